@@ -2,41 +2,383 @@
 
 package miscreant
 
-import "crypto/cipher"
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strconv"
+)
 
-// aead is a wrapper for Cipher implementing cipher.AEAD interface.
+// MaxNonceSize is the largest nonce size the AEAD constructors accept. S2V
+// hashes the nonce, so any length up to it is equally secure; 12 bytes matches
+// AES-GCM and 16 bytes is the size generated by NewAEADAESRandomNonce.
+const MaxNonceSize = 64
+
+// AnyNonceSize is the nonce size which makes an AEAD accept nonces of any
+// length, rather than of one fixed length.
+const AnyNonceSize = -1
+
+var (
+	ErrNonceSize  = errors.New("siv: nonce size must be from 0 to 64 bytes")
+	ErrNonceOrder = errors.New("siv: invalid nonce order")
+	ErrEmptyAD    = errors.New("siv: invalid empty associated data encoding")
+
+	// ErrNonceLength is returned by the methods of the AEADs in this package
+	// other than Seal, which panics instead, for a nonce of the wrong size.
+	ErrNonceLength = errors.New("siv: incorrect nonce length")
+)
+
+// AEAD is implemented by the cipher.AEAD returned by NewAEADAES and the other
+// constructors of this package wrapping a Cipher, which return it as a
+// cipher.AEAD so that they can be used as an AEADConstructor. Assert to AEAD
+// to reach the methods beyond cipher.AEAD:
+//
+//	nonce, err := a.(miscreant.AEAD).NewNonce()
+//
+// The AEADs of NewAEADAESRandomNonce, NewAEADAESCommitting and
+// NewNonceAuditAEAD don't implement it.
+type AEAD interface {
+	cipher.AEAD
+
+	// Algorithm returns the name of the algorithm of the underlying Cipher.
+	Algorithm() Algorithm
+
+	// NewNonce generates a random nonce of the size the AEAD takes.
+	NewNonce() ([]byte, error)
+
+	// Verify authenticates a ciphertext without returning its plaintext.
+	Verify(nonce, ciphertext, data []byte) error
+
+	// SealDetached and OpenDetached keep the synthetic IV apart from the
+	// ciphertext, as the methods of Cipher with those names do.
+	SealDetached(dst, nonce, plaintext, data []byte) (ciphertext []byte, tag [TagSize]byte, err error)
+	OpenDetached(dst, nonce, ciphertext, tag, data []byte) ([]byte, error)
+
+	// SealInto and OpenInto write to the start of a preallocated buffer
+	// instead of appending, as the methods of Cipher with those names do.
+	SealInto(dst, nonce, plaintext, data []byte) (n int, err error)
+	OpenInto(dst, nonce, ciphertext, data []byte) (n int, err error)
+
+	// Clone returns an AEAD which can be Reset independently, as
+	// Cipher.Clone does.
+	Clone() (AEAD, error)
+
+	// Reset wipes the key material of the underlying Cipher.
+	Reset()
+}
+
+var _ AEAD = (*aead)(nil)
+
+// aead is a wrapper for Cipher implementing the AEAD interface.
 type aead struct {
-	c         *Cipher
-	nonceSize int
+	c          *Cipher
+	nonceSize  int
+	nonceFirst bool
+	emptyAD    EmptyADEncoding
 }
 
+// NonceOrder selects where an AEAD places the nonce among the S2V inputs,
+// relative to the associated data passed to Seal and Open.
+type NonceOrder int
+
+const (
+	// NonceLast passes the associated data to S2V before the nonce, as
+	// RFC 5297 section 3 recommends. It is the order used by NewAEADAES and
+	// the other Miscreant implementations.
+	NonceLast NonceOrder = iota
+
+	// NonceFirst passes the nonce to S2V before the associated data, for
+	// protocols which specify that order.
+	NonceFirst
+)
+
+// EmptyADEncoding selects how an AEAD passes empty associated data to S2V.
+// RFC 5297 distinguishes a vector with no associated data item from one
+// with a single empty item, and implementations differ over which of the two
+// empty associated data given to an AEAD interface means, so ciphertexts
+// from one only open with the other if the choice is matched.
+type EmptyADEncoding int
+
+const (
+	// OmitNilAD leaves nil associated data out of the S2V inputs, and passes
+	// non-nil empty associated data as an empty item. It is the encoding
+	// used by NewAEADAES, as it has been since the first release.
+	OmitNilAD EmptyADEncoding = iota
+
+	// OmitEmptyAD leaves empty associated data, whether nil or not, out of
+	// the S2V inputs, so that only the nonce is passed, as the AEADs in
+	// crypto/cipher treat nil and empty associated data alike.
+	OmitEmptyAD
+
+	// IncludeEmptyAD passes empty associated data to S2V as an empty item,
+	// even when it is nil, as it does any other associated data. The
+	// Python, Ruby, JavaScript and Rust implementations of Miscreant take
+	// the S2V inputs as a list, and match IncludeEmptyAD when given
+	// [associated_data, nonce] even for empty associated_data, and
+	// OmitEmptyAD when given [nonce] alone. STREAM always passes each
+	// segment's associated data this way.
+	IncludeEmptyAD
+)
+
 // NewAEADAES returns an AES-SIV instance implementing cipher.AEAD interface,
 // with the given nonce size and a key which must be twice as long as an AES key,
 // either 32, 48, or 64 bytes to select AES-128 (AES-SIV-CMAC-256), AES-192
 // (AES-SIV-CMAC-384), or AES-256 (AES-SIV-CMAC-512).
 //
-// Unless the given nonce size is less than zero, Seal and Open will panic when
-// passed nonce of a different size.
+// The nonce size may be from zero to MaxNonceSize bytes, or AnyNonceSize,
+// and ErrNonceSize is returned for any other. Seal panics when passed a nonce
+// of a different size, as it can't return an error, as crypto/cipher's GCM
+// does, and Open returns ErrNonceLength. With AnyNonceSize, nonces of any
+// length are accepted, and each length gives different ciphertexts, so both
+// sides must agree on it.
+//
+// The nonce is the last S2V input before the plaintext, after the associated
+// data, as RFC 5297 section 3 recommends (see NonceOrder).
+//
+// A nonce size of zero selects deterministic encryption as described in
+// RFC 5297 section 3: no nonce is passed to S2V at all, so the same
+// plaintext and associated data always produce the same ciphertext.
+// This is a wire-format break from 0.1.0, which passed the empty nonce to S2V
+// as an empty item: messages sealed by 0.1.0 with a nonce size of zero open
+// with Cipher.Open given the associated data, if any, followed by an empty
+// item, as in c.Open(nil, ciphertext, data, []byte{}).
+//
+// The associated data is passed to S2V as a single item, unless it is nil,
+// when it is left out: Seal with nil associated data gives the same
+// ciphertext as Cipher.Seal given only the nonce, while non-nil empty
+// associated data is an empty item. See EmptyADEncoding and
+// NewAEADAESWithOptions for the alternatives.
+//
+// The returned cipher.AEAD implements AEAD, which adds NewNonce, Verify,
+// detached and in-place sealing and opening, Clone and Reset.
 func NewAEADAES(key []byte, nonceSize int) (cipher.AEAD, error) {
 	c, err := NewAES(key)
 	if err != nil {
 		return nil, err
 	}
+	return newAEAD(c, nonceSize)
+}
+
+// NewAEADAESKeys is like NewAEADAES, with the two halves of the AES-SIV key
+// given separately, for keys derived independently: macKey is used for S2V
+// and encKey for CTR mode, so the result is the same as that of NewAEADAES
+// for the concatenation of macKey and encKey. Each must be an AES key of 16,
+// 24, or 32 bytes, or an aes.KeySizeError is returned, and both must be the
+// same length, or ErrKeySize is returned.
+func NewAEADAESKeys(macKey, encKey []byte, nonceSize int) (cipher.AEAD, error) {
+	macBlock, err := aes.NewCipher(macKey)
+	if err != nil {
+		return nil, err
+	}
+	ctrBlock, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(macKey) != len(encKey) {
+		return nil, ErrKeySize
+	}
+	c, err := NewSIV(macBlock, ctrBlock)
+	if err != nil {
+		return nil, err
+	}
+	c.aesCTR = true
+	return newAEAD(c, nonceSize)
+}
+
+// AEADOptions selects how an AEAD passes the nonce and associated data to
+// S2V. The zero value selects the encoding NewAEADAES uses.
+type AEADOptions struct {
+	// NonceOrder places the nonce among the S2V inputs. NewAEADAES uses
+	// NonceLast.
+	NonceOrder NonceOrder
+
+	// EmptyAD selects how empty associated data is passed to S2V.
+	// NewAEADAES uses OmitNilAD.
+	EmptyAD EmptyADEncoding
+}
+
+// NewAEADAESWithOptions is like NewAEADAES, with the nonce and associated
+// data passed to S2V as opts selects. It returns ErrNonceOrder or ErrEmptyAD
+// for options it doesn't know.
+//
+// The options are part of the ciphertext format: S2V authenticates its inputs
+// as an ordered vector, so a ciphertext sealed with one order only opens with
+// the same order, and interoperating with another implementation requires
+// matching its order and its treatment of empty associated data. Without
+// associated data, or with a nonce size of zero, both orders give the same
+// result, and the EmptyADEncoding only matters for empty associated data.
+func NewAEADAESWithOptions(key []byte, nonceSize int, opts AEADOptions) (cipher.AEAD, error) {
+	if opts.NonceOrder != NonceLast && opts.NonceOrder != NonceFirst {
+		return nil, ErrNonceOrder
+	}
+	if opts.EmptyAD != OmitNilAD && opts.EmptyAD != OmitEmptyAD && opts.EmptyAD != IncludeEmptyAD {
+		return nil, ErrEmptyAD
+	}
+	a, err := NewAEADAES(key, nonceSize)
+	if err != nil {
+		return nil, err
+	}
+	a.(*aead).nonceFirst = opts.NonceOrder == NonceFirst
+	a.(*aead).emptyAD = opts.EmptyAD
+	return a, nil
+}
+
+// NewAEAD returns an SIV instance implementing cipher.AEAD interface like
+// NewAEADAES, with block ciphers made by newBlock in place of crypto/aes, such
+// as one backed by an HSM. The key is split in half, and newBlock is called
+// with each half to make the block ciphers for S2V and CTR mode respectively.
+// It returns ErrKeySize if the key can't be split evenly, any error from
+// newBlock, and ErrBlockSize unless both blocks are 16 bytes.
+func NewAEAD(newBlock func([]byte) (cipher.Block, error), key []byte, nonceSize int) (cipher.AEAD, error) {
+	macBlock, ctrBlock, err := newBlocks(newBlock, key)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewSIV(macBlock, ctrBlock)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(c, nonceSize)
+}
+
+// NewAEADAESPMACSIV returns an AES-PMAC-SIV instance implementing
+// cipher.AEAD interface, with the given nonce size and a key which must be
+// twice as long as an AES key, either 32, 48, or 64 bytes to select AES-128,
+// AES-192, or AES-256. It is a drop-in replacement for NewAEADAES.
+//
+// The nonce size is checked as for NewAEADAES, and as with NewAEADAES, a
+// nonce size of zero selects deterministic encryption.
+func NewAEADAESPMACSIV(key []byte, nonceSize int) (cipher.AEAD, error) {
+	c, err := NewPMACSIV(key)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(c, nonceSize)
+}
+
+// NewAEADPMAC is shorthand for NewAEADAESPMACSIV.
+func NewAEADPMAC(key []byte, nonceSize int) (cipher.AEAD, error) {
+	return NewAEADAESPMACSIV(key, nonceSize)
+}
+
+// newAEAD wraps c as a cipher.AEAD taking nonces of nonceSize bytes.
+func newAEAD(c *Cipher, nonceSize int) (cipher.AEAD, error) {
+	if nonceSize > MaxNonceSize || nonceSize < AnyNonceSize {
+		return nil, ErrNonceSize
+	}
 	return &aead{c: c, nonceSize: nonceSize}, nil
 }
 
+// checkNonce returns ErrNonceLength unless nonce is of the size the AEAD was
+// constructed with.
+func (a *aead) checkNonce(nonce []byte) error {
+	if len(nonce) != a.nonceSize && a.nonceSize != AnyNonceSize {
+		return ErrNonceLength
+	}
+	return nil
+}
+
+// Algorithm returns the name of the algorithm of the underlying Cipher.
+func (a *aead) Algorithm() Algorithm { return a.c.Algorithm() }
+
+// NonceSize returns the nonce size the AEAD was constructed with.
 func (a *aead) NonceSize() int { return a.nonceSize }
-func (a *aead) Overhead() int  { return a.c.Overhead() }
 
-func (a *aead) Seal(dst, nonce, plaintext, data []byte) (out []byte) {
-	if len(nonce) != a.nonceSize && a.nonceSize >= 0 {
-		panic("siv.AEAD: incorrect nonce length")
+// Overhead returns the size of the synthetic IV prepended to each ciphertext,
+// which doesn't depend on the nonce size.
+func (a *aead) Overhead() int { return a.c.Overhead() }
+
+// Reset wipes the key material of the underlying Cipher. See Cipher.Reset.
+func (a *aead) Reset() { a.c.Reset() }
+
+// Clone returns an AEAD like a, over a Clone of the underlying Cipher, so
+// that either can be Reset without affecting the other. The two share no
+// mutable state, only the block ciphers' key schedules. See Cipher.Clone.
+func (a *aead) Clone() (AEAD, error) {
+	c, err := a.c.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return &aead{c: c, nonceSize: a.nonceSize, nonceFirst: a.nonceFirst, emptyAD: a.emptyAD}, nil
+}
+
+// NewNonce returns a nonce of NonceSize() bytes read from crypto/rand, ready
+// to be passed to Seal, or a 16-byte nonce if the AEAD accepts any size. Any
+// error from crypto/rand.Read is returned as is.
+func (a *aead) NewNonce() ([]byte, error) {
+	n := a.nonceSize
+	if n == AnyNonceSize {
+		n = randomNonceSize
+	}
+	nonce := make([]byte, n)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// omitData reports whether data is left out of the S2V inputs, as the
+// EmptyADEncoding of the AEAD selects.
+func (a *aead) omitData(data []byte) bool {
+	switch a.emptyAD {
+	case OmitEmptyAD:
+		return len(data) == 0
+	case IncludeEmptyAD:
+		return false
 	}
+	return data == nil
+}
+
+// items returns the S2V inputs for nonce and data in the order the AEAD was
+// constructed with, using buf to hold them. data isn't an input if the
+// AEAD's EmptyADEncoding leaves it out.
+func (a *aead) items(buf *[2][]byte, nonce, data []byte) [][]byte {
+	omit := a.omitData(data)
+	switch {
+	case a.nonceSize == 0 && omit:
+		return buf[:0]
+	case a.nonceSize == 0:
+		buf[0] = data
+		return buf[:1]
+	case omit:
+		buf[0] = nonce
+		return buf[:1]
+	case a.nonceFirst:
+		buf[0], buf[1] = nonce, data
+	default:
+		buf[0], buf[1] = data, nonce
+	}
+	return buf[:]
+}
+
+// pair returns the two S2V items for nonce and data in order, if there are
+// both, for Cipher.sealPair and Cipher.openPair.
+func (a *aead) pair(nonce, data []byte) (x, y []byte, ok bool) {
+	switch {
+	case a.nonceSize == 0 || a.omitData(data):
+		return nil, nil, false
+	case a.nonceFirst:
+		return nonce, data, true
+	}
+	return data, nonce, true
+}
+
+// Seal encrypts and authenticates plaintext as Cipher.Seal does. To encrypt
+// in place, pass plaintext[:0] as dst: the ciphertext is written over the
+// plaintext with the synthetic IV in front of it.
+func (a *aead) Seal(dst, nonce, plaintext, data []byte) []byte {
+	if err := a.checkNonce(nonce); err != nil {
+		panic("siv.AEAD: incorrect nonce length " + strconv.Itoa(len(nonce)) + ", expected " + strconv.Itoa(a.nonceSize))
+	}
+	var out []byte
 	var err error
-	if data == nil {
-		out, err = a.c.Seal(dst, plaintext, nonce)
+	if x, y, ok := a.pair(nonce, data); ok {
+		out, err = a.c.sealPair(dst, plaintext, x, y)
 	} else {
-		out, err = a.c.Seal(dst, plaintext, data, nonce)
+		var buf [2][]byte
+		out, err = a.c.Seal(dst, plaintext, a.items(&buf, nonce, data)...)
 	}
 	if err != nil {
 		panic("siv.AEAD: " + err.Error())
@@ -44,12 +386,199 @@ func (a *aead) Seal(dst, nonce, plaintext, data []byte) (out []byte) {
 	return out
 }
 
+// Open decrypts and authenticates ciphertext as Cipher.Open does. To decrypt
+// in place, pass ciphertext[:0] as dst.
 func (a *aead) Open(dst, nonce, ciphertext, data []byte) ([]byte, error) {
-	if len(nonce) != a.nonceSize && a.nonceSize >= 0 {
-		panic("siv.AEAD: incorrect nonce length")
+	if err := a.checkNonce(nonce); err != nil {
+		return nil, err
+	}
+	if x, y, ok := a.pair(nonce, data); ok {
+		return a.c.openPair(dst, ciphertext, x, y)
+	}
+	var buf [2][]byte
+	return a.c.Open(dst, ciphertext, a.items(&buf, nonce, data)...)
+}
+
+// SealInto is like Seal, but writes the ciphertext to the start of dst and
+// returns its length, as Cipher.SealInto does. It returns ErrShortBuffer if
+// dst is shorter than len(plaintext)+Overhead(), and ErrNonceLength for a
+// nonce of the wrong size, rather than panicking.
+func (a *aead) SealInto(dst, nonce, plaintext, data []byte) (n int, err error) {
+	if err := a.checkNonce(nonce); err != nil {
+		return 0, err
+	}
+	if err := checkSealSize(0, a.Overhead(), len(plaintext)); err != nil {
+		return 0, err
+	}
+	if len(dst) < len(plaintext)+a.Overhead() {
+		return 0, ErrShortBuffer
 	}
+	var out []byte
+	if x, y, ok := a.pair(nonce, data); ok {
+		out, err = a.c.sealPair(dst[:0], plaintext, x, y)
+	} else {
+		var buf [2][]byte
+		out, err = a.c.Seal(dst[:0], plaintext, a.items(&buf, nonce, data)...)
+	}
+	return len(out), err
+}
+
+// OpenInto is like Open, but writes the plaintext to the start of dst and
+// returns its length, as Cipher.OpenInto does. It returns ErrShortBuffer if
+// dst is shorter than len(ciphertext)-Overhead().
+func (a *aead) OpenInto(dst, nonce, ciphertext, data []byte) (n int, err error) {
+	if err := a.checkNonce(nonce); err != nil {
+		return 0, err
+	}
+	if len(ciphertext) < a.Overhead() {
+		return 0, ErrTooShort
+	}
+	if len(dst) < len(ciphertext)-a.Overhead() {
+		return 0, ErrShortBuffer
+	}
+	out, err := a.Open(dst[:0], nonce, ciphertext, data)
+	return len(out), err
+}
+
+// SealDetached is like Seal, but returns the synthetic IV separately from the
+// ciphertext, as Cipher.SealDetached does. The IV followed by the ciphertext
+// is what Seal would produce.
+func (a *aead) SealDetached(dst, nonce, plaintext, data []byte) (ciphertext []byte, tag [TagSize]byte, err error) {
+	if err := a.checkNonce(nonce); err != nil {
+		return nil, tag, err
+	}
+	var buf [2][]byte
+	return a.c.SealDetached(dst, plaintext, a.items(&buf, nonce, data)...)
+}
+
+// OpenDetached opens a ciphertext and synthetic IV produced by SealDetached,
+// as Cipher.OpenDetached does.
+func (a *aead) OpenDetached(dst, nonce, ciphertext, tag, data []byte) ([]byte, error) {
+	if err := a.checkNonce(nonce); err != nil {
+		return nil, err
+	}
+	var buf [2][]byte
+	return a.c.OpenDetached(dst, ciphertext, tag, a.items(&buf, nonce, data)...)
+}
+
+// Verify reports whether ciphertext is authentic for the given nonce and
+// associated data, returning nil if Open would succeed and the error it would
+// return otherwise, without returning the plaintext. See Cipher.Verify, which
+// explains why it is no faster than Open.
+func (a *aead) Verify(nonce, ciphertext, data []byte) error {
+	if err := a.checkNonce(nonce); err != nil {
+		return err
+	}
+	var buf [2][]byte
+	return a.c.Verify(ciphertext, a.items(&buf, nonce, data)...)
+}
+
+// randomNonceSize is the size of the nonces generated by the AEAD returned
+// by NewAEADAESRandomNonce.
+const randomNonceSize = 16
+
+// randomNonceAEAD is a cipher.AEAD which generates its own nonces and
+// prepends them to the ciphertext.
+type randomNonceAEAD struct {
+	c *Cipher
+}
+
+// NewAEADAESRandomNonce returns an AES-SIV instance implementing cipher.AEAD
+// interface which draws a fresh 16-byte nonce from crypto/rand for each call
+// to Seal and prepends it to the ciphertext, so callers needn't manage nonces.
+// The key is as for NewAEADAES.
+//
+// NonceSize returns zero, and the nonce passed to Seal and Open is ignored.
+// Overhead accounts for both the embedded nonce and the synthetic IV.
+func NewAEADAESRandomNonce(key []byte) (cipher.AEAD, error) {
+	c, err := NewAES(key)
+	if err != nil {
+		return nil, err
+	}
+	return &randomNonceAEAD{c: c}, nil
+}
+
+// Algorithm returns the name of the algorithm of the underlying Cipher.
+func (a *randomNonceAEAD) Algorithm() Algorithm { return a.c.Algorithm() }
+
+// NonceSize returns zero, as no nonce needs to be passed to Seal or Open.
+func (a *randomNonceAEAD) NonceSize() int { return 0 }
+
+// Overhead returns the size of the nonce and synthetic IV prepended to each
+// ciphertext.
+func (a *randomNonceAEAD) Overhead() int { return randomNonceSize + a.c.Overhead() }
+
+// Reset wipes the key material of the underlying Cipher. See Cipher.Reset.
+func (a *randomNonceAEAD) Reset() { a.c.Reset() }
+
+// Seal encrypts and authenticates plaintext under a random nonce and appends
+// the nonce followed by the ciphertext to dst. dst must not overlap plaintext.
+func (a *randomNonceAEAD) Seal(dst, _, plaintext, data []byte) (out []byte) {
+	if err := checkSealSize(len(dst), a.Overhead(), len(plaintext)); err != nil {
+		panic("siv.AEAD: " + err.Error())
+	}
+	head, tail := sliceForAppend(dst, a.Overhead()+len(plaintext))
+	if anyOverlap(tail, plaintext) {
+		panic("siv.AEAD: invalid buffer overlap")
+	}
+	nonce := tail[:randomNonceSize]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic("siv.AEAD: failed to generate nonce: " + err.Error())
+	}
+	// head has room for the rest, so Cipher.Seal doesn't allocate again
+	head = head[:len(dst)+randomNonceSize]
+	var err error
+	if data == nil {
+		out, err = a.c.Seal(head, plaintext, nonce)
+	} else {
+		out, err = a.c.Seal(head, plaintext, data, nonce)
+	}
+	if err != nil {
+		panic("siv.AEAD: " + err.Error())
+	}
+	return out
+}
+
+// Open decrypts and authenticates ciphertext produced by Seal, using the
+// nonce at its start. It returns ErrTooShort if ciphertext is shorter than
+// Overhead(). To decrypt in place, pass ciphertext[16:16] as dst.
+func (a *randomNonceAEAD) Open(dst, _, ciphertext, data []byte) ([]byte, error) {
+	if len(ciphertext) < a.Overhead() {
+		return nil, ErrTooShort
+	}
+	nonce, ciphertext := ciphertext[:randomNonceSize], ciphertext[randomNonceSize:]
 	if data == nil {
 		return a.c.Open(dst, ciphertext, nonce)
 	}
 	return a.c.Open(dst, ciphertext, data, nonce)
 }
+
+// EncryptAES seals plaintext with AES-SIV in a single call, as the AEAD
+// returned by NewAEADAES(key, len(nonce)) would, so the result can be opened
+// by either. The cipher is Reset before EncryptAES returns, wiping what key
+// material it can (see Cipher.Reset). A nil or empty nonce selects
+// deterministic encryption. Invalid keys, nonces and
+// plaintexts are rejected with the errors NewAEADAES and Cipher.Seal return.
+func EncryptAES(key, nonce, plaintext, aad []byte) ([]byte, error) {
+	if err := checkSealSize(0, TagSize, len(plaintext)); err != nil {
+		return nil, err
+	}
+	a, err := NewAEADAES(key, len(nonce))
+	if err != nil {
+		return nil, err
+	}
+	defer a.(*aead).Reset()
+	return a.Seal(nil, nonce, plaintext, aad), nil
+}
+
+// DecryptAES opens ciphertext produced by EncryptAES, or by the AEAD returned
+// by NewAEADAES(key, len(nonce)), in a single call. As with EncryptAES, the
+// cipher is Reset before DecryptAES returns.
+func DecryptAES(key, nonce, ciphertext, aad []byte) ([]byte, error) {
+	a, err := NewAEADAES(key, len(nonce))
+	if err != nil {
+		return nil, err
+	}
+	defer a.(*aead).Reset()
+	return a.Open(nil, nonce, ciphertext, aad)
+}
@@ -4,13 +4,58 @@ package miscreant
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 )
 
+// testAEAD checks every vector which fits the cipher.AEAD interface, i.e.
+// those with a nonce as the last associated data item and at most one other.
+func testAEAD(t *testing.T, newAEAD AEADConstructor, vectors []testVector) {
+	for i, v := range vectors {
+		if len(v.adata) == 0 || len(v.adata) > 2 {
+			continue
+		}
+		key := decode(v.key)
+		nonce := decode(v.adata[len(v.adata)-1])
+		c, err := newAEAD(key, len(nonce))
+		if err != nil {
+			t.Fatalf("newAEAD: %d: %s", i, err)
+		}
+		var ad []byte
+		if len(v.adata) == 2 {
+			ad = decode(v.adata[0])
+		}
+		gpt, gct := decode(v.plaintext), decode(v.output)
+		ct := c.Seal(nil, nonce, gpt, ad)
+		if !bytes.Equal(gct, ct) {
+			t.Errorf("Seal: %d: %d byte key: expected: %x\ngot: %x", i, len(key), gct, ct)
+		}
+		pt, err := c.Open(nil, nonce, ct, ad)
+		if err != nil {
+			t.Errorf("Open: %d: %d byte key: %s", i, len(key), err)
+		}
+		if !bytes.Equal(gpt, pt) {
+			t.Errorf("Open: %d: %d byte key: expected: %x\ngot: %x", i, len(key), gpt, pt)
+		}
+	}
+}
+
 func TestAEADAES(t *testing.T) {
-	v := testVectors[0]
+	testAEAD(t, NewAEADAES, testVectors)
+}
+
+func TestAEADPMAC(t *testing.T) {
+	v := pmacTestVectors[0]
 	nonce := decode(v.adata[0])
-	c, err := NewAEADAES(decode(v.key), len(nonce))
+	c, err := NewAEADPMAC(decode(v.key), len(nonce))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -27,3 +72,1101 @@ func TestAEADAES(t *testing.T) {
 		t.Errorf("Open: expected: %x\ngot: %x", gpt, pt)
 	}
 }
+
+func TestAEADCrossOpen(t *testing.T) {
+	v := testVectors[0]
+	key, nonce, pt := decode(v.key), decode(v.adata[0]), decode(v.plaintext)
+	c1, err := NewAEADAES(key, len(nonce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := NewAEADPMAC(key, len(nonce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.Open(nil, nonce, c1.Seal(nil, nonce, pt, nil), nil); err != ErrNotAuthentic {
+		t.Errorf("PMAC-SIV opened CMAC-SIV ciphertext: %v", err)
+	}
+	if _, err := c1.Open(nil, nonce, c2.Seal(nil, nonce, pt, nil), nil); err != ErrNotAuthentic {
+		t.Errorf("CMAC-SIV opened PMAC-SIV ciphertext: %v", err)
+	}
+}
+
+func TestAEADNonceOrder(t *testing.T) {
+	// RFC 5297 A.2 with AD1 as the header
+	v := testVectors[1]
+	key, header, nonce, pt := decode(v.key), decode(v.adata[0]), decode(v.adata[2]), decode(v.plaintext)
+	for _, tc := range []struct {
+		order NonceOrder
+		// S2V(header, nonce, plaintext) and S2V(nonce, header, plaintext)
+		output string
+	}{
+		{NonceLast, "85825e22 e90cf2dd da2c548d c7c1b631 0dcdaca0 cebf9dc6 cb90583f 5bf1506e 02cd4883 2b00e4e5 98b2b22a 53e6199d 4df0c166 6a35a043 3b250dc1 34d776"},
+		{NonceFirst, "2eb54e91 c7ff66e5 68c974ff 7e45dae6 92cc57d4 7f510e09 04a3ff57 3dccf0eb 0e9064ef b1e78716 bc1c81a3 5e405086 9d13920b 0f01df87 b332a77b 55c4b0"},
+	} {
+		a, err := NewAEADAESWithOptions(key, len(nonce), AEADOptions{NonceOrder: tc.order})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct := a.Seal(nil, nonce, pt, header)
+		if !bytes.Equal(decode(tc.output), ct) {
+			t.Errorf("Seal: order %d: expected: %s\ngot: %x", tc.order, tc.output, ct)
+		}
+		if out, err := a.Open(nil, nonce, ct, header); err != nil || !bytes.Equal(out, pt) {
+			t.Errorf("Open: order %d: %x (%v)", tc.order, out, err)
+		}
+	}
+
+	// The default order is NonceLast, and the orders don't open each other
+	last, _ := NewAEADAESWithOptions(key, len(nonce), AEADOptions{NonceOrder: NonceLast})
+	first, _ := NewAEADAESWithOptions(key, len(nonce), AEADOptions{NonceOrder: NonceFirst})
+	def, _ := NewAEADAES(key, len(nonce))
+	if !bytes.Equal(def.Seal(nil, nonce, pt, header), last.Seal(nil, nonce, pt, header)) {
+		t.Errorf("NewAEADAES doesn't use NonceLast")
+	}
+	if _, err := first.Open(nil, nonce, last.Seal(nil, nonce, pt, header), header); err != ErrAuthFailed {
+		t.Errorf("NonceFirst opened a NonceLast ciphertext: %v", err)
+	}
+
+	// Without associated data, the order makes no difference
+	if !bytes.Equal(first.Seal(nil, nonce, pt, nil), last.Seal(nil, nonce, pt, nil)) {
+		t.Errorf("Seal: the order changed a ciphertext without associated data")
+	}
+
+	if _, err := NewAEADAESWithOptions(key, 16, AEADOptions{NonceOrder: NonceOrder(2)}); err != ErrNonceOrder {
+		t.Errorf("NewAEADAESWithOptions: expected ErrNonceOrder, got %v", err)
+	}
+}
+
+// TestAEADPair checks the path the AEAD takes when given both a nonce and
+// associated data against Cipher.Seal and Cipher.Open with the same items,
+// for random inputs.
+func TestAEADPair(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		r.Read(b)
+		return b
+	}
+	for _, tc := range []struct {
+		name      string
+		newCipher func(key []byte) (*Cipher, error)
+		newAEAD   func(key []byte, order NonceOrder) (cipher.AEAD, error)
+	}{
+		{"AES-SIV", NewAES, func(key []byte, order NonceOrder) (cipher.AEAD, error) {
+			return NewAEADAESWithOptions(key, 16, AEADOptions{NonceOrder: order})
+		}},
+		{"AES-PMAC-SIV", NewPMACSIV, func(key []byte, _ NonceOrder) (cipher.AEAD, error) {
+			return NewAEADAESPMACSIV(key, 16)
+		}},
+	} {
+		for i := 0; i < 500; i++ {
+			key := random([]int{32, 48, 64}[r.Intn(3)])
+			order := NonceOrder(r.Intn(2))
+			if tc.name != "AES-SIV" {
+				order = NonceLast
+			}
+			nonce, ad, pt := random(16), random(r.Intn(40)), random(r.Intn(65))
+			c, _ := tc.newCipher(key)
+			a, err := tc.newAEAD(key, order)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			items := [][]byte{ad, nonce}
+			switch {
+			case ad == nil:
+				items = items[1:]
+			case order == NonceFirst:
+				items[0], items[1] = nonce, ad
+			}
+			want, _ := c.Seal(nil, pt, items...)
+			dst := random(r.Intn(20))
+			ct := a.Seal(dst[:len(dst):len(dst)], nonce, pt, ad)
+			if !bytes.Equal(ct[:len(dst)], dst) || !bytes.Equal(ct[len(dst):], want) {
+				t.Fatalf("%s: Seal: key %x, order %d, nonce %x, ad %x, plaintext %x: expected: %x\ngot: %x", tc.name, key, order, nonce, ad, pt, want, ct[len(dst):])
+			}
+			if out, err := a.Open(nil, nonce, want, ad); err != nil || !bytes.Equal(out, pt) {
+				t.Fatalf("%s: Open: expected: %x\ngot: %x (%v)", tc.name, pt, out, err)
+			}
+
+			bad := append([]byte(nil), want...)
+			bad[r.Intn(len(bad))] ^= 1 << uint(r.Intn(8))
+			_, wantErr := c.Open(nil, bad, items...)
+			if _, err := a.Open(nil, nonce, bad, ad); err != wantErr || err == nil {
+				t.Fatalf("%s: Open: modified ciphertext: expected %v, got %v", tc.name, wantErr, err)
+			}
+		}
+	}
+}
+
+// TestAEADNilAndEmpty checks nil, empty and non-empty plaintexts and
+// associated data against each other, for each kind of nonce and each
+// EmptyADEncoding.
+func TestAEADNilAndEmpty(t *testing.T) {
+	key := make([]byte, 32)
+	c, _ := NewAES(key)
+	values := []struct {
+		name string
+		b    []byte
+	}{
+		{"nil", nil},
+		{"empty", []byte{}},
+		{"non-empty", []byte("x")},
+	}
+	// included reports whether enc passes ad to S2V as an item
+	included := func(enc EmptyADEncoding, ad []byte) bool {
+		switch enc {
+		case OmitEmptyAD:
+			return len(ad) > 0
+		case IncludeEmptyAD:
+			return true
+		}
+		return ad != nil
+	}
+	for _, enc := range []EmptyADEncoding{OmitNilAD, OmitEmptyAD, IncludeEmptyAD} {
+		for _, nonceSize := range []int{0, 1, 16, -1} {
+			a, err := NewAEADAESWithOptions(key, nonceSize, AEADOptions{EmptyAD: enc})
+			if err != nil {
+				t.Fatal(err)
+			}
+			nonce := make([]byte, 16)
+			if nonceSize >= 0 {
+				nonce = nonce[:nonceSize]
+			}
+			for _, pt := range values {
+				for _, ad := range values {
+					name := "encoding " + strconv.Itoa(int(enc)) + ", nonce size " + strconv.Itoa(nonceSize) + ": " + pt.name + " plaintext, " + ad.name + " associated data"
+
+					// An empty nonce is an S2V item only if nonces are of
+					// variable size
+					var items [][]byte
+					if included(enc, ad.b) {
+						items = append(items, ad.b)
+					}
+					if nonceSize != 0 {
+						items = append(items, nonce)
+					}
+					want, _ := c.Seal(nil, pt.b, items...)
+					ct := a.Seal(nil, nonce, pt.b, ad.b)
+					if !bytes.Equal(ct, want) || len(ct) != a.Overhead()+len(pt.b) {
+						t.Errorf("Seal: %s: expected: %x\ngot: %x", name, want, ct)
+					}
+
+					for _, other := range values {
+						out, err := a.Open(nil, nonce, ct, other.b)
+						same := included(enc, other.b) == included(enc, ad.b) && bytes.Equal(other.b, ad.b)
+						if same && (err != nil || out == nil || !bytes.Equal(out, pt.b)) {
+							t.Errorf("Open: %s, opened with %s associated data: %#v (%v)", name, other.name, out, err)
+						}
+						if !same && err != ErrAuthFailed {
+							t.Errorf("Open: %s, opened with %s associated data: expected ErrAuthFailed, got %v", name, other.name, err)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// The random-nonce AEAD leaves out only nil associated data, as NewAEADAES
+	r, _ := NewAEADAESRandomNonce(key)
+	for _, ad := range [][]byte{nil, {}} {
+		ct := r.Seal(nil, nil, nil, ad)
+		for _, other := range [][]byte{nil, {}} {
+			out, err := r.Open(nil, nil, ct, other)
+			if (ad == nil) == (other == nil) && (err != nil || out == nil || len(out) != 0) {
+				t.Errorf("random nonce: Open: %#v (%v)", out, err)
+			}
+			if (ad == nil) != (other == nil) && err != ErrAuthFailed {
+				t.Errorf("random nonce: Open: nil and empty associated data: expected ErrAuthFailed, got %v", err)
+			}
+		}
+	}
+}
+
+func TestAEADEmptyADEncoding(t *testing.T) {
+	// The last two examples of testdata/aes_siv.tjson
+	key := decode("7f7e7d7c 7b7a7978 77767574 73727170 40414243 44454647 48494a4b 4c4d4e4f")
+	nonce := decode("09f91102 9d74e35b d84156c5 635688c0")
+	pt := decode("11223344 55667788 99aabbcc ddee")
+	omitted := decode("21a08cd2 d9a3dc13 a90b9b79 ded695c6 d4994631 2b979623 ebe7db61 04df")
+	included := decode("78265c42 00ada637 b204fe8e f82fdeb1 afcfd4af 75beae5c 05093e6c 1407")
+
+	c, _ := NewAES(key)
+	if ct, _ := c.Seal(nil, pt, nonce); !bytes.Equal(ct, omitted) {
+		t.Fatalf("Seal: [nonce]: expected: %x\ngot: %x", omitted, ct)
+	}
+	if ct, _ := c.Seal(nil, pt, []byte{}, nonce); !bytes.Equal(ct, included) {
+		t.Fatalf("Seal: [\"\", nonce]: expected: %x\ngot: %x", included, ct)
+	}
+	for _, tt := range []struct {
+		enc              EmptyADEncoding
+		forNil, forEmpty []byte
+	}{
+		{OmitNilAD, omitted, included},
+		{OmitEmptyAD, omitted, omitted},
+		{IncludeEmptyAD, included, included},
+	} {
+		a, err := NewAEADAESWithOptions(key, len(nonce), AEADOptions{EmptyAD: tt.enc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clone, _ := a.(AEAD).Clone()
+		for _, x := range []cipher.AEAD{a, clone} {
+			for _, ad := range [][]byte{nil, {}} {
+				want, other := tt.forEmpty, tt.forNil
+				if ad == nil {
+					want, other = tt.forNil, tt.forEmpty
+				}
+				if ct := x.Seal(nil, nonce, pt, ad); !bytes.Equal(ct, want) {
+					t.Errorf("Seal: encoding %d: %#v: expected: %x\ngot: %x", tt.enc, ad, want, ct)
+				}
+				if out, err := x.Open(nil, nonce, want, ad); err != nil || !bytes.Equal(out, pt) {
+					t.Errorf("Open: encoding %d: %#v: %x (%v)", tt.enc, ad, out, err)
+				}
+				// Each only opens its own encoding
+				if _, err := x.Open(nil, nonce, other, ad); !bytes.Equal(other, want) && err != ErrNotAuthentic {
+					t.Errorf("Open: encoding %d: %#v: the other encoding: expected ErrNotAuthentic, got %v", tt.enc, ad, err)
+				}
+			}
+		}
+	}
+
+	// NewAEADAES uses OmitNilAD, and non-empty associated data is the same in
+	// every encoding
+	def, _ := NewAEADAES(key, len(nonce))
+	if ct := def.Seal(nil, nonce, pt, []byte{}); !bytes.Equal(ct, included) {
+		t.Errorf("Seal: NewAEADAES: empty associated data: expected: %x\ngot: %x", included, ct)
+	}
+	want := def.Seal(nil, nonce, pt, []byte("x"))
+	for _, enc := range []EmptyADEncoding{OmitEmptyAD, IncludeEmptyAD} {
+		a, _ := NewAEADAESWithOptions(key, len(nonce), AEADOptions{EmptyAD: enc})
+		if ct := a.Seal(nil, nonce, pt, []byte("x")); !bytes.Equal(ct, want) {
+			t.Errorf("Seal: encoding %d: non-empty associated data: %x != %x", enc, ct, want)
+		}
+	}
+	// Without a nonce, IncludeEmptyAD passes a single empty item
+	det, _ := NewAEADAESWithOptions(key, 0, AEADOptions{EmptyAD: IncludeEmptyAD})
+	if want, _ := c.Seal(nil, pt, []byte{}); !bytes.Equal(det.Seal(nil, nil, pt, nil), want) {
+		t.Errorf("Seal: deterministic IncludeEmptyAD: expected %x", want)
+	}
+	// The options combine
+	both, _ := NewAEADAESWithOptions(key, len(nonce), AEADOptions{NonceOrder: NonceFirst, EmptyAD: IncludeEmptyAD})
+	if want, _ := c.Seal(nil, pt, nonce, []byte{}); !bytes.Equal(both.Seal(nil, nonce, pt, nil), want) {
+		t.Errorf("Seal: NonceFirst and IncludeEmptyAD: expected %x", want)
+	}
+	if _, err := NewAEADAESWithOptions(key, len(nonce), AEADOptions{EmptyAD: 3}); err != ErrEmptyAD {
+		t.Errorf("NewAEADAESWithOptions: expected ErrEmptyAD, got %v", err)
+	}
+}
+
+func TestAEADAESPMACSIV(t *testing.T) {
+	testAEAD(t, NewAEADAESPMACSIV, pmacTestVectors)
+}
+
+// xorBlock is a trivial (and insecure) 16-byte block cipher which xors each
+// block with its key, standing in for an external implementation.
+type xorBlock struct {
+	key []byte
+}
+
+func newXORBlock(key []byte) (cipher.Block, error) {
+	if len(key) != 16 {
+		return nil, errors.New("xorBlock: bad key size")
+	}
+	return &xorBlock{append([]byte(nil), key...)}, nil
+}
+
+func (b *xorBlock) BlockSize() int { return 16 }
+
+func (b *xorBlock) Encrypt(dst, src []byte) {
+	for i := range b.key {
+		dst[i] = src[i] ^ b.key[i]
+	}
+}
+
+func (b *xorBlock) Decrypt(dst, src []byte) { b.Encrypt(dst, src) }
+
+func TestNewAEAD(t *testing.T) {
+	// With crypto/aes, NewAEAD is NewAEADAES
+	testAEAD(t, func(key []byte, nonceSize int) (cipher.AEAD, error) {
+		return NewAEAD(aes.NewCipher, key, nonceSize)
+	}, testVectors)
+
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	var keys [][]byte
+	c, err := NewAEAD(func(k []byte) (cipher.Block, error) {
+		keys = append(keys, k)
+		return newXORBlock(k)
+	}, key, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || !bytes.Equal(keys[0], key[:16]) || !bytes.Equal(keys[1], key[16:]) {
+		t.Errorf("NewAEAD: expected each half of the key in turn, got %x", keys)
+	}
+	nonce, pt := make([]byte, 16), []byte("plaintext")
+	ct := c.Seal(nil, nonce, pt, []byte("data"))
+	if out, err := c.Open(nil, nonce, ct, []byte("data")); err != nil || !bytes.Equal(out, pt) {
+		t.Errorf("Open: expected: %x\ngot: %x (%v)", pt, out, err)
+	}
+	ct[len(ct)-1] ^= 1
+	if _, err := c.Open(nil, nonce, ct, []byte("data")); err != ErrNotAuthentic {
+		t.Errorf("Open: tampered: expected ErrNotAuthentic, got %v", err)
+	}
+
+	if _, err := NewAEAD(newXORBlock, make([]byte, 33), 16); err != ErrKeySize {
+		t.Errorf("NewAEAD: odd key: expected ErrKeySize, got %v", err)
+	}
+	if _, err := NewAEAD(newXORBlock, make([]byte, 64), 16); err == nil {
+		t.Errorf("NewAEAD: expected the error from newBlock")
+	}
+	if _, err := NewAEAD(des.NewCipher, make([]byte, 16), 16); err != ErrBlockSize {
+		t.Errorf("NewAEAD: DES: expected ErrBlockSize, got %v", err)
+	}
+}
+
+func TestNewAEADAESKeys(t *testing.T) {
+	// Splitting the vector keys gives the same results as NewAEADAES
+	testAEAD(t, func(key []byte, nonceSize int) (cipher.AEAD, error) {
+		return NewAEADAESKeys(key[:len(key)/2], key[len(key)/2:], nonceSize)
+	}, testVectors)
+
+	nonce, pt, ad := make([]byte, 16), []byte("plaintext"), []byte("data")
+	for _, n := range []int{16, 24, 32} {
+		key := make([]byte, 2*n)
+		for i := range key {
+			key[i] = byte(i)
+		}
+		a, err := NewAEADAESKeys(key[:n], key[n:], len(nonce))
+		if err != nil {
+			t.Fatalf("NewAEADAESKeys: %d-byte keys: %s", n, err)
+		}
+		b, _ := NewAEADAES(key, len(nonce))
+		if got, want := a.Seal(nil, nonce, pt, ad), b.Seal(nil, nonce, pt, ad); !bytes.Equal(got, want) {
+			t.Errorf("Seal: %d-byte keys: expected: %x\ngot: %x", n, want, got)
+		}
+	}
+
+	if _, err := NewAEADAESKeys(make([]byte, 16), make([]byte, 32), 16); err != ErrKeySize {
+		t.Errorf("NewAEADAESKeys: mismatched keys: expected ErrKeySize, got %v", err)
+	}
+	for _, n := range [][2]int{{15, 16}, {16, 17}} {
+		var keyErr aes.KeySizeError
+		if _, err := NewAEADAESKeys(make([]byte, n[0]), make([]byte, n[1]), 16); !errors.As(err, &keyErr) {
+			t.Errorf("NewAEADAESKeys: %d and %d bytes: expected aes.KeySizeError, got %v", n[0], n[1], err)
+		}
+	}
+}
+
+func TestAEADReset(t *testing.T) {
+	c, err := NewAEADAES(make([]byte, 32), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, 16)
+	ct := c.Seal(nil, nonce, []byte("plaintext"), nil)
+
+	c.(AEAD).Reset()
+
+	if _, err := c.Open(nil, nonce, ct, nil); err != ErrReset {
+		t.Errorf("Open: expected ErrReset, got %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Seal: expected panic after Reset")
+		}
+	}()
+	c.Seal(nil, nonce, []byte("plaintext"), nil)
+}
+
+func TestAEADSizes(t *testing.T) {
+	for _, newAEAD := range []AEADConstructor{NewAEADAES, NewAEADAESPMACSIV} {
+		for _, keySize := range []int{32, 48, 64} {
+			for _, nonceSize := range []int{0, 1, 8, 12, 16, 24, 64} {
+				c, err := newAEAD(make([]byte, keySize), nonceSize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if c.NonceSize() != nonceSize {
+					t.Errorf("NonceSize: expected %d, got %d", nonceSize, c.NonceSize())
+				}
+				if c.Overhead() != 16 {
+					t.Errorf("Overhead: nonce size %d: expected 16, got %d", nonceSize, c.Overhead())
+				}
+				pt := make([]byte, 100)
+				ct := c.Seal(nil, make([]byte, nonceSize), pt, nil)
+				if len(ct) != len(pt)+c.Overhead() {
+					t.Errorf("Seal: nonce size %d: expected %d bytes, got %d", nonceSize, len(pt)+c.Overhead(), len(ct))
+				}
+			}
+		}
+	}
+}
+
+func TestAEADNonceSizes(t *testing.T) {
+	key := decode(testVectors[1].key)
+	c, _ := NewAES(key)
+	pt, ad := []byte("plaintext"), []byte("data")
+	for _, nonceSize := range []int{0, 12, 16, MaxNonceSize} {
+		a, err := NewAEADAES(key, nonceSize)
+		if err != nil {
+			t.Fatalf("NewAEADAES: nonce size %d: %s", nonceSize, err)
+		}
+		if a.NonceSize() != nonceSize {
+			t.Errorf("NonceSize: expected %d, got %d", nonceSize, a.NonceSize())
+		}
+
+		// The nonce is the last S2V input, or absent for deterministic mode
+		nonce := make([]byte, nonceSize)
+		for i := range nonce {
+			nonce[i] = byte(i)
+		}
+		want, _ := c.Seal(nil, pt, ad, nonce)
+		if nonceSize == 0 {
+			want, _ = c.Seal(nil, pt, ad)
+		}
+		ct := a.Seal(nil, nonce, pt, ad)
+		if !bytes.Equal(want, ct) {
+			t.Errorf("Seal: nonce size %d: expected: %x\ngot: %x", nonceSize, want, ct)
+		}
+		if out, err := a.Open(nil, nonce, ct, ad); err != nil || !bytes.Equal(out, pt) {
+			t.Errorf("Open: nonce size %d: %x (%v)", nonceSize, out, err)
+		}
+
+		// Too long and too short
+		for _, bad := range [][]byte{append(nonce, 0), nonce[:0], nonce[:len(nonce)/2]} {
+			if len(bad) == nonceSize {
+				continue
+			}
+			if _, err := a.Open(nil, bad, ct, ad); err != ErrNonceLength {
+				t.Errorf("Open: nonce size %d: %d byte nonce: expected ErrNonceLength, got %v", nonceSize, len(bad), err)
+			}
+			x := a.(AEAD)
+			if err := x.Verify(bad, ct, ad); err != ErrNonceLength {
+				t.Errorf("Verify: nonce size %d: %d byte nonce: expected ErrNonceLength, got %v", nonceSize, len(bad), err)
+			}
+			if _, _, err := x.SealDetached(nil, bad, pt, ad); err != ErrNonceLength {
+				t.Errorf("SealDetached: nonce size %d: %d byte nonce: expected ErrNonceLength, got %v", nonceSize, len(bad), err)
+			}
+			if _, err := x.OpenDetached(nil, bad, ct[16:], ct[:16], ad); err != ErrNonceLength {
+				t.Errorf("OpenDetached: nonce size %d: %d byte nonce: expected ErrNonceLength, got %v", nonceSize, len(bad), err)
+			}
+			func() {
+				defer func() {
+					msg, _ := recover().(string)
+					if !strings.Contains(msg, "incorrect nonce length") {
+						t.Errorf("Seal: nonce size %d: %d byte nonce: expected a panic, got %q", nonceSize, len(bad), msg)
+					}
+				}()
+				a.Seal(nil, bad, pt, ad)
+			}()
+		}
+	}
+
+	for _, newAEAD := range []AEADConstructor{NewAEADAES, NewAEADAESPMACSIV} {
+		for _, n := range []int{MaxNonceSize + 1, 255, maxInt, -2, -maxInt - 1} {
+			if _, err := newAEAD(key, n); err != ErrNonceSize {
+				t.Errorf("nonce size %d: expected ErrNonceSize, got %v", n, err)
+			}
+		}
+		for _, n := range []int{0, MaxNonceSize} {
+			if a, err := newAEAD(key, n); err != nil || a.NonceSize() != n {
+				t.Errorf("nonce size %d: %v", n, err)
+			}
+		}
+	}
+	a, err := NewAEADAES(key, AnyNonceSize)
+	if err != nil || a.NonceSize() != AnyNonceSize {
+		t.Fatalf("NewAEADAES: any nonce size: %v", err)
+	}
+	// Each length opens, but only with the same nonce
+	for _, nonce := range [][]byte{nil, {1}, make([]byte, 12), make([]byte, MaxNonceSize)} {
+		ct := a.Seal(nil, nonce, []byte("message"), []byte("header"))
+		if out, err := a.Open(nil, nonce, ct, []byte("header")); err != nil || string(out) != "message" {
+			t.Errorf("Open: any nonce size: %d byte nonce: %q (%v)", len(nonce), out, err)
+		}
+		if _, err := a.Open(nil, append(nonce, 0), ct, []byte("header")); err != ErrNotAuthentic {
+			t.Errorf("Open: any nonce size: %d byte nonce, one longer: expected ErrNotAuthentic, got %v", len(nonce), err)
+		}
+	}
+}
+
+func TestAEADDeterministic(t *testing.T) {
+	// RFC 5297 A.1 and the empty example don't use a nonce
+	for _, i := range []int{0, 2} {
+		v := testVectors[i]
+		c, err := NewAEADAES(decode(v.key), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ad []byte
+		if len(v.adata) > 0 {
+			ad = decode(v.adata[0])
+		}
+		gpt, gct := decode(v.plaintext), decode(v.output)
+		for _, nonce := range [][]byte{nil, {}} {
+			ct := c.Seal(nil, nonce, gpt, ad)
+			if !bytes.Equal(gct, ct) {
+				t.Errorf("Seal: %d: expected: %x\ngot: %x", i, gct, ct)
+			}
+			pt, err := c.Open(nil, nonce, ct, ad)
+			if err != nil {
+				t.Errorf("Open: %d: %s", i, err)
+			}
+			if !bytes.Equal(gpt, pt) {
+				t.Errorf("Open: %d: expected: %x\ngot: %x", i, gpt, pt)
+			}
+		}
+		if !bytes.Equal(c.Seal(nil, nil, gpt, ad), c.Seal(nil, nil, gpt, ad)) {
+			t.Errorf("Seal: %d: deterministic encryption produced different ciphertexts", i)
+		}
+	}
+}
+
+func TestAEADDeterministicOldFormat(t *testing.T) {
+	// 0.1.0 passed the empty nonce of a zero nonce size to S2V as an item
+	key, ad, pt := make([]byte, 32), []byte("ad"), []byte("plaintext")
+	c, err := NewAES(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := c.Seal(nil, pt, ad, []byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAEADAES(key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Open(nil, nil, old, ad); err != ErrNotAuthentic {
+		t.Errorf("Open of a 0.1.0 ciphertext: expected ErrNotAuthentic, got %v", err)
+	}
+	out, err := c.Open(nil, old, ad, []byte{})
+	if err != nil || !bytes.Equal(out, pt) {
+		t.Errorf("Cipher.Open of a 0.1.0 ciphertext: got %x, %v", out, err)
+	}
+}
+
+func TestAEADInPlace(t *testing.T) {
+	for _, newAEAD := range []AEADConstructor{NewAEADAES, NewAEADAESPMACSIV} {
+		c, err := newAEAD(make([]byte, 32), 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce, ad := make([]byte, 16), []byte("associated data")
+		for n := 0; n <= 100; n++ {
+			pt := make([]byte, n)
+			for i := range pt {
+				pt[i] = byte(i)
+			}
+			want := c.Seal(nil, nonce, pt, ad)
+
+			buf := make([]byte, n, n+c.Overhead())
+			copy(buf, pt)
+			ct := c.Seal(buf[:0], nonce, buf, ad)
+			if !bytes.Equal(ct, want) {
+				t.Errorf("Seal: %d: in place: expected: %x\ngot: %x", n, want, ct)
+			}
+			x, err := c.Open(ct[:0], nonce, ct, ad)
+			if err != nil {
+				t.Fatalf("Open: %d: in place: %s", n, err)
+			}
+			if !bytes.Equal(x, pt) {
+				t.Errorf("Open: %d: in place: expected: %x\ngot: %x", n, pt, x)
+			}
+		}
+	}
+}
+
+func TestAEADConcurrent(t *testing.T) {
+	const goroutines, iterations = 8, 100
+	for _, newAEAD := range []AEADConstructor{NewAEADAES, NewAEADAESPMACSIV} {
+		c, err := newAEAD(make([]byte, 32), 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ad := []byte("associated data")
+		var wg sync.WaitGroup
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				nonce, pt := make([]byte, 16), make([]byte, 100)
+				for i := 0; i < iterations; i++ {
+					binary.BigEndian.PutUint64(nonce, uint64(g))
+					binary.BigEndian.PutUint64(nonce[8:], uint64(i))
+					copy(pt, nonce)
+					ct := c.Seal(nil, nonce, pt, ad)
+					x, err := c.Open(nil, nonce, ct, ad)
+					if err != nil {
+						t.Errorf("Open: %d/%d: %s", g, i, err)
+						return
+					}
+					if !bytes.Equal(x, pt) {
+						t.Errorf("Open: %d/%d: expected: %x\ngot: %x", g, i, pt, x)
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+	}
+}
+
+func TestAEADCloneConcurrent(t *testing.T) {
+	// One clone per connection, to be run with -race
+	const clones = 100
+	key, ad := countingKey(32), []byte("header")
+	for _, newAEAD := range []AEADConstructor{NewAEADAES, NewAEADAESPMACSIV} {
+		base, _ := newAEAD(key, 16)
+		fresh, _ := newAEAD(key, 16)
+		var wg sync.WaitGroup
+		for g := 0; g < clones; g++ {
+			c, err := base.(AEAD).Clone()
+			if err != nil {
+				t.Fatal(err)
+			}
+			wg.Add(1)
+			go func(c cipher.AEAD, g int) {
+				defer wg.Done()
+				for i := 0; i < 10; i++ {
+					nonce := make([]byte, 16)
+					binary.BigEndian.PutUint64(nonce, uint64(g))
+					binary.BigEndian.PutUint64(nonce[8:], uint64(i))
+					pt := []byte("message " + strconv.Itoa(g*10+i))
+					ct := c.Seal(nil, nonce, pt, ad)
+					if want := fresh.Seal(nil, nonce, pt, ad); !bytes.Equal(ct, want) {
+						t.Errorf("Seal: clone %d: expected: %x\ngot: %x", g, want, ct)
+						return
+					}
+					if out, err := c.Open(nil, nonce, ct, ad); err != nil || !bytes.Equal(out, pt) {
+						t.Errorf("Open: clone %d: %q (%v)", g, out, err)
+						return
+					}
+				}
+			}(c, g)
+		}
+		wg.Wait()
+	}
+}
+
+func TestAEADAESRandomNonce(t *testing.T) {
+	c, err := NewAEADAESRandomNonce(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.NonceSize() != 0 {
+		t.Errorf("NonceSize: expected 0, got %d", c.NonceSize())
+	}
+	if c.Overhead() != 32 {
+		t.Errorf("Overhead: expected 32, got %d", c.Overhead())
+	}
+	pt, ad := []byte("plaintext"), []byte("associated data")
+	for _, data := range [][]byte{nil, ad} {
+		prefix := []byte{1, 2, 3, 4}
+		ct := c.Seal(prefix, nil, pt, data)
+		if !bytes.Equal(ct[:len(prefix)], prefix) {
+			t.Fatalf("Seal: didn't correctly append")
+		}
+		ct = ct[len(prefix):]
+		if len(ct) != len(pt)+c.Overhead() {
+			t.Errorf("Seal: expected %d bytes, got %d", len(pt)+c.Overhead(), len(ct))
+		}
+		x, err := c.Open(nil, nil, ct, data)
+		if err != nil {
+			t.Fatalf("Open: %s", err)
+		}
+		if !bytes.Equal(x, pt) {
+			t.Errorf("Open: expected: %x\ngot: %x", pt, x)
+		}
+
+		// The embedded nonce is authenticated like any other
+		ct[0] ^= 1
+		if _, err := c.Open(nil, nil, ct, data); err != ErrNotAuthentic {
+			t.Errorf("Open: altered nonce: expected ErrNotAuthentic, got %v", err)
+		}
+	}
+
+	if bytes.Equal(c.Seal(nil, nil, pt, ad), c.Seal(nil, nil, pt, ad)) {
+		t.Error("Seal: same plaintext sealed twice gave the same ciphertext")
+	}
+
+	ct := c.Seal(nil, nil, nil, nil)
+	for n := 0; n < c.Overhead(); n++ {
+		if _, err := c.Open(nil, nil, ct[:n], nil); err != ErrTooShort {
+			t.Errorf("Open: %d bytes: expected ErrTooShort, got %v", n, err)
+		}
+	}
+	if _, err := c.Open(nil, nil, ct, nil); err != nil {
+		t.Errorf("Open: empty plaintext: %s", err)
+	}
+}
+
+func TestAEADOpenTooShort(t *testing.T) {
+	for _, newAEAD := range []AEADConstructor{NewAEADAES, NewAEADAESPMACSIV} {
+		for _, nonceSize := range []int{0, 16} {
+			c, err := newAEAD(make([]byte, 32), nonceSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nonce := make([]byte, nonceSize)
+			for n := 0; n < c.Overhead(); n++ {
+				if _, err := c.Open(nil, nonce, make([]byte, n), nil); err != ErrTooShort {
+					t.Errorf("Open: %d bytes: expected ErrTooShort, got %v", n, err)
+				}
+			}
+		}
+	}
+}
+
+func TestAEADAuthFailed(t *testing.T) {
+	for _, newAEAD := range []AEADConstructor{NewAEADAES, NewAEADAESPMACSIV} {
+		c, err := newAEAD(make([]byte, 32), 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce := make([]byte, 16)
+		ct := c.Seal(nil, nonce, []byte("plaintext"), nil)
+		ct[0] ^= 0x80
+		if _, err := c.Open(nil, nonce, ct, nil); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("Open: flipped tag bit: expected ErrAuthFailed, got %v", err)
+		}
+	}
+}
+
+func TestAEADVerify(t *testing.T) {
+	for _, order := range []NonceOrder{NonceLast, NonceFirst} {
+		for _, nonceSize := range []int{0, 16} {
+			a, err := NewAEADAESWithOptions(make([]byte, 32), nonceSize, AEADOptions{NonceOrder: order})
+			if err != nil {
+				t.Fatal(err)
+			}
+			nonce := make([]byte, nonceSize)
+			for _, data := range [][]byte{nil, []byte("header")} {
+				ct := a.Seal(nil, nonce, []byte("plaintext"), data)
+				if err := a.(AEAD).Verify(nonce, ct, data); err != nil {
+					t.Errorf("Verify: order %d: nonce size %d: %s", order, nonceSize, err)
+				}
+				ct[len(ct)-1] ^= 1
+				_, openErr := a.Open(nil, nonce, ct, data)
+				if err := a.(AEAD).Verify(nonce, ct, data); err != openErr || err == nil {
+					t.Errorf("Verify: order %d: nonce size %d: tampered: Open returned %v, Verify %v", order, nonceSize, openErr, err)
+				}
+			}
+		}
+	}
+}
+
+func TestAEADDetached(t *testing.T) {
+	pt := []byte("plaintext")
+	for _, order := range []NonceOrder{NonceLast, NonceFirst} {
+		for _, nonceSize := range []int{0, 16} {
+			a, _ := NewAEADAESWithOptions(make([]byte, 32), nonceSize, AEADOptions{NonceOrder: order})
+			d := a.(AEAD)
+			nonce := make([]byte, nonceSize)
+			for _, data := range [][]byte{nil, []byte("header")} {
+				ct, tag, err := d.SealDetached(nil, nonce, pt, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := a.Seal(nil, nonce, pt, data); !bytes.Equal(append(tag[:], ct...), want) {
+					t.Errorf("SealDetached: order %d: nonce size %d: expected: %x\ngot: %x %x", order, nonceSize, want, tag, ct)
+				}
+				if out, err := d.OpenDetached(nil, nonce, ct, tag[:], data); err != nil || !bytes.Equal(out, pt) {
+					t.Errorf("OpenDetached: order %d: nonce size %d: %x (%v)", order, nonceSize, out, err)
+				}
+				tag[0] ^= 1
+				if _, err := d.OpenDetached(nil, nonce, ct, tag[:], data); err != ErrNotAuthentic {
+					t.Errorf("OpenDetached: order %d: nonce size %d: bad tag: expected ErrNotAuthentic, got %v", order, nonceSize, err)
+				}
+			}
+		}
+	}
+}
+
+func TestAEADNewNonce(t *testing.T) {
+	for _, nonceSize := range []int{0, 12, 16, -1} {
+		c, err := NewAEADAES(make([]byte, 32), nonceSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newNonce := c.(AEAD).NewNonce
+		n1, err := newNonce()
+		if err != nil {
+			t.Fatalf("NewNonce: %s", err)
+		}
+		n2, _ := newNonce()
+		want := nonceSize
+		if want < 0 {
+			want = 16
+		}
+		if len(n1) != want || len(n2) != want {
+			t.Errorf("NewNonce: nonce size %d: expected %d bytes, got %d", nonceSize, want, len(n1))
+		}
+		if want > 0 && bytes.Equal(n1, n2) {
+			t.Errorf("NewNonce: nonce size %d: returned the same nonce twice", nonceSize)
+		}
+		pt := []byte("plaintext")
+		if x, err := c.Open(nil, n1, c.Seal(nil, n1, pt, nil), nil); err != nil || !bytes.Equal(x, pt) {
+			t.Errorf("Open: nonce size %d: %v", nonceSize, err)
+		}
+	}
+}
+
+func TestAEADAppend(t *testing.T) {
+	randomNonce := func(key []byte, _ int) (cipher.AEAD, error) { return NewAEADAESRandomNonce(key) }
+	for _, newAEAD := range []AEADConstructor{NewAEADAES, NewAEADAESPMACSIV, randomNonce} {
+		c, err := newAEAD(make([]byte, 32), 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce := make([]byte, c.NonceSize())
+		pt, ad := []byte("plaintext"), []byte("associated data")
+		prefix := []byte("prefix")
+
+		// nil dst
+		ct := c.Seal(nil, nonce, pt, ad)
+		if len(ct) != len(pt)+c.Overhead() {
+			t.Fatalf("Seal: nil dst: expected %d bytes, got %d", len(pt)+c.Overhead(), len(ct))
+		}
+		if x, err := c.Open(nil, nonce, ct, ad); err != nil || !bytes.Equal(x, pt) {
+			t.Errorf("Open: nil dst: %x (%v)", x, err)
+		}
+
+		// dst with existing contents and no spare capacity
+		dst := append([]byte(nil), prefix...)
+		x := c.Seal(dst[:len(prefix):len(prefix)], nonce, pt, ad)
+		if len(x) != len(prefix)+len(ct) || !bytes.Equal(x[:len(prefix)], prefix) {
+			t.Errorf("Seal: dst contents not preserved: %x", x)
+		}
+		x, err = c.Open(dst[:len(prefix):len(prefix)], nonce, ct, ad)
+		if err != nil || !bytes.Equal(x, append(append([]byte(nil), prefix...), pt...)) {
+			t.Errorf("Open: dst contents not preserved: %x (%v)", x, err)
+		}
+
+		// dst with spare capacity is appended to in place
+		dst = make([]byte, len(prefix), len(prefix)+len(ct)+10)
+		copy(dst, prefix)
+		x = c.Seal(dst, nonce, pt, ad)
+		if len(x) != len(prefix)+len(ct) || &x[0] != &dst[0] || !bytes.Equal(x[:len(prefix)], prefix) {
+			t.Errorf("Seal: didn't append within the capacity of dst")
+		}
+		dst = dst[:len(prefix)]
+		x, err = c.Open(dst, nonce, ct, ad)
+		if err != nil || len(x) != len(prefix)+len(pt) || &x[0] != &dst[0] || !bytes.Equal(x[:len(prefix)], prefix) {
+			t.Errorf("Open: didn't append within the capacity of dst (%v)", err)
+		}
+
+		if c.NonceSize() > 0 {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("Seal: expected a panic for the wrong nonce length")
+					}
+				}()
+				c.Seal(nil, nonce[1:], pt, ad)
+			}()
+			if _, err := c.Open(nil, append(nonce, 0), ct, ad); err != ErrNonceLength {
+				t.Errorf("Open: expected ErrNonceLength, got %v", err)
+			}
+		}
+	}
+}
+
+func TestAEADAppendAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("skipping allocation test with the race detector")
+	}
+	randomNonce := func(key []byte, _ int) (cipher.AEAD, error) { return NewAEADAESRandomNonce(key) }
+	for _, newAEAD := range []AEADConstructor{NewAEADAES, NewAEADAESPMACSIV, randomNonce} {
+		c, err := newAEAD(make([]byte, 32), 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nonce := make([]byte, c.NonceSize())
+		pt, ad, prefix := make([]byte, 100), []byte("associated data"), []byte("prefix")
+		ct := c.Seal(nil, nonce, pt, ad)
+
+		// A pre-filled dst with enough spare capacity is extended in place
+		dst := make([]byte, len(prefix), len(prefix)+len(ct)+10)
+		copy(dst, prefix)
+		var out []byte
+		if allocs := testing.AllocsPerRun(100, func() { out = c.Seal(dst, nonce, pt, ad) }); allocs != 0 {
+			t.Errorf("Seal: expected no allocations, got %v", allocs)
+		}
+		if &out[0] != &dst[0] || !bytes.Equal(out[:len(prefix)], prefix) {
+			t.Errorf("Seal: prefix not preserved in place: %x", out)
+		}
+		if allocs := testing.AllocsPerRun(100, func() { out, err = c.Open(dst, nonce, ct, ad) }); allocs != 0 {
+			t.Errorf("Open: expected no allocations, got %v", allocs)
+		}
+		if err != nil || &out[0] != &dst[0] || !bytes.Equal(out, append(append([]byte(nil), prefix...), pt...)) {
+			t.Errorf("Open: prefix not preserved in place: %x (%v)", out, err)
+		}
+	}
+}
+
+func TestAEADInto(t *testing.T) {
+	for _, newAEAD := range []AEADConstructor{NewAEADAES, NewAEADAESPMACSIV} {
+		a, err := newAEAD(make([]byte, 32), 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x := a.(AEAD)
+		nonce, pt := make([]byte, 16), []byte("message")
+		for _, ad := range [][]byte{nil, []byte("header")} {
+			ct := a.Seal(nil, nonce, pt, ad)
+
+			// An exact fit, and the start of a larger buffer
+			buf := make([]byte, len(ct)+5)
+			for _, dst := range [][]byte{buf[:len(ct)], buf} {
+				n, err := x.SealInto(dst, nonce, pt, ad)
+				if err != nil || !bytes.Equal(dst[:n], ct) {
+					t.Errorf("SealInto: %d byte dst: expected: %x\ngot: %x (%v)", len(dst), ct, dst[:n], err)
+				}
+			}
+			for _, dst := range [][]byte{buf[:len(pt)], buf} {
+				n, err := x.OpenInto(dst, nonce, ct, ad)
+				if err != nil || !bytes.Equal(dst[:n], pt) {
+					t.Errorf("OpenInto: %d byte dst: expected: %x\ngot: %x (%v)", len(dst), pt, dst[:n], err)
+				}
+			}
+
+			if _, err := x.SealInto(buf[:len(ct)-1], nonce, pt, ad); err != ErrShortBuffer {
+				t.Errorf("SealInto: short dst: expected ErrShortBuffer, got %v", err)
+			}
+			if _, err := x.OpenInto(buf[:len(pt)-1], nonce, ct, ad); err != ErrShortBuffer {
+				t.Errorf("OpenInto: short dst: expected ErrShortBuffer, got %v", err)
+			}
+			if _, err := x.SealInto(buf, nonce[1:], pt, ad); err != ErrNonceLength {
+				t.Errorf("SealInto: short nonce: expected ErrNonceLength, got %v", err)
+			}
+			if _, err := x.OpenInto(buf, nonce[1:], ct, ad); err != ErrNonceLength {
+				t.Errorf("OpenInto: short nonce: expected ErrNonceLength, got %v", err)
+			}
+			if _, err := x.OpenInto(buf, nonce, ct[:x.Overhead()-1], ad); err != ErrTooShort {
+				t.Errorf("OpenInto: expected ErrTooShort, got %v", err)
+			}
+			ct[0] ^= 1
+			if n, err := x.OpenInto(buf, nonce, ct, ad); err != ErrNotAuthentic || n != 0 {
+				t.Errorf("OpenInto: forged: expected ErrNotAuthentic, got %d (%v)", n, err)
+			}
+
+			if raceEnabled {
+				continue
+			}
+			if allocs := testing.AllocsPerRun(100, func() { x.SealInto(buf, nonce, pt, ad) }); allocs != 0 {
+				t.Errorf("SealInto: expected no allocations, got %v", allocs)
+			}
+		}
+	}
+}
+
+func TestEncryptAES(t *testing.T) {
+	testAEAD(t, func(key []byte, nonceSize int) (cipher.AEAD, error) {
+		return oneShotAEAD{key, nonceSize}, nil
+	}, testVectors)
+
+	// Deterministic mode, and compatibility with the stateful API
+	key := make([]byte, 32)
+	pt, ad := []byte("plaintext"), []byte("data")
+	ct, err := EncryptAES(key, nil, pt, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := NewAEADAES(key, 0)
+	if want := a.Seal(nil, nil, pt, ad); !bytes.Equal(ct, want) {
+		t.Errorf("EncryptAES: expected: %x\ngot: %x", want, ct)
+	}
+
+	if _, err := EncryptAES(make([]byte, 16), nil, pt, ad); !errors.Is(err, ErrKeySize) {
+		t.Errorf("EncryptAES: expected ErrKeySize, got %v", err)
+	}
+	if _, err := EncryptAES(key, make([]byte, MaxNonceSize+1), pt, ad); err != ErrNonceSize {
+		t.Errorf("EncryptAES: expected ErrNonceSize, got %v", err)
+	}
+	if _, err := DecryptAES(make([]byte, 16), nil, ct, ad); !errors.Is(err, ErrKeySize) {
+		t.Errorf("DecryptAES: expected ErrKeySize, got %v", err)
+	}
+	if _, err := DecryptAES(key, nil, ct[:15], ad); err != ErrTooShort {
+		t.Errorf("DecryptAES: expected ErrTooShort, got %v", err)
+	}
+	if _, err := DecryptAES(key, nil, ct, []byte("other")); err != ErrAuthFailed {
+		t.Errorf("DecryptAES: expected ErrAuthFailed, got %v", err)
+	}
+}
+
+// oneShotAEAD adapts EncryptAES and DecryptAES to cipher.AEAD for testAEAD.
+type oneShotAEAD struct {
+	key       []byte
+	nonceSize int
+}
+
+func (a oneShotAEAD) NonceSize() int { return a.nonceSize }
+func (a oneShotAEAD) Overhead() int  { return 16 }
+
+func (a oneShotAEAD) Seal(dst, nonce, plaintext, data []byte) []byte {
+	ct, err := EncryptAES(a.key, nonce, plaintext, data)
+	if err != nil {
+		panic(err)
+	}
+	return append(dst, ct...)
+}
+
+func (a oneShotAEAD) Open(dst, nonce, ciphertext, data []byte) ([]byte, error) {
+	pt, err := DecryptAES(a.key, nonce, ciphertext, data)
+	if err != nil {
+		return nil, err
+	}
+	return append(dst, pt...), nil
+}
+
+func TestTagSize(t *testing.T) {
+	for _, alg := range Algorithms() {
+		for _, size := range []int{32, 48, 64} {
+			a, err := NewAEADByName(string(alg), make([]byte, size), 16)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.Overhead() != TagSize {
+				t.Errorf("%s: %d byte key: Overhead: expected %d, got %d", alg, size, TagSize, a.Overhead())
+			}
+			pt := []byte("plaintext")
+			if ct := a.Seal(nil, make([]byte, 16), pt, nil); len(ct) != len(pt)+TagSize {
+				t.Errorf("%s: %d byte key: Seal: expected %d bytes, got %d", alg, size, len(pt)+TagSize, len(ct))
+			}
+		}
+	}
+}
+
+// BenchmarkAEADSmall seals small records with one associated data item and
+// a 16-byte nonce through the cipher.AEAD interface.
+func BenchmarkAEADSmall(b *testing.B) {
+	a, _ := NewAEADAES(make([]byte, 32), 16)
+	nonce, ad := make([]byte, 16), make([]byte, 32)
+	for _, n := range []int{16, 64, 255} {
+		m := make([]byte, n)
+		out := make([]byte, 0, n+a.Overhead())
+		ct := a.Seal(nil, nonce, m, ad)
+		b.Run("Seal/"+strconv.Itoa(n), func(b *testing.B) {
+			b.SetBytes(int64(n))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				a.Seal(out, nonce, m, ad)
+			}
+		})
+		b.Run("Open/"+strconv.Itoa(n), func(b *testing.B) {
+			b.SetBytes(int64(n))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := a.Open(out[:0], nonce, ct, ad); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
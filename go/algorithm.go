@@ -0,0 +1,67 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"crypto/cipher"
+	"strconv"
+	"strings"
+)
+
+// Algorithm is the name of an SIV algorithm, as used by the other Miscreant
+// implementations and in the "alg" field of the test vectors.
+type Algorithm string
+
+const (
+	// AlgorithmAESSIV is AES-SIV (RFC 5297), using AES-CMAC in S2V.
+	AlgorithmAESSIV Algorithm = "AES-SIV"
+
+	// AlgorithmAESPMACSIV is AES-PMAC-SIV, using AES-PMAC in S2V.
+	AlgorithmAESPMACSIV Algorithm = "AES-PMAC-SIV"
+)
+
+// algorithms maps each supported algorithm to its AEAD constructor.
+var algorithms = []struct {
+	alg     Algorithm
+	newAEAD func(key []byte, nonceSize int) (cipher.AEAD, error)
+}{
+	{AlgorithmAESSIV, NewAEADAES},
+	{AlgorithmAESPMACSIV, NewAEADAESPMACSIV},
+}
+
+// Algorithms returns the names of the algorithms NewAEADByName accepts.
+func Algorithms() []Algorithm {
+	algs := make([]Algorithm, len(algorithms))
+	for i, a := range algorithms {
+		algs[i] = a.alg
+	}
+	return algs
+}
+
+// UnknownAlgorithmError is returned by NewAEADByName for an algorithm name it
+// doesn't support. Its value is the rejected name.
+type UnknownAlgorithmError string
+
+func (e UnknownAlgorithmError) Error() string {
+	names := make([]string, len(algorithms))
+	for i, a := range algorithms {
+		names[i] = string(a.alg)
+	}
+	return "siv: unknown algorithm " + strconv.Quote(string(e)) + ", expected one of " + strings.Join(names, ", ")
+}
+
+// NewAEADByName returns an instance of the named algorithm implementing
+// cipher.AEAD interface, as NewAEADAES or NewAEADAESPMACSIV would for the
+// given key and nonce size, so that the algorithm can be recorded alongside a
+// ciphertext and chosen when it is read. The name is matched exactly, and any
+// name not listed by Algorithms is rejected with an UnknownAlgorithmError.
+//
+// The returned AEAD has an Algorithm() Algorithm method reporting alg.
+func NewAEADByName(alg string, key []byte, nonceSize int) (cipher.AEAD, error) {
+	for _, a := range algorithms {
+		if string(a.alg) == alg {
+			return a.newAEAD(key, nonceSize)
+		}
+	}
+	return nil, UnknownAlgorithmError(alg)
+}
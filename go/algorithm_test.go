@@ -0,0 +1,73 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"strings"
+	"testing"
+)
+
+func TestNewAEADByName(t *testing.T) {
+	direct := map[Algorithm]func([]byte, int) (cipher.AEAD, error){
+		AlgorithmAESSIV:     NewAEADAES,
+		AlgorithmAESPMACSIV: NewAEADAESPMACSIV,
+	}
+	if len(Algorithms()) != len(direct) {
+		t.Fatalf("Algorithms: expected %d algorithms, got %v", len(direct), Algorithms())
+	}
+	nonce, pt, ad := make([]byte, 16), []byte("plaintext"), []byte("header")
+	for _, alg := range Algorithms() {
+		for _, size := range []int{32, 48, 64} {
+			key := make([]byte, size)
+			for i := range key {
+				key[i] = byte(i)
+			}
+			a, err := NewAEADByName(string(alg), key, len(nonce))
+			if err != nil {
+				t.Fatalf("%s: %d byte key: %s", alg, size, err)
+			}
+			if got := a.(AEAD).Algorithm(); got != alg {
+				t.Errorf("%s: Algorithm: got %q", alg, got)
+			}
+			ct := a.Seal(nil, nonce, pt, ad)
+			b, _ := direct[alg](key, len(nonce))
+			if want := b.Seal(nil, nonce, pt, ad); !bytes.Equal(ct, want) {
+				t.Errorf("%s: %d byte key: expected: %x\ngot: %x", alg, size, want, ct)
+			}
+			if x, err := a.Open(nil, nonce, ct, ad); err != nil || !bytes.Equal(x, pt) {
+				t.Errorf("%s: %d byte key: Open: %x (%v)", alg, size, x, err)
+			}
+		}
+	}
+
+	if _, err := NewAEADByName("AES-SIV", make([]byte, 16), 16); err != KeySizeError(16) {
+		t.Errorf("bad key: expected KeySizeError(16), got %v", err)
+	}
+	for _, alg := range []string{"", "aes-siv", "AES-GCM-SIV"} {
+		_, err := NewAEADByName(alg, make([]byte, 32), 16)
+		if err != UnknownAlgorithmError(alg) {
+			t.Errorf("%q: expected UnknownAlgorithmError, got %v", alg, err)
+			continue
+		}
+		if msg := err.Error(); !strings.Contains(msg, "AES-SIV, AES-PMAC-SIV") {
+			t.Errorf("%q: error doesn't list the supported algorithms: %s", alg, msg)
+		}
+	}
+}
+
+func TestCipherAlgorithm(t *testing.T) {
+	c, _ := NewAES(make([]byte, 32))
+	if alg := c.Algorithm(); alg != AlgorithmAESSIV {
+		t.Errorf("NewAES: got %q", alg)
+	}
+	c, _ = NewPMACSIV(make([]byte, 32))
+	if alg := c.Algorithm(); alg != AlgorithmAESPMACSIV {
+		t.Errorf("NewPMACSIV: got %q", alg)
+	}
+	a, _ := NewAEADAESRandomNonce(make([]byte, 32))
+	if alg := a.(interface{ Algorithm() Algorithm }).Algorithm(); alg != AlgorithmAESSIV {
+		t.Errorf("NewAEADAESRandomNonce: got %q", alg)
+	}
+}
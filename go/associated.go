@@ -0,0 +1,196 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+var ErrAssociatedDataUsed = errors.New("siv: associated data already used by Seal or Open")
+
+// readBufferSize is the size of the buffers ReadFrom reads associated data
+// into.
+const readBufferSize = 4096
+
+// readBuffers holds buffers for ReadFrom, which are zeroed before being
+// returned since they held associated data.
+var readBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, readBufferSize)
+		return &b
+	},
+}
+
+// AssociatedData computes S2V over associated data items which are written
+// to it in chunks, so that large items needn't be held in memory at once.
+//
+// Everything written between two calls to Next forms a single item, giving the
+// same result as passing the concatenation of the chunks to Cipher.Seal or
+// Cipher.Open as one item. Seal and Open then finish the computation, and the
+// AssociatedData can't be used again afterwards.
+//
+// An AssociatedData must not be used by multiple goroutines at once, but any
+// number of them may be in progress on the same Cipher.
+type AssociatedData struct {
+	c       *Cipher
+	st      *state
+	items   int
+	pending bool // whether the current item has been written to
+	done    bool
+}
+
+// NewAssociatedData returns an AssociatedData for a single call to Seal or
+// Open with c.
+func (c *Cipher) NewAssociatedData() *AssociatedData {
+	return &AssociatedData{c: c}
+}
+
+// start takes scratch space from the pool the first time it's needed.
+func (a *AssociatedData) start() error {
+	switch {
+	case a.done:
+		return ErrAssociatedDataUsed
+	case a.c.b == nil:
+		return ErrReset
+	case a.st == nil:
+		a.st = a.c.getState()
+		a.st.s2vStart(a.c.zeroMAC)
+	}
+	return nil
+}
+
+// Write adds p to the current associated data item.
+func (a *AssociatedData) Write(p []byte) (n int, err error) {
+	if err := a.start(); err != nil {
+		return 0, err
+	}
+	a.pending = true
+	return a.st.h.Write(p)
+}
+
+// ReadFrom adds everything read from r until EOF to the current associated
+// data item, reading into a pooled buffer rather than allocating one as
+// io.Copy would.
+func (a *AssociatedData) ReadFrom(r io.Reader) (n int64, err error) {
+	if err := a.start(); err != nil {
+		return 0, err
+	}
+	bp := readBuffers.Get().(*[]byte)
+	buf, used := *bp, 0
+	defer func() {
+		zero(buf[:used])
+		readBuffers.Put(bp)
+	}()
+	for {
+		m, err := r.Read(buf)
+		if m > used {
+			used = m
+		}
+		if m > 0 {
+			a.pending = true
+			a.st.h.Write(buf[:m])
+			n += int64(m)
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// Next ends the current associated data item, which is empty if nothing has
+// been written to it, and starts a new one.
+func (a *AssociatedData) Next() error {
+	if err := a.start(); err != nil {
+		return err
+	}
+	if a.items == MaxAssociatedDataItems {
+		return ErrTooManyAssociatedDataItems
+	}
+	a.st.s2vNext()
+	a.items++
+	a.pending = false
+	return nil
+}
+
+// finish ends the current item if it has been written to and returns the
+// state to compute S2V with.
+func (a *AssociatedData) finish() (*state, error) {
+	if err := a.start(); err != nil {
+		return nil, err
+	}
+	if a.pending {
+		if err := a.Next(); err != nil {
+			return nil, err
+		}
+	}
+	a.done = true
+	return a.st, nil
+}
+
+// release returns the scratch space to the pool if Seal or Open hasn't,
+// after which the AssociatedData can't be used.
+func (a *AssociatedData) release() {
+	if a.st != nil && !a.done {
+		a.c.putState(a.st)
+	}
+	a.done = true
+}
+
+// Seal encrypts and authenticates plaintext along with the associated data
+// written so far, as Cipher.Seal does.
+func (a *AssociatedData) Seal(dst, plaintext []byte) ([]byte, error) {
+	st, err := a.finish()
+	if err != nil {
+		return nil, err
+	}
+	defer a.c.putState(st)
+	return a.c.seal(st, dst, plaintext)
+}
+
+// Open decrypts and authenticates ciphertext along with the associated data
+// written so far, as Cipher.Open does.
+func (a *AssociatedData) Open(dst, ciphertext []byte) ([]byte, error) {
+	st, err := a.finish()
+	if err != nil {
+		return nil, err
+	}
+	defer a.c.putState(st)
+	return a.c.open(st, dst, ciphertext)
+}
+
+// SealReader is like Seal with a single associated data item, which is read
+// from r until EOF rather than held in memory. The result is the same as if
+// the bytes read were passed to Seal. If reading fails, SealReader returns the
+// error and nothing is appended to dst.
+func (c *Cipher) SealReader(dst, plaintext []byte, r io.Reader) ([]byte, error) {
+	a := c.NewAssociatedData()
+	defer a.release()
+	if err := a.readItem(r); err != nil {
+		return nil, err
+	}
+	return a.Seal(dst, plaintext)
+}
+
+// OpenReader is like Open with a single associated data item read from r
+// until EOF, and is the counterpart of SealReader.
+func (c *Cipher) OpenReader(dst, ciphertext []byte, r io.Reader) ([]byte, error) {
+	a := c.NewAssociatedData()
+	defer a.release()
+	if err := a.readItem(r); err != nil {
+		return nil, err
+	}
+	return a.Open(dst, ciphertext)
+}
+
+// readItem writes everything read from r as one associated data item.
+func (a *AssociatedData) readItem(r io.Reader) error {
+	if _, err := a.ReadFrom(r); err != nil {
+		return err
+	}
+	return a.Next()
+}
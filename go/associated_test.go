@@ -0,0 +1,228 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestAssociatedData(t *testing.T) {
+	// RFC 5297 A.2, with each item written in chunks of every size
+	v := testVectors[1]
+	c, err := NewAES(decode(v.key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad, gpt, gct := decodeAD(v.adata), decode(v.plaintext), decode(v.output)
+	for n := 1; n <= 17; n++ {
+		write := func(a *AssociatedData) {
+			for _, item := range ad {
+				for i := 0; i < len(item); i += n {
+					end := i + n
+					if end > len(item) {
+						end = len(item)
+					}
+					a.Write(item[i:end])
+				}
+				if err := a.Next(); err != nil {
+					t.Fatalf("Next: %s", err)
+				}
+			}
+		}
+
+		a := c.NewAssociatedData()
+		write(a)
+		ct, err := a.Seal(nil, gpt)
+		if err != nil {
+			t.Fatalf("Seal: %d byte chunks: %s", n, err)
+		}
+		if !bytes.Equal(gct, ct) {
+			t.Errorf("Seal: %d byte chunks: expected: %x\ngot: %x", n, gct, ct)
+		}
+
+		a = c.NewAssociatedData()
+		write(a)
+		pt, err := a.Open(nil, ct)
+		if err != nil {
+			t.Fatalf("Open: %d byte chunks: %s", n, err)
+		}
+		if !bytes.Equal(gpt, pt) {
+			t.Errorf("Open: %d byte chunks: expected: %x\ngot: %x", n, gpt, pt)
+		}
+	}
+}
+
+func TestAssociatedDataItems(t *testing.T) {
+	c, err := NewPMACSIV(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt := []byte("plaintext")
+
+	// The final item needn't be ended with Next
+	a := c.NewAssociatedData()
+	a.Write([]byte("header"))
+	got, _ := a.Seal(nil, pt)
+	want, _ := c.Seal(nil, pt, []byte("header"))
+	if !bytes.Equal(got, want) {
+		t.Errorf("Seal: one item: expected: %x\ngot: %x", want, got)
+	}
+
+	// Next without a Write adds an empty item
+	a = c.NewAssociatedData()
+	a.Next()
+	got, _ = a.Seal(nil, pt)
+	want, _ = c.Seal(nil, pt, []byte{})
+	if !bytes.Equal(got, want) {
+		t.Errorf("Seal: empty item: expected: %x\ngot: %x", want, got)
+	}
+
+	// No items at all
+	got, _ = c.NewAssociatedData().Seal(nil, pt)
+	want, _ = c.Seal(nil, pt)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Seal: no items: expected: %x\ngot: %x", want, got)
+	}
+
+	a = c.NewAssociatedData()
+	for i := 0; i < MaxAssociatedDataItems; i++ {
+		if err := a.Next(); err != nil {
+			t.Fatalf("Next: %d: %s", i, err)
+		}
+	}
+	if err := a.Next(); err != ErrTooManyAssociatedDataItems {
+		t.Errorf("Next: expected ErrTooManyAssociatedDataItems, got %v", err)
+	}
+	if _, err := a.Seal(nil, pt); err != nil {
+		t.Errorf("Seal: maximum items: %s", err)
+	}
+	if _, err := a.Seal(nil, pt); err != ErrAssociatedDataUsed {
+		t.Errorf("Seal: reused: expected ErrAssociatedDataUsed, got %v", err)
+	}
+	if _, err := a.Write(pt); err != ErrAssociatedDataUsed {
+		t.Errorf("Write: reused: expected ErrAssociatedDataUsed, got %v", err)
+	}
+
+	c.Reset()
+	if _, err := c.NewAssociatedData().Write(pt); err != ErrReset {
+		t.Errorf("Write: expected ErrReset, got %v", err)
+	}
+}
+
+func TestAssociatedDataSplit(t *testing.T) {
+	c, err := NewAES(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt := []byte("plaintext")
+	whole, _ := c.Seal(nil, pt, []byte("headerfields"))
+	split, _ := c.Seal(nil, pt, []byte("header"), []byte("fields"))
+	if bytes.Equal(whole[:16], split[:16]) {
+		t.Fatalf("Seal: splitting an item didn't change the tag: %x", whole[:16])
+	}
+
+	// Items are separate S2V inputs, and chunks of one item are concatenated
+	a := c.NewAssociatedData()
+	a.Write([]byte("head"))
+	a.Write([]byte("er"))
+	a.Next()
+	a.Write([]byte("fields"))
+	if got, _ := a.Seal(nil, pt); !bytes.Equal(got, split) {
+		t.Errorf("Seal: two items: expected: %x\ngot: %x", split, got)
+	}
+	a = c.NewAssociatedData()
+	a.Write([]byte("header"))
+	a.Write([]byte("fields"))
+	if got, _ := a.Seal(nil, pt); !bytes.Equal(got, whole) {
+		t.Errorf("Seal: one item in two chunks: expected: %x\ngot: %x", whole, got)
+	}
+}
+
+// errReader returns the data it holds, then err.
+type errReader struct {
+	data []byte
+	err  error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestSealReader(t *testing.T) {
+	c, err := NewAES(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt := []byte("plaintext")
+	for _, n := range []int{0, 1, 16, 100, 100000} {
+		ad := make([]byte, n)
+		for i := range ad {
+			ad[i] = byte(i)
+		}
+		want, _ := c.Seal(nil, pt, ad)
+		ct, err := c.SealReader(nil, pt, bytes.NewReader(ad))
+		if err != nil {
+			t.Fatalf("SealReader: %d bytes: %s", n, err)
+		}
+		if !bytes.Equal(want, ct) {
+			t.Errorf("SealReader: %d bytes: expected: %x\ngot: %x", n, want, ct)
+		}
+		x, err := c.OpenReader(nil, ct, bytes.NewReader(ad))
+		if err != nil || !bytes.Equal(x, pt) {
+			t.Errorf("OpenReader: %d bytes: %x (%v)", n, x, err)
+		}
+	}
+
+	readErr := errors.New("read failed")
+	dst := []byte("prefix")
+	if out, err := c.SealReader(dst, pt, &errReader{[]byte("partial"), readErr}); err != readErr || out != nil {
+		t.Errorf("SealReader: expected the read error, got %x (%v)", out, err)
+	}
+	if !bytes.Equal(dst, []byte("prefix")) {
+		t.Errorf("SealReader: dst was modified: %q", dst)
+	}
+	ct, _ := c.Seal(nil, pt, []byte("partial"))
+	if _, err := c.OpenReader(nil, ct, &errReader{[]byte("partial"), readErr}); err != readErr {
+		t.Errorf("OpenReader: expected the read error, got %v", err)
+	}
+
+	if raceEnabled {
+		return
+	}
+	ad := make([]byte, 10000)
+	br := bytes.NewReader(ad)
+	var r io.Reader = onlyReader{br}
+	out := make([]byte, 0, len(pt)+c.Overhead())
+	allocs := testing.AllocsPerRun(100, func() {
+		br.Reset(ad)
+		c.SealReader(out, pt, r)
+	})
+	if allocs != 0 {
+		t.Errorf("SealReader: expected no allocations, got %v", allocs)
+	}
+}
+
+// onlyReader hides any WriteTo method of the reader it wraps, as a file or
+// network connection would lack one.
+type onlyReader struct{ r io.Reader }
+
+func (r onlyReader) Read(p []byte) (int, error) { return r.r.Read(p) }
+
+func BenchmarkSealReader(b *testing.B) {
+	c, _ := NewAES(make([]byte, 32))
+	ad, m := make([]byte, 64<<10), make([]byte, 1<<10)
+	out := make([]byte, 0, len(m)+c.Overhead())
+	b.SetBytes(int64(len(ad) + len(m)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.SealReader(out, m, onlyReader{bytes.NewReader(ad)})
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"crypto/cipher"
+	"errors"
+	"sync"
+)
+
+var ErrAuditCapacity = errors.New("siv: audit capacity must be positive")
+
+// auditAEAD is a cipher.AEAD which remembers the synthetic IVs produced by
+// the AEAD it wraps, reporting any that repeat.
+type auditAEAD struct {
+	cipher.AEAD
+	onRepeat func(iv []byte)
+
+	mu   sync.Mutex
+	seen map[[TagSize]byte]struct{}
+	ivs  [][TagSize]byte // the IVs in seen, oldest first from next
+	next int
+}
+
+// NewNonceAuditAEAD returns a cipher.AEAD which seals and opens as inner
+// does, and calls onRepeat with a copy of the synthetic IV whenever Seal
+// produces one it has produced before. A repeated IV means the same key,
+// nonce, associated data and plaintext were sealed twice, so the ciphertexts
+// reveal that the messages are equal. It is intended for tests which want to
+// catch accidentally deterministic use of an AEAD; nothing is audited unless
+// an AEAD is wrapped.
+//
+// Only the most recent capacity IVs are remembered, so memory use is bounded
+// and repeats further apart than that go unreported. It returns
+// ErrAuditCapacity unless capacity is positive. inner must be an AEAD returned
+// by this package, with the synthetic IV at the end of its Overhead().
+//
+// The returned AEAD is safe for concurrent use, and onRepeat may be called
+// from several goroutines at once.
+func NewNonceAuditAEAD(inner cipher.AEAD, capacity int, onRepeat func(iv []byte)) (cipher.AEAD, error) {
+	if capacity <= 0 {
+		return nil, ErrAuditCapacity
+	}
+	return &auditAEAD{
+		AEAD:     inner,
+		onRepeat: onRepeat,
+		seen:     make(map[[TagSize]byte]struct{}, capacity),
+		ivs:      make([][TagSize]byte, 0, capacity),
+	}, nil
+}
+
+// Seal seals plaintext with the wrapped AEAD and records the synthetic IV of
+// the result.
+func (a *auditAEAD) Seal(dst, nonce, plaintext, data []byte) []byte {
+	out := a.AEAD.Seal(dst, nonce, plaintext, data)
+	var iv [TagSize]byte
+	end := len(dst) + a.Overhead()
+	copy(iv[:], out[end-TagSize:end])
+	if a.record(iv) && a.onRepeat != nil {
+		a.onRepeat(iv[:])
+	}
+	return out
+}
+
+// record adds iv to the set of recently seen IVs, evicting the oldest if the
+// set is full, and reports whether it was already there.
+func (a *auditAEAD) record(iv [TagSize]byte) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.seen[iv]; ok {
+		return true
+	}
+	if len(a.ivs) < cap(a.ivs) {
+		a.ivs = append(a.ivs, iv)
+	} else {
+		delete(a.seen, a.ivs[a.next])
+		a.ivs[a.next] = iv
+		a.next = (a.next + 1) % len(a.ivs)
+	}
+	a.seen[iv] = struct{}{}
+	return false
+}
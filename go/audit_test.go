@@ -0,0 +1,81 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestNonceAuditAEAD(t *testing.T) {
+	key, nonce := make([]byte, 32), make([]byte, 16)
+	inner, _ := NewAEADAES(key, len(nonce))
+	var repeats [][]byte
+	a, err := NewNonceAuditAEAD(inner, 2, func(iv []byte) { repeats = append(repeats, iv) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ct := a.Seal([]byte("prefix"), nonce, []byte("one"), nil)
+	a.Seal(nil, nonce, []byte("two"), nil)
+	if len(repeats) != 0 {
+		t.Fatalf("Seal: differing plaintexts reported as repeats: %x", repeats)
+	}
+	a.Seal(nil, nonce, []byte("one"), nil)
+	if len(repeats) != 1 || !bytes.Equal(repeats[0], ct[6:6+TagSize]) {
+		t.Fatalf("Seal: expected the repeated IV %x, got %x", ct[6:6+TagSize], repeats)
+	}
+	if pt, err := a.Open(nil, nonce, ct[6:], nil); err != nil || string(pt) != "one" {
+		t.Errorf("Open: %q (%v)", pt, err)
+	}
+
+	// Only the last two IVs are remembered
+	a.Seal(nil, nonce, []byte("three"), nil)
+	a.Seal(nil, nonce, []byte("four"), nil)
+	a.Seal(nil, nonce, []byte("one"), nil)
+	if len(repeats) != 1 {
+		t.Errorf("Seal: evicted IV reported as a repeat")
+	}
+
+	// The IV follows the nonce embedded by NewAEADAESRandomNonce
+	r, _ := NewAEADAESRandomNonce(key)
+	repeats = nil
+	a, _ = NewNonceAuditAEAD(r, 10, func(iv []byte) { repeats = append(repeats, iv) })
+	for i := 0; i < 5; i++ {
+		a.Seal(nil, nil, []byte("same"), nil)
+	}
+	if len(repeats) != 0 {
+		t.Errorf("Seal: random nonces reported as repeats: %x", repeats)
+	}
+
+	if _, err := NewNonceAuditAEAD(inner, 0, nil); err != ErrAuditCapacity {
+		t.Errorf("NewNonceAuditAEAD: expected ErrAuditCapacity, got %v", err)
+	}
+}
+
+func TestNonceAuditAEADConcurrent(t *testing.T) {
+	inner, _ := NewAEADAES(make([]byte, 32), 16)
+	var mu sync.Mutex
+	repeats := 0
+	a, _ := NewNonceAuditAEAD(inner, 1000, func([]byte) {
+		mu.Lock()
+		repeats++
+		mu.Unlock()
+	})
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				a.Seal(nil, make([]byte, 16), []byte(strconv.Itoa(i)), nil)
+			}
+		}()
+	}
+	wg.Wait()
+	if repeats != 7*100 {
+		t.Errorf("expected %d repeats, got %d", 7*100, repeats)
+	}
+}
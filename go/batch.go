@@ -0,0 +1,204 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// batchWorkerSize is the smallest number of messages SealBatch and OpenBatch
+// give each goroutine; smaller batches are handled by the calling goroutine
+// alone, as starting more would cost more than it saves.
+const batchWorkerSize = 256
+
+var ErrBatchSize = errors.New("siv: batch slices must have the same length")
+
+// BatchError is the error OpenBatch returns when some of the messages of a
+// batch fail to open.
+type BatchError struct {
+	// Errs holds the error for each message of the batch, which is nil for
+	// those which opened.
+	Errs []error
+}
+
+func (e *BatchError) Error() string {
+	failed, first := 0, -1
+	for i, err := range e.Errs {
+		if err != nil {
+			if first < 0 {
+				first = i
+			}
+			failed++
+		}
+	}
+	if first < 0 {
+		return "siv: no messages of the batch failed"
+	}
+	return "siv: " + strconv.Itoa(failed) + " of " + strconv.Itoa(len(e.Errs)) +
+		" messages failed to open, the first, at index " + strconv.Itoa(first) + ", with: " + e.Errs[first].Error()
+}
+
+// Unwrap returns the error of the first message which failed to open, so
+// that errors.Is(err, ErrNotAuthentic) holds when that was the reason.
+func (e *BatchError) Unwrap() error {
+	for _, err := range e.Errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SealBatch seals each of plaintexts with the nonce and associated data of
+// the same index, as the AEAD returned by NewAEADAES(key, len(nonce)) would,
+// so each ciphertext can be opened by such an AEAD, by DecryptAES, or by
+// OpenBatch. An empty nonce selects deterministic encryption, and nil
+// associated data is left out. nonces and ads may be nil, for no nonces or no
+// associated data at all; otherwise they must be as long as plaintexts, or
+// ErrBatchSize is returned.
+//
+// The scratch space is set up once per goroutine rather than for each
+// message, and the ciphertexts are slices of a single allocation, with no
+// spare capacity between them. Batches of more than a few hundred messages
+// are spread across up to GOMAXPROCS goroutines.
+func (c *Cipher) SealBatch(nonces, plaintexts, ads [][]byte) ([][]byte, error) {
+	if c.b == nil {
+		return nil, ErrReset
+	}
+	if !batchSize(len(plaintexts), nonces, ads) {
+		return nil, ErrBatchSize
+	}
+	total := 0
+	for _, pt := range plaintexts {
+		if err := checkSealSize(total, c.Overhead(), len(pt)); err != nil {
+			return nil, err
+		}
+		total += c.Overhead() + len(pt)
+	}
+
+	buf := make([]byte, total)
+	out := make([][]byte, len(plaintexts))
+	for i, pt := range plaintexts {
+		n := c.Overhead() + len(pt)
+		out[i], buf = buf[:0:n], buf[n:]
+	}
+	c.batch(len(plaintexts), func(st *state, i int) {
+		st.s2vMessage(c.zeroMAC, batchItem(nonces, i), batchItem(ads, i))
+		// out[i] has exactly the capacity needed, so this can't fail
+		out[i], _ = c.seal(st, out[i], plaintexts[i])
+	})
+	return out, nil
+}
+
+// OpenBatch opens each of ciphertexts, sealed as by SealBatch, with the
+// nonce and associated data of the same index, which are as for SealBatch.
+// Messages which fail to open don't stop the others: their plaintexts are
+// nil, and the error is a *BatchError holding the error of each message.
+// Otherwise the error is nil, or ErrBatchSize or ErrReset if the batch
+// couldn't be opened at all. As for SealBatch, the plaintexts are slices of a
+// single allocation.
+func (c *Cipher) OpenBatch(nonces, ciphertexts, ads [][]byte) ([][]byte, error) {
+	if c.b == nil {
+		return nil, ErrReset
+	}
+	if !batchSize(len(ciphertexts), nonces, ads) {
+		return nil, ErrBatchSize
+	}
+	total := 0
+	for _, ct := range ciphertexts {
+		if len(ct) > c.Overhead() {
+			total += len(ct) - c.Overhead()
+		}
+	}
+
+	buf := make([]byte, total)
+	out := make([][]byte, len(ciphertexts))
+	for i, ct := range ciphertexts {
+		n := len(ct) - c.Overhead()
+		if n < 0 {
+			n = 0
+		}
+		out[i], buf = buf[:0:n], buf[n:]
+	}
+	var errs []error
+	var mu sync.Mutex
+	c.batch(len(ciphertexts), func(st *state, i int) {
+		st.s2vMessage(c.zeroMAC, batchItem(nonces, i), batchItem(ads, i))
+		var err error
+		if out[i], err = c.open(st, out[i], ciphertexts[i]); err != nil {
+			mu.Lock()
+			if errs == nil {
+				errs = make([]error, len(ciphertexts))
+			}
+			errs[i] = err
+			mu.Unlock()
+		}
+	})
+	if errs != nil {
+		return out, &BatchError{Errs: errs}
+	}
+	return out, nil
+}
+
+// batch calls f with each index of a batch of n messages, and a state which
+// f may reuse from one message to the next, spreading the indexes across
+// goroutines for large batches.
+func (c *Cipher) batch(n int, f func(st *state, i int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if max := n / batchWorkerSize; workers > max {
+		workers = max
+	}
+	if workers <= 1 {
+		st := c.getState()
+		defer c.putState(st)
+		for i := 0; i < n; i++ {
+			f(st, i)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			st := c.getState()
+			defer c.putState(st)
+			for i := start; i < end; i++ {
+				f(st, i)
+			}
+		}(w*n/workers, (w+1)*n/workers)
+	}
+	wg.Wait()
+}
+
+// batchSize reports whether nonces and ads are either nil or of length n.
+func batchSize(n int, nonces, ads [][]byte) bool {
+	return (nonces == nil || len(nonces) == n) && (ads == nil || len(ads) == n)
+}
+
+// batchItem returns items[i], or nil if items is nil.
+func batchItem(items [][]byte, i int) []byte {
+	if items == nil {
+		return nil
+	}
+	return items[i]
+}
+
+// s2vMessage is s2vStart followed by data, unless it is nil, and nonce,
+// unless it is empty, the S2V inputs the AEAD returned by NewAEADAES passes
+// for them.
+func (st *state) s2vMessage(zeroMAC, nonce, data []byte) {
+	st.s2vStart(zeroMAC)
+	if data != nil {
+		st.h.Write(data)
+		st.s2vNext()
+	}
+	if len(nonce) > 0 {
+		st.h.Write(nonce)
+		st.s2vNext()
+	}
+}
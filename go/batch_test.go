@@ -0,0 +1,150 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+// batchMessages returns n random messages, with some nonces and associated
+// data left empty.
+func batchMessages(n int) (nonces, plaintexts, ads [][]byte) {
+	r := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		r.Read(b)
+		return b
+	}
+	for i := 0; i < n; i++ {
+		nonces = append(nonces, random([]int{0, 12, 16}[r.Intn(3)]))
+		plaintexts = append(plaintexts, random(r.Intn(40)))
+		ads = append(ads, random([]int{0, 5}[r.Intn(2)]))
+	}
+	return
+}
+
+func TestSealBatch(t *testing.T) {
+	// Large batches are only split with more than one P
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	key := countingKey(32)
+	c, _ := NewAES(key)
+	// Small enough for one goroutine, and large enough for several
+	for _, n := range []int{0, 1, 10, 4 * batchWorkerSize} {
+		nonces, pts, ads := batchMessages(n)
+		cts, err := c.SealBatch(nonces, pts, ads)
+		if err != nil || len(cts) != n {
+			t.Fatalf("SealBatch: %d messages: %d (%v)", n, len(cts), err)
+		}
+		for i := range pts {
+			want, _ := EncryptAES(key, nonces[i], pts[i], ads[i])
+			if !bytes.Equal(cts[i], want) {
+				t.Fatalf("SealBatch: %d messages: %d: expected: %x\ngot: %x", n, i, want, cts[i])
+			}
+			if cap(cts[i]) != len(cts[i]) {
+				t.Errorf("SealBatch: %d: spare capacity %d", i, cap(cts[i])-len(cts[i]))
+			}
+		}
+
+		out, err := c.OpenBatch(nonces, cts, ads)
+		if err != nil || len(out) != n {
+			t.Fatalf("OpenBatch: %d messages: %d (%v)", n, len(out), err)
+		}
+		for i := range out {
+			if !bytes.Equal(out[i], pts[i]) {
+				t.Fatalf("OpenBatch: %d messages: %d: expected: %x\ngot: %x", n, i, pts[i], out[i])
+			}
+		}
+	}
+
+	// Without nonces or associated data
+	_, pts, _ := batchMessages(3)
+	cts, err := c.SealBatch(nil, pts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range pts {
+		if want, _ := c.Seal(nil, pts[i]); !bytes.Equal(cts[i], want) {
+			t.Errorf("SealBatch: no nonces: %d: expected: %x\ngot: %x", i, want, cts[i])
+		}
+	}
+	if out, err := c.OpenBatch(nil, cts, nil); err != nil || !bytes.Equal(out[2], pts[2]) {
+		t.Errorf("OpenBatch: no nonces: %x (%v)", out, err)
+	}
+
+	if _, err := c.SealBatch(make([][]byte, 2), pts, nil); err != ErrBatchSize {
+		t.Errorf("SealBatch: expected ErrBatchSize, got %v", err)
+	}
+	if _, err := c.OpenBatch(nil, cts, make([][]byte, 4)); err != ErrBatchSize {
+		t.Errorf("OpenBatch: expected ErrBatchSize, got %v", err)
+	}
+	c.Reset()
+	if _, err := c.SealBatch(nil, pts, nil); err != ErrReset {
+		t.Errorf("SealBatch: expected ErrReset, got %v", err)
+	}
+}
+
+func TestOpenBatchErrors(t *testing.T) {
+	// Large batches are only split with more than one P
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	c, _ := NewAES(countingKey(32))
+	for _, n := range []int{10, 4 * batchWorkerSize} {
+		nonces, pts, ads := batchMessages(n)
+		cts, _ := c.SealBatch(nonces, pts, ads)
+		bad := map[int]error{3: ErrNotAuthentic, 7: ErrTooShort, n - 1: ErrNotAuthentic}
+		cts[3][0] ^= 1
+		cts[7] = cts[7][:TagSize-1]
+		cts[n-1] = append(cts[n-1], 0)
+
+		out, err := c.OpenBatch(nonces, cts, ads)
+		var be *BatchError
+		if !errors.As(err, &be) || len(be.Errs) != n {
+			t.Fatalf("OpenBatch: %d messages: expected a BatchError, got %v", n, err)
+		}
+		if !errors.Is(err, ErrNotAuthentic) {
+			t.Errorf("OpenBatch: %d messages: expected the first failure to be ErrNotAuthentic: %v", n, err)
+		}
+		for i := range cts {
+			if want := bad[i]; be.Errs[i] != want {
+				t.Errorf("OpenBatch: %d messages: %d: expected %v, got %v", n, i, want, be.Errs[i])
+			}
+			if _, ok := bad[i]; ok {
+				if out[i] != nil {
+					t.Errorf("OpenBatch: %d messages: %d: failed message returned %x", n, i, out[i])
+				}
+			} else if !bytes.Equal(out[i], pts[i]) {
+				t.Errorf("OpenBatch: %d messages: %d: expected: %x\ngot: %x", n, i, pts[i], out[i])
+			}
+		}
+	}
+}
+
+func BenchmarkSealBatch(b *testing.B) {
+	const records = 10000
+	key, nonce := make([]byte, 32), make([]byte, 16)
+	pts, nonces, ads := make([][]byte, records), make([][]byte, records), make([][]byte, records)
+	for i := range pts {
+		pts[i], nonces[i], ads[i] = make([]byte, 128), nonce, []byte("record header")
+	}
+	b.Run("Loop", func(b *testing.B) {
+		a, _ := NewAEADAES(key, len(nonce))
+		b.ReportAllocs()
+		b.SetBytes(records * 128)
+		for i := 0; i < b.N; i++ {
+			for j := range pts {
+				a.Seal(nil, nonces[j], pts[j], ads[j])
+			}
+		}
+	})
+	b.Run("Batch", func(b *testing.B) {
+		c, _ := NewAES(key)
+		b.ReportAllocs()
+		b.SetBytes(records * 128)
+		for i := 0; i < b.N; i++ {
+			c.SealBatch(nonces, pts, ads)
+		}
+	})
+}
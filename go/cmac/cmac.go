@@ -10,6 +10,7 @@ package cmac
 import (
 	"crypto/cipher"
 	"crypto/subtle"
+	"encoding/binary"
 	"errors"
 	"hash"
 )
@@ -70,6 +71,37 @@ func (d *cmac) Reset() {
 	d.p = 0
 }
 
+// Wipe overwrites the subkeys and digest state with zeros. The digest must
+// not be used afterwards.
+func (d *cmac) Wipe() {
+	d.Reset()
+	for i := range d.k1 {
+		d.k1[i] = 0
+	}
+	for i := range d.k2 {
+		d.k2[i] = 0
+	}
+}
+
+// Clone returns a copy of the digest in its current state. The copy shares
+// the subkeys of d, so messages can be digested concurrently without
+// generating them again, and Wipe on either digest wipes the subkeys of both.
+func (d *cmac) Clone() hash.Hash {
+	c := *d
+	c.ci = append([]byte(nil), d.ci...)
+	c.digest = make([]byte, len(d.digest))
+	return &c
+}
+
+// Copy is like Clone, but the copy has subkeys of its own, so Wipe on either
+// digest leaves the other's intact. The block cipher is still shared.
+func (d *cmac) Copy() hash.Hash {
+	c := d.Clone().(*cmac)
+	c.k1 = append([]byte(nil), d.k1...)
+	c.k2 = append([]byte(nil), d.k2...)
+	return c
+}
+
 // Write adds the given data to the digest state.
 func (d *cmac) Write(p []byte) (nn int, err error) {
 	nn = len(p)
@@ -106,9 +138,8 @@ func (d *cmac) Sum(in []byte) []byte {
 	if d.p < len(d.digest) {
 		k = d.k2
 	}
-	for i := 0; i < len(d.ci); i++ {
-		d.digest[i] = d.ci[i] ^ k[i]
-	}
+	copy(d.digest, d.ci)
+	xor(d.digest, k)
 	if d.p < len(d.digest) {
 		d.digest[d.p] ^= 0x80
 	}
@@ -131,6 +162,13 @@ func shift1(src, dst []byte) int {
 }
 
 func xor(a, b []byte) {
+	if len(b) == 16 && len(a) >= 16 {
+		// A block at a time, as the compiler turns these into single loads
+		// and stores
+		binary.LittleEndian.PutUint64(a, binary.LittleEndian.Uint64(a)^binary.LittleEndian.Uint64(b))
+		binary.LittleEndian.PutUint64(a[8:], binary.LittleEndian.Uint64(a[8:])^binary.LittleEndian.Uint64(b[8:]))
+		return
+	}
 	for i, v := range b {
 		a[i] ^= v
 	}
@@ -9,6 +9,7 @@ package cmac
 import (
 	"bytes"
 	"crypto/aes"
+	"encoding/hex"
 	"testing"
 )
 
@@ -210,3 +211,139 @@ func BenchmarkCMAC_AES128(b *testing.B) {
 		out = d.Sum(out[:0])
 	}
 }
+
+func TestWipe(t *testing.T) {
+	c, err := aes.NewCipher(commonKey128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Write(cmacAESTests[2].in)
+
+	x := d.(*cmac)
+	x.Wipe()
+	for _, b := range [][]byte{x.k1, x.k2, x.ci, x.digest} {
+		if !bytes.Equal(b, make([]byte, len(b))) {
+			t.Fatalf("Wipe: state not zeroed\n\tk1 %x\n\tk2 %x\n\tci %x", x.k1, x.k2, x.ci)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	tt := cmacAESTests[len(cmacAESTests)-1]
+	c, err := aes.NewCipher(tt.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Write(tt.in[:len(tt.in)/2])
+
+	// The clone continues from the state of the original, independently of it
+	x := d.(*cmac).Clone()
+	x.Write(tt.in[len(tt.in)/2:])
+	if sum := x.Sum(nil); !bytes.Equal(sum, tt.digest) {
+		t.Fatalf("clone: digest mismatch\n\twant %x\n\thave %x", tt.digest, sum)
+	}
+	d.Write(tt.in[len(tt.in)/2:])
+	if sum := d.Sum(nil); !bytes.Equal(sum, tt.digest) {
+		t.Fatalf("original: digest mismatch\n\twant %x\n\thave %x", tt.digest, sum)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	tt := cmacAESTests[len(cmacAESTests)-1]
+	c, err := aes.NewCipher(tt.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Write(tt.in[:len(tt.in)/2])
+
+	// The copy continues from the state of the original, and still works
+	// once the original is wiped
+	x := d.(*cmac).Copy()
+	d.(*cmac).Wipe()
+	x.Write(tt.in[len(tt.in)/2:])
+	if sum := x.Sum(nil); !bytes.Equal(sum, tt.digest) {
+		t.Fatalf("copy: digest mismatch\n\twant %x\n\thave %x", tt.digest, sum)
+	}
+
+	// And wiping the copy leaves a clone of it alone, unlike Clone
+	y := x.(*cmac).Copy()
+	x.(*cmac).Wipe()
+	y.Reset()
+	y.Write(tt.in)
+	if sum := y.Sum(nil); !bytes.Equal(sum, tt.digest) {
+		t.Fatalf("copy of copy: digest mismatch\n\twant %x\n\thave %x", tt.digest, sum)
+	}
+}
+
+func TestSubkeys(t *testing.T) {
+	// RFC 4493 section 4, subkey generation
+	c, err := aes.NewCipher(commonKey128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := d.(*cmac)
+	if k1 := hex.EncodeToString(x.k1); k1 != "fbeed618357133667c85e08f7236a8de" {
+		t.Errorf("K1: got %s", k1)
+	}
+	if k2 := hex.EncodeToString(x.k2); k2 != "f7ddac306ae266ccf90bc11ee46d513b" {
+		t.Errorf("K2: got %s", k2)
+	}
+}
+
+func TestFinalBlock(t *testing.T) {
+	c, err := aes.NewCipher(commonKey128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := d.(*cmac)
+
+	// A complete final block is xored with K1
+	msg := cmacAESTests[1].in[:16]
+	want := make([]byte, 16)
+	for i := range want {
+		want[i] = msg[i] ^ x.k1[i]
+	}
+	c.Encrypt(want, want)
+	d.Write(msg)
+	if sum := d.Sum(nil); !bytes.Equal(sum, want) {
+		t.Errorf("complete block: expected %x, got %x", want, sum)
+	}
+
+	// An incomplete final block, such as the empty message, is padded with
+	// 10* and xored with K2
+	for _, n := range []int{0, 1, 15} {
+		msg := cmacAESTests[1].in[:n]
+		want := make([]byte, 16)
+		copy(want, msg)
+		want[n] = 0x80
+		for i := range want {
+			want[i] ^= x.k2[i]
+		}
+		c.Encrypt(want, want)
+		d.Reset()
+		d.Write(msg)
+		if sum := d.Sum(nil); !bytes.Equal(sum, want) {
+			t.Errorf("%d byte block: expected %x, got %x", n, want, sum)
+		}
+	}
+}
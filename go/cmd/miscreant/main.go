@@ -0,0 +1,333 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+// Command miscreant encrypts and decrypts data with AES-SIV or AES-PMAC-SIV
+// from the shell, and checks this implementation against the test vectors.
+//
+// Usage:
+//
+//	miscreant seal -key-file FILE [-alg ALG] [-nonce HEX] [-ad HEX]... < plaintext > ciphertext
+//	miscreant open -key-file FILE [-alg ALG] [-nonce HEX] [-ad HEX]... < ciphertext > plaintext
+//	miscreant seal -stream -key-file FILE -nonce HEX < plaintext > ciphertext
+//	miscreant open -stream -key-file FILE -nonce HEX < ciphertext > plaintext
+//	miscreant verify-vectors [-dir DIR]
+//
+// The key file holds either the raw key of 32, 48 or 64 bytes, or its hex or
+// base64 encoding, as accepted by miscreant.ParseKey. A key file made only of
+// printable text is always decoded, and rejected if it isn't valid hex or
+// base64. Each -ad flag adds an
+// associated data item, which are passed to S2V as separate inputs in the
+// order given, followed by the nonce if there is one; this is the order the
+// other Miscreant implementations use.
+//
+// Without -stream, the whole input is read into memory and sealed or opened
+// at once. With -stream, the input is processed in chunks with AES-SIV in
+// STREAM mode, as by miscreant.NewEncryptWriter, so inputs larger than
+// memory can be handled. The nonce is then the 8-byte STREAM nonce prefix,
+// and associated data isn't supported.
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	miscreant "github.com/miscreant/miscreant/go"
+	"github.com/miscreant/miscreant/go/internal/tjson"
+)
+
+// streamChunkSize is the plaintext chunk size used with -stream.
+const streamChunkSize = 64 << 10
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "miscreant:", err)
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("usage: miscreant seal|open|verify-vectors [flags]")
+
+// run executes the subcommand named by args[0] with the remaining arguments
+// as its flags.
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "seal", "open":
+		return runSIV(args[0], args[1:], stdin, stdout)
+	case "verify-vectors":
+		return runVerifyVectors(args[1:], stdout)
+	default:
+		return errUsage
+	}
+}
+
+// hexList is a repeatable flag of hex encoded values.
+type hexList [][]byte
+
+func (l *hexList) String() string {
+	s := make([]string, len(*l))
+	for i, b := range *l {
+		s[i] = hex.EncodeToString(b)
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *hexList) Set(s string) error {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, b)
+	return nil
+}
+
+// runSIV seals or opens stdin to stdout.
+func runSIV(cmd string, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	keyFile := fs.String("key-file", "", "file holding the raw, hex or base64 encoded key")
+	alg := fs.String("alg", string(miscreant.AlgorithmAESSIV), "algorithm: AES-SIV or AES-PMAC-SIV")
+	nonceHex := fs.String("nonce", "", "hex encoded nonce, or STREAM nonce prefix with -stream")
+	stream := fs.Bool("stream", false, "process the input in chunks using STREAM")
+	var ad hexList
+	fs.Var(&ad, "ad", "hex encoded associated data item (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *keyFile == "" {
+		return errors.New("-key-file is required")
+	}
+	key, err := readKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	nonce, err := hex.DecodeString(*nonceHex)
+	if err != nil {
+		return fmt.Errorf("-nonce: %s", err)
+	}
+
+	if *stream {
+		if len(ad) > 0 {
+			return errors.New("-ad is not supported with -stream")
+		}
+		if *alg != string(miscreant.AlgorithmAESSIV) {
+			return errors.New("-stream only supports AES-SIV")
+		}
+		return runStream(cmd, key, nonce, stdin, stdout)
+	}
+
+	var c *miscreant.Cipher
+	switch miscreant.Algorithm(*alg) {
+	case miscreant.AlgorithmAESSIV:
+		c, err = miscreant.NewAES(key)
+	case miscreant.AlgorithmAESPMACSIV:
+		c, err = miscreant.NewPMACSIV(key)
+	default:
+		err = miscreant.UnknownAlgorithmError(*alg)
+	}
+	if err != nil {
+		return err
+	}
+	defer c.Reset()
+
+	items := [][]byte(ad)
+	if len(nonce) > 0 {
+		items = append(items, nonce)
+	}
+	in, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return err
+	}
+	var out []byte
+	if cmd == "seal" {
+		out, err = c.Seal(nil, in, items...)
+	} else {
+		out, err = c.Open(nil, in, items...)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(out)
+	return err
+}
+
+// runStream seals or opens stdin to stdout in STREAM mode.
+func runStream(cmd string, key, prefix []byte, stdin io.Reader, stdout io.Writer) error {
+	if cmd == "open" {
+		r, err := miscreant.NewDecryptReader(key, prefix, stdin)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(stdout, r)
+		return err
+	}
+	w, err := miscreant.NewEncryptWriter(key, prefix, streamChunkSize, stdout)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, stdin); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// readKey reads a key from file. A file holding only printable ASCII text is
+// decoded with miscreant.ParseKey, and its error returned, so that a mistyped
+// hex or base64 key fails with miscreant.ErrKeyEncoding instead of being used
+// as a raw key; any other file is the raw key.
+func readKey(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if !isText(data) {
+		return data, nil
+	}
+	return miscreant.ParseKey(string(data))
+}
+
+// isText reports whether data is made only of printable ASCII characters and
+// whitespace.
+func isText(data []byte) bool {
+	for _, b := range data {
+		if (b < ' ' || b > '~') && b != '\t' && b != '\n' && b != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// runVerifyVectors checks the AES-SIV, AES-PMAC-SIV and STREAM test vectors
+// in a directory, printing a line for each one. It fails if any don't match.
+// Files the directory doesn't have are skipped: the shared vectors directory
+// has no STREAM vectors, which are in the testdata directory of the Go
+// package instead.
+func runVerifyVectors(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("verify-vectors", flag.ContinueOnError)
+	dir := fs.String("dir", "vectors", "directory holding the TJSON test vectors")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	failed := 0
+	report := func(file string, i int, ex map[string]interface{}, err error) {
+		name, _ := ex["name"].(string)
+		if name == "" {
+			name = fmt.Sprintf("example %d", i)
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(stdout, "FAIL %s: %s: %s\n", file, name, err)
+		} else {
+			fmt.Fprintf(stdout, "PASS %s: %s\n", file, name)
+		}
+	}
+	for _, tc := range []struct {
+		file   string
+		verify func(map[string]interface{}) error
+	}{
+		{"aes_siv.tjson", func(ex map[string]interface{}) error { return verifySIV(miscreant.NewAES, ex) }},
+		{"aes_pmac_siv.tjson", func(ex map[string]interface{}) error { return verifySIV(miscreant.NewPMACSIV, ex) }},
+		{"aes_siv_stream.tjson", verifyStream},
+	} {
+		examples, err := loadExamples(filepath.Join(*dir, tc.file))
+		if os.IsNotExist(err) {
+			fmt.Fprintf(stdout, "SKIP %s: not found\n", tc.file)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for i, ex := range examples {
+			report(tc.file, i, ex, tc.verify(ex))
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d vectors failed", failed)
+	}
+	return nil
+}
+
+// loadExamples returns the examples in a test vector file.
+func loadExamples(file string) ([]map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := tjson.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	a, ok := doc["examples"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: no examples", file)
+	}
+	examples := make([]map[string]interface{}, len(a))
+	for i, x := range a {
+		if examples[i], ok = x.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("%s: example %d is not an object", file, i)
+		}
+	}
+	return examples, nil
+}
+
+// verifySIV checks that an AES-SIV or AES-PMAC-SIV example seals and opens.
+func verifySIV(newCipher func([]byte) (*miscreant.Cipher, error), ex map[string]interface{}) error {
+	key, _ := ex["key"].([]byte)
+	ad, _ := ex["ad"].([][]byte)
+	pt, _ := ex["plaintext"].([]byte)
+	ct, _ := ex["ciphertext"].([]byte)
+	c, err := newCipher(key)
+	if err != nil {
+		return err
+	}
+	if out, err := c.Seal(nil, pt, ad...); err != nil || !bytes.Equal(out, ct) {
+		return fmt.Errorf("Seal: expected %x, got %x (%v)", ct, out, err)
+	}
+	if out, err := c.Open(nil, ct, ad...); err != nil || !bytes.Equal(out, pt) {
+		return fmt.Errorf("Open: expected %x, got %x (%v)", pt, out, err)
+	}
+	return nil
+}
+
+// verifyStream checks that each segment of a STREAM example seals and opens.
+func verifyStream(ex map[string]interface{}) error {
+	alg, _ := ex["alg"].(string)
+	key, _ := ex["key"].([]byte)
+	prefix, _ := ex["nonce"].([]byte)
+	blocks, _ := ex["blocks"].([]interface{})
+	newAEAD := func(key []byte, nonceSize int) (cipher.AEAD, error) {
+		return miscreant.NewAEADByName(alg, key, nonceSize)
+	}
+	enc, err := miscreant.NewStreamEncryptor(newAEAD, key, prefix)
+	if err != nil {
+		return err
+	}
+	dec, err := miscreant.NewStreamDecryptor(newAEAD, key, prefix)
+	if err != nil {
+		return err
+	}
+	for i, x := range blocks {
+		b, _ := x.(map[string]interface{})
+		ad, _ := b["ad"].([]byte)
+		pt, _ := b["plaintext"].([]byte)
+		ct, _ := b["ciphertext"].([]byte)
+		last := i == len(blocks)-1
+		if out, err := enc.Seal(nil, pt, ad, last); err != nil || !bytes.Equal(out, ct) {
+			return fmt.Errorf("segment %d: Seal: expected %x, got %x (%v)", i, ct, out, err)
+		}
+		if out, err := dec.Open(nil, ct, ad, last); err != nil || !bytes.Equal(out, pt) {
+			return fmt.Errorf("segment %d: Open: expected %x, got %x (%v)", i, pt, out, err)
+		}
+	}
+	return nil
+}
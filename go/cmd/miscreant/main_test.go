@@ -0,0 +1,142 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	miscreant "github.com/miscreant/miscreant/go"
+)
+
+// writeKey writes key to a file in dir, encoded with encode if it isn't nil.
+func writeKey(t *testing.T, dir string, key []byte, encode func([]byte) string) string {
+	file := filepath.Join(dir, "key")
+	data := key
+	if encode != nil {
+		data = []byte(encode(key) + "\n")
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestSealOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miscreant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	pt := []byte("plaintext")
+	for _, encode := range []func([]byte) string{nil, hex.EncodeToString, base64.StdEncoding.EncodeToString} {
+		file := writeKey(t, dir, key, encode)
+		for _, alg := range miscreant.Algorithms() {
+			args := []string{"-key-file", file, "-alg", string(alg), "-nonce", "0001", "-ad", "aa", "-ad", "bbbb"}
+			var ct bytes.Buffer
+			if err := run(append([]string{"seal"}, args...), bytes.NewReader(pt), &ct); err != nil {
+				t.Fatalf("seal: %s: %s", alg, err)
+			}
+
+			// Each -ad is a separate item, followed by the nonce
+			newCipher := miscreant.NewAES
+			if alg == miscreant.AlgorithmAESPMACSIV {
+				newCipher = miscreant.NewPMACSIV
+			}
+			c, _ := newCipher(key)
+			want, _ := c.Seal(nil, pt, []byte{0xaa}, []byte{0xbb, 0xbb}, []byte{0, 1})
+			if !bytes.Equal(ct.Bytes(), want) {
+				t.Errorf("seal: %s: expected: %x\ngot: %x", alg, want, ct.Bytes())
+			}
+
+			var out bytes.Buffer
+			if err := run(append([]string{"open"}, args...), bytes.NewReader(ct.Bytes()), &out); err != nil || !bytes.Equal(out.Bytes(), pt) {
+				t.Errorf("open: %s: %q (%v)", alg, out.Bytes(), err)
+			}
+			args[len(args)-1] = "bbba"
+			if err := run(append([]string{"open"}, args...), bytes.NewReader(ct.Bytes()), &out); err != miscreant.ErrNotAuthentic {
+				t.Errorf("open: %s: wrong ad: expected ErrNotAuthentic, got %v", alg, err)
+			}
+		}
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miscreant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := bytes.Repeat([]byte{0xa5}, 32)
+	for _, encode := range []func([]byte) string{nil, hex.EncodeToString, base64.StdEncoding.EncodeToString} {
+		got, err := readKey(writeKey(t, dir, key, encode))
+		if err != nil || !bytes.Equal(got, key) {
+			t.Errorf("readKey: %x (%v)", got, err)
+		}
+	}
+
+	// Text which isn't a valid encoding is an error, not a raw key
+	file := writeKey(t, dir, nil, func([]byte) string { return strings.Repeat("not hex!", 4) })
+	if _, err := readKey(file); err != miscreant.ErrKeyEncoding {
+		t.Errorf("readKey: invalid text: expected ErrKeyEncoding, got %v", err)
+	}
+	file = writeKey(t, dir, key[:16], hex.EncodeToString)
+	if _, err := readKey(file); err != miscreant.KeySizeError(16) {
+		t.Errorf("readKey: 16-byte hex key: expected KeySizeError, got %v", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miscreant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := writeKey(t, dir, make([]byte, 32), nil)
+	pt := bytes.Repeat([]byte("0123456789"), streamChunkSize/4)
+	args := []string{"-stream", "-key-file", file, "-nonce", "0001020304050607"}
+	var ct, out bytes.Buffer
+	if err := run(append([]string{"seal"}, args...), bytes.NewReader(pt), &ct); err != nil {
+		t.Fatalf("seal: %s", err)
+	}
+	if err := run(append([]string{"open"}, args...), bytes.NewReader(ct.Bytes()), &out); err != nil || !bytes.Equal(out.Bytes(), pt) {
+		t.Fatalf("open: %d bytes (%v)", out.Len(), err)
+	}
+	truncated := ct.Bytes()[:ct.Len()-1]
+	if err := run(append([]string{"open"}, args...), bytes.NewReader(truncated), &out); err == nil {
+		t.Errorf("open: truncated stream: expected an error")
+	}
+	if err := run([]string{"seal", "-stream", "-key-file", file, "-nonce", "0001020304050607", "-ad", "00"}, nil, &out); err == nil {
+		t.Errorf("seal: -stream with -ad: expected an error")
+	}
+}
+
+func TestVerifyVectors(t *testing.T) {
+	for _, tt := range []struct {
+		dir, want string
+	}{
+		{filepath.Join("..", "..", "..", "vectors"), "PASS aes_siv.tjson"},
+		{filepath.Join("..", "..", "testdata"), "PASS aes_siv_stream.tjson"},
+	} {
+		var out bytes.Buffer
+		if err := run([]string{"verify-vectors", "-dir", tt.dir}, nil, &out); err != nil {
+			t.Fatalf("verify-vectors -dir %s: %s\n%s", tt.dir, err, out.String())
+		}
+		if !strings.Contains(out.String(), tt.want) || strings.Contains(out.String(), "FAIL") {
+			t.Errorf("verify-vectors -dir %s: unexpected output:\n%s", tt.dir, out.String())
+		}
+	}
+}
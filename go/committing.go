@@ -0,0 +1,97 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+)
+
+// commitmentSize is the size of the key commitment in front of each
+// ciphertext sealed by the AEAD returned by NewAEADAESCommitting.
+const commitmentSize = sha256.Size
+
+// commitmentLabel identifies the key commitment derived from an AES-SIV key.
+const commitmentLabel = "miscreant key commitment v1"
+
+// committingAEAD is a cipher.AEAD which prepends a commitment to its key to
+// each ciphertext.
+type committingAEAD struct {
+	a          *aead
+	commitment [commitmentSize]byte
+}
+
+// NewAEADAESCommitting returns an AES-SIV instance implementing cipher.AEAD
+// interface which is key-committing: a ciphertext opens under only the key it
+// was sealed with. The key and nonce size are as for NewAEADAES.
+//
+// SIV alone doesn't commit to its key. Someone who chooses two keys can make
+// a ciphertext which both open, which matters where keys come from
+// passwords or are otherwise guessable, as whether a ciphertext opens then
+// tells which of many keys it was tried under is right. For instance, the
+// synthetic IV of an empty plaintext only depends on the MAC half of the
+// key, so it opens under any key which shares that half.
+//
+// Each ciphertext here starts with HMAC-SHA256 of the key, which Open checks
+// before decrypting. As it is the same for every message, it only identifies
+// the key, never the plaintext. Overhead returns 48: the 32-byte commitment,
+// then the 16-byte synthetic IV. A ciphertext is otherwise what the AEAD
+// returned by NewAEADAES produces, and dst must not overlap plaintext.
+func NewAEADAESCommitting(key []byte, nonceSize int) (cipher.AEAD, error) {
+	a, err := NewAEADAES(key, nonceSize)
+	if err != nil {
+		return nil, err
+	}
+	c := &committingAEAD{a: a.(*aead)}
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(commitmentLabel))
+	h.Sum(c.commitment[:0])
+	return c, nil
+}
+
+// Algorithm returns the name of the algorithm of the underlying Cipher.
+func (a *committingAEAD) Algorithm() Algorithm { return a.a.Algorithm() }
+
+// NonceSize returns the nonce size the AEAD was constructed with.
+func (a *committingAEAD) NonceSize() int { return a.a.NonceSize() }
+
+// Overhead returns the size of the key commitment and the synthetic IV.
+func (a *committingAEAD) Overhead() int { return commitmentSize + a.a.Overhead() }
+
+// Reset wipes the key material of the underlying Cipher, and the commitment.
+// See Cipher.Reset.
+func (a *committingAEAD) Reset() {
+	a.a.Reset()
+	zero(a.commitment[:])
+}
+
+// Seal encrypts and authenticates plaintext and appends the key commitment
+// followed by the ciphertext to dst.
+func (a *committingAEAD) Seal(dst, nonce, plaintext, data []byte) []byte {
+	if err := checkSealSize(len(dst), a.Overhead(), len(plaintext)); err != nil {
+		panic("siv.AEAD: " + err.Error())
+	}
+	head, tail := sliceForAppend(dst, a.Overhead()+len(plaintext))
+	if anyOverlap(tail, plaintext) {
+		panic("siv.AEAD: invalid buffer overlap")
+	}
+	copy(tail, a.commitment[:])
+	// head has room for the rest, so the AEAD doesn't allocate again
+	return a.a.Seal(head[:len(dst)+commitmentSize], nonce, plaintext, data)
+}
+
+// Open checks the key commitment at the start of ciphertext, returning
+// ErrNotAuthentic if it isn't that of this AEAD's key, and then decrypts and
+// authenticates the rest as the AEAD returned by NewAEADAES would. It returns
+// ErrTooShort if ciphertext is shorter than Overhead().
+func (a *committingAEAD) Open(dst, nonce, ciphertext, data []byte) ([]byte, error) {
+	if len(ciphertext) < a.Overhead() {
+		return nil, ErrTooShort
+	}
+	if subtle.ConstantTimeCompare(ciphertext[:commitmentSize], a.commitment[:]) != 1 {
+		return nil, ErrNotAuthentic
+	}
+	return a.a.Open(dst, nonce, ciphertext[commitmentSize:], data)
+}
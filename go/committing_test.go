@@ -0,0 +1,85 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestAEADAESCommitting(t *testing.T) {
+	key, nonce := countingKey(32), make([]byte, 16)
+	pt, ad := []byte("plaintext"), []byte("header")
+	a, err := NewAEADAESCommitting(key, len(nonce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Overhead() != 48 || a.NonceSize() != 16 {
+		t.Errorf("Overhead, NonceSize: expected 48, 16, got %d, %d", a.Overhead(), a.NonceSize())
+	}
+
+	// The commitment followed by what NewAEADAES seals
+	ct := a.Seal([]byte("prefix"), nonce, pt, ad)
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(commitmentLabel))
+	plain, _ := NewAEADAES(key, len(nonce))
+	want := append(append([]byte("prefix"), h.Sum(nil)...), plain.Seal(nil, nonce, pt, ad)...)
+	if !bytes.Equal(ct, want) {
+		t.Errorf("Seal: expected: %x\ngot: %x", want, ct)
+	}
+	ct = ct[6:]
+	if out, err := a.Open(nil, nonce, ct, ad); err != nil || !bytes.Equal(out, pt) {
+		t.Errorf("Open: %q (%v)", out, err)
+	}
+
+	bad := append([]byte(nil), ct...)
+	bad[0] ^= 1
+	if _, err := a.Open(nil, nonce, bad, ad); err != ErrNotAuthentic {
+		t.Errorf("Open: modified commitment: expected ErrNotAuthentic, got %v", err)
+	}
+	if _, err := a.Open(nil, nonce, ct[:a.Overhead()-1], ad); err != ErrTooShort {
+		t.Errorf("Open: expected ErrTooShort, got %v", err)
+	}
+	if _, err := NewAEADAESCommitting(key[:16], 16); !errors.Is(err, ErrKeySize) {
+		t.Errorf("NewAEADAESCommitting: expected ErrKeySize, got %v", err)
+	}
+}
+
+func TestAEADAESCommittingOtherKey(t *testing.T) {
+	// Key B has the MAC half of key A and a different encryption half. The
+	// synthetic IV of an empty plaintext only depends on the MAC half, so a
+	// plain AES-SIV ciphertext of one opens under both keys.
+	keyA := countingKey(32)
+	keyB := append([]byte(nil), keyA...)
+	keyB[31] ^= 1
+	nonce, ad := make([]byte, 16), []byte("header")
+
+	plainA, _ := NewAEADAES(keyA, len(nonce))
+	plainB, _ := NewAEADAES(keyB, len(nonce))
+	if _, err := plainB.Open(nil, nonce, plainA.Seal(nil, nonce, nil, ad), ad); err != nil {
+		t.Fatalf("Open: plain AES-SIV under key B: %v", err)
+	}
+
+	a, _ := NewAEADAESCommitting(keyA, len(nonce))
+	b, _ := NewAEADAESCommitting(keyB, len(nonce))
+	ct := a.Seal(nil, nonce, nil, ad)
+	if _, err := a.Open(nil, nonce, ct, ad); err != nil {
+		t.Errorf("Open: under key A: %v", err)
+	}
+	if _, err := b.Open(nil, nonce, ct, ad); err != ErrNotAuthentic {
+		t.Errorf("Open: under key B: expected ErrNotAuthentic, got %v", err)
+	}
+
+	// Swapping in key B's commitment moves the ciphertext to key B: it never
+	// opens under both
+	forged := append(b.Seal(nil, nonce, nil, ad)[:commitmentSize], ct[commitmentSize:]...)
+	if _, err := b.Open(nil, nonce, forged, ad); err != nil {
+		t.Errorf("Open: key B's commitment: %v", err)
+	}
+	if _, err := a.Open(nil, nonce, forged, ad); err != ErrNotAuthentic {
+		t.Errorf("Open: key B's commitment under key A: expected ErrNotAuthentic, got %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import "golang.org/x/sys/cpu"
+
+// HasAESNI reports whether the CPU has AES instructions which crypto/aes
+// uses, such as AES-NI on amd64, the ARMv8 Cryptography Extensions on arm64,
+// or the CPACF instructions on s390x. AES-SIV and AES-PMAC-SIV are built
+// entirely on the block cipher, so without them throughput is an order of
+// magnitude lower, as crypto/aes falls back to constant-time software.
+// PMAC-SIV gains the most from them, as PMAC has no chain of block
+// encryptions, unlike CMAC.
+//
+// The answer is a best effort, from the feature flags golang.org/x/sys/cpu
+// detects: on platforms where it detects none, HasAESNI returns false.
+func HasAESNI() bool { return hasAESNI }
+
+var hasAESNI = cpu.X86.HasAES || cpu.ARM64.HasAES || cpu.S390X.HasAES
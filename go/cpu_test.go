@@ -0,0 +1,26 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"io/ioutil"
+	"regexp"
+	"runtime"
+	"testing"
+)
+
+func TestHasAESNI(t *testing.T) {
+	t.Logf("HasAESNI: %v", HasAESNI())
+	if runtime.GOOS != "linux" || (runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64") {
+		return
+	}
+	// The kernel reports the same feature flags in /proc/cpuinfo
+	info, err := ioutil.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		t.Skip(err)
+	}
+	want := regexp.MustCompile(`(?m)^(flags|Features)\s*:.* aes( |$)`).Match(info)
+	if HasAESNI() != want {
+		t.Errorf("HasAESNI: expected %v, as in /proc/cpuinfo, got %v", want, HasAESNI())
+	}
+}
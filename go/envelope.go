@@ -0,0 +1,119 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// EnvelopeVersion is the version of the envelope format EncryptEnvelope
+// produces, and the only one DecryptEnvelope accepts.
+const EnvelopeVersion = 1
+
+// envelopeNonceSize is the size of the random nonce in an envelope header,
+// which is part of the envelope format.
+const envelopeNonceSize = 16
+
+// envelopeHeaderSize is the length of an envelope up to the ciphertext.
+const envelopeHeaderSize = 2 + envelopeNonceSize + 8
+
+var (
+	ErrEnvelopeVersion   = errors.New("siv: unsupported envelope version")
+	ErrEnvelopeAlgorithm = errors.New("siv: unknown envelope algorithm")
+	ErrEnvelopeSize      = errors.New("siv: envelope length doesn't match its contents")
+)
+
+// envelopeAlgorithms gives the identifier of each algorithm in an envelope.
+// Identifiers are part of the format, so they must never be reassigned.
+var envelopeAlgorithms = []struct {
+	id        byte
+	alg       Algorithm
+	newCipher func(key []byte) (*Cipher, error)
+}{
+	{1, AlgorithmAESSIV, NewAES},
+	{2, AlgorithmAESPMACSIV, NewPMACSIV},
+}
+
+// EncryptEnvelope encrypts plaintext under key with the given algorithm and a
+// random nonce, returning a self-describing envelope which DecryptEnvelope
+// can open knowing only the key and associated data. key must be a valid key
+// for the algorithm (32, 48, or 64 bytes).
+//
+// The envelope format is stable: envelopes produced by this version of the
+// package will be accepted by all later ones. Version 1 is
+//
+//	version    1 byte, 1
+//	algorithm  1 byte: 1 for AES-SIV, 2 for AES-PMAC-SIV
+//	nonce      16 bytes
+//	length     8 bytes, the big endian length of the ciphertext
+//	ciphertext the synthetic IV followed by the encrypted plaintext
+//
+// S2V is computed over the version and algorithm bytes, the associated data
+// and the nonce, as the associated data items, in that order, so the header
+// is authenticated along with the ciphertext.
+func EncryptEnvelope(alg Algorithm, key, plaintext, ad []byte) ([]byte, error) {
+	nonce := make([]byte, envelopeNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return sealEnvelope(alg, key, nonce, plaintext, ad)
+}
+
+// sealEnvelope is EncryptEnvelope with the nonce given.
+func sealEnvelope(alg Algorithm, key, nonce, plaintext, ad []byte) ([]byte, error) {
+	for _, a := range envelopeAlgorithms {
+		if a.alg != alg {
+			continue
+		}
+		if err := checkSealSize(envelopeHeaderSize, TagSize, len(plaintext)); err != nil {
+			return nil, err
+		}
+		c, err := a.newCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		defer c.Reset()
+
+		out := make([]byte, envelopeHeaderSize, envelopeHeaderSize+TagSize+len(plaintext))
+		out[0], out[1] = EnvelopeVersion, a.id
+		copy(out[2:], nonce)
+		binary.BigEndian.PutUint64(out[2+envelopeNonceSize:], uint64(TagSize+len(plaintext)))
+		return c.Seal(out, plaintext, out[:2], ad, nonce)
+	}
+	return nil, UnknownAlgorithmError(alg)
+}
+
+// DecryptEnvelope opens an envelope produced by EncryptEnvelope under the same
+// key and associated data, returning the plaintext. It returns
+// ErrEnvelopeVersion or ErrEnvelopeAlgorithm if the envelope wasn't produced
+// by a known version and algorithm, ErrEnvelopeSize if its length field
+// doesn't match the rest of it, including if there are trailing bytes, and
+// ErrNotAuthentic if it or the associated data have been altered.
+func DecryptEnvelope(key, envelope, ad []byte) ([]byte, error) {
+	if len(envelope) < envelopeHeaderSize+TagSize {
+		return nil, ErrTooShort
+	}
+	if envelope[0] != EnvelopeVersion {
+		return nil, ErrEnvelopeVersion
+	}
+	nonce := envelope[2 : 2+envelopeNonceSize]
+	ciphertext := envelope[envelopeHeaderSize:]
+	if binary.BigEndian.Uint64(envelope[2+envelopeNonceSize:]) != uint64(len(ciphertext)) {
+		return nil, ErrEnvelopeSize
+	}
+	for _, a := range envelopeAlgorithms {
+		if a.id != envelope[1] {
+			continue
+		}
+		c, err := a.newCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		defer c.Reset()
+		return c.Open(nil, ciphertext, envelope[:2], ad, nonce)
+	}
+	return nil, ErrEnvelopeAlgorithm
+}
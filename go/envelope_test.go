@@ -0,0 +1,80 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// TestEnvelopeGolden checks the envelopes in testdata, which were produced by
+// the first version of EncryptEnvelope, so that the format can't change
+// without this failing.
+func TestEnvelopeGolden(t *testing.T) {
+	const file = "envelope.tjson"
+	for i, ex := range loadExampleFile(t, filepath.Join("testdata", file)) {
+		name := exampleName(file, i, ex)
+		alg, _ := ex["alg"].(string)
+		key, _ := ex["key"].([]byte)
+		ad, _ := ex["ad"].([]byte)
+		pt, _ := ex["plaintext"].([]byte)
+		envelope, _ := ex["envelope"].([]byte)
+
+		if out, err := DecryptEnvelope(key, envelope, ad); err != nil || !bytes.Equal(out, pt) {
+			t.Errorf("%s: DecryptEnvelope: expected: %x\ngot: %x (%v)", name, pt, out, err)
+		}
+		nonce := envelope[2 : 2+envelopeNonceSize]
+		if out, err := sealEnvelope(Algorithm(alg), key, nonce, pt, ad); err != nil || !bytes.Equal(out, envelope) {
+			t.Errorf("%s: sealEnvelope: expected: %x\ngot: %x (%v)", name, envelope, out, err)
+		}
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	key, pt, ad := make([]byte, 32), []byte("plaintext"), []byte("header")
+	for _, alg := range Algorithms() {
+		envelope, err := EncryptEnvelope(alg, key, pt, ad)
+		if err != nil {
+			t.Fatalf("EncryptEnvelope: %s: %s", alg, err)
+		}
+		if other, _ := EncryptEnvelope(alg, key, pt, ad); bytes.Equal(other, envelope) {
+			t.Errorf("EncryptEnvelope: %s: nonce repeated", alg)
+		}
+		if out, err := DecryptEnvelope(key, envelope, ad); err != nil || !bytes.Equal(out, pt) {
+			t.Errorf("DecryptEnvelope: %s: %q (%v)", alg, out, err)
+		}
+
+		modified := func(f func(e []byte) []byte) []byte {
+			return f(append([]byte(nil), envelope...))
+		}
+		for _, tt := range []struct {
+			name     string
+			envelope []byte
+			ad       []byte
+			err      error
+		}{
+			{"wrong associated data", envelope, []byte("other"), ErrNotAuthentic},
+			{"trailing byte", append(envelope[:len(envelope):len(envelope)], 0), ad, ErrEnvelopeSize},
+			{"truncated", envelope[:len(envelope)-1], ad, ErrEnvelopeSize},
+			{"header only", envelope[:envelopeHeaderSize+TagSize-1], ad, ErrTooShort},
+			{"version", modified(func(e []byte) []byte { e[0] = 2; return e }), ad, ErrEnvelopeVersion},
+			{"unknown algorithm", modified(func(e []byte) []byte { e[1] = 0; return e }), ad, ErrEnvelopeAlgorithm},
+			{"other algorithm", modified(func(e []byte) []byte { e[1] ^= 3; return e }), ad, ErrNotAuthentic},
+			{"nonce", modified(func(e []byte) []byte { e[2] ^= 1; return e }), ad, ErrNotAuthentic},
+			{"ciphertext", modified(func(e []byte) []byte { e[len(e)-1] ^= 1; return e }), ad, ErrNotAuthentic},
+		} {
+			if _, err := DecryptEnvelope(key, tt.envelope, tt.ad); err != tt.err {
+				t.Errorf("DecryptEnvelope: %s: %s: expected %v, got %v", alg, tt.name, tt.err, err)
+			}
+		}
+	}
+
+	if _, err := EncryptEnvelope("AES-GCM", key, pt, ad); err != UnknownAlgorithmError("AES-GCM") {
+		t.Errorf("EncryptEnvelope: expected UnknownAlgorithmError, got %v", err)
+	}
+	if _, err := EncryptEnvelope(AlgorithmAESSIV, key[:16], pt, ad); !errors.Is(err, ErrKeySize) {
+		t.Errorf("EncryptEnvelope: expected ErrKeySize, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant_test
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/miscreant/miscreant/go"
+)
+
+// A fixed key, so the output is the same each time. Real keys must be
+// random, e.g. from GenerateKey.
+var exampleKey, _ = hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+
+// Deterministic encryption with a Cipher: the same plaintext and associated
+// data always give the same ciphertext, so no nonce is needed, but equal
+// messages can be recognized.
+func Example_sealOpen() {
+	c, err := miscreant.NewAES(exampleKey)
+	if err != nil {
+		panic(err)
+	}
+	ct, err := c.Seal(nil, []byte("Hello, world!"), []byte("header"))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("%x\n", ct)
+
+	pt, err := c.Open(nil, ct, []byte("header"))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("%s\n", pt)
+
+	_, err = c.Open(nil, ct, []byte("other header"))
+	fmt.Println(err)
+	// Output:
+	// 0da9dea217133c82f9232ac9bae482aa74d8b4889f004decb4e326ef09
+	// Hello, world!
+	// siv: authentication failed
+}
+
+// Nonce-based encryption with the cipher.AEAD interface, with a random
+// nonce sent along with the ciphertext.
+func Example_aead() {
+	aead, err := miscreant.NewAEADAES(exampleKey, 16)
+	if err != nil {
+		panic(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic(err)
+	}
+	ct := aead.Seal(nil, nonce, []byte("Hello, world!"), []byte("header"))
+
+	pt, err := aead.Open(nil, nonce, ct, []byte("header"))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("%s\n", pt)
+	fmt.Println(len(ct) - len(pt))
+	// Output:
+	// Hello, world!
+	// 16
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+//go:build go1.18
+// +build go1.18
+
+package miscreant
+
+import (
+	"bytes"
+	"testing"
+)
+
+// fuzzItems returns the associated data items for a fuzzed ad and nonce: ad
+// alone, or ad followed by the nonce if there is one.
+func fuzzItems(ad, nonce []byte) [][]byte {
+	if len(nonce) == 0 {
+		return [][]byte{ad}
+	}
+	return [][]byte{ad, nonce}
+}
+
+// vectorAD returns the first and last associated data items of v, as seeds
+// for the ad and nonce arguments of the fuzz targets.
+func vectorAD(v testVector) (ad, nonce []byte) {
+	items := decodeAD(v.adata)
+	if len(items) == 0 {
+		return nil, nil
+	}
+	return items[0], items[len(items)-1]
+}
+
+// FuzzOpen checks that Open never panics on arbitrary input, that it
+// rejects a ciphertext only with ErrTooShort or ErrNotAuthentic, and that it
+// only accepts a ciphertext which Seal produces from the resulting plaintext.
+// Verify and the AEAD returned by NewAEADAES must agree with it.
+func FuzzOpen(f *testing.F) {
+	for _, v := range testVectors {
+		ad, nonce := vectorAD(v)
+		ct := decode(v.output)
+		for _, n := range []int{len(ct), len(ct) - 1, 16, 15, 0} {
+			f.Add(decode(v.key), ct[:n], ad, nonce)
+		}
+	}
+	f.Fuzz(func(t *testing.T, key, ciphertext, ad, nonce []byte) {
+		for _, newCipher := range []func([]byte) (*Cipher, error){NewAES, NewPMACSIV} {
+			c, err := newCipher(key)
+			if err != nil {
+				return
+			}
+			items := fuzzItems(ad, nonce)
+			pt, err := c.Open(nil, ciphertext, items...)
+			if verr := c.Verify(ciphertext, items...); verr != err {
+				t.Fatalf("Verify returned %v, Open %v", verr, err)
+			}
+			if err != nil {
+				if err != ErrTooShort && err != ErrNotAuthentic {
+					t.Fatalf("Open: unexpected error %v", err)
+				}
+				if pt != nil {
+					t.Fatalf("Open: returned %x with error %v", pt, err)
+				}
+				continue
+			}
+			ct, err := c.Seal(nil, pt, items...)
+			if err != nil || !bytes.Equal(ct, ciphertext) {
+				t.Fatalf("Open accepted %x, but Seal of its plaintext gave %x (%v)", ciphertext, ct, err)
+			}
+		}
+
+		a, err := NewAEADAES(key, -1)
+		if err != nil {
+			return
+		}
+		c, _ := NewAES(key)
+		pt, err := a.Open(nil, nonce, ciphertext, ad)
+		want, wantErr := c.Open(nil, ciphertext, ad, nonce)
+		if ad == nil {
+			want, wantErr = c.Open(nil, ciphertext, nonce)
+		}
+		if err != wantErr || !bytes.Equal(pt, want) {
+			t.Fatalf("AEAD Open: expected %x (%v), got %x (%v)", want, wantErr, pt, err)
+		}
+	})
+}
+
+// FuzzSealOpenRoundTrip checks that whatever Seal produces, Open recovers.
+func FuzzSealOpenRoundTrip(f *testing.F) {
+	for _, v := range testVectors {
+		ad, nonce := vectorAD(v)
+		f.Add(decode(v.plaintext), ad, nonce)
+	}
+	key := decode(testVectors[1].key)
+	f.Fuzz(func(t *testing.T, plaintext, ad, nonce []byte) {
+		for _, newCipher := range []func([]byte) (*Cipher, error){NewAES, NewPMACSIV} {
+			c, err := newCipher(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			items := fuzzItems(ad, nonce)
+			ct, err := c.Seal(nil, plaintext, items...)
+			if err != nil {
+				t.Fatalf("Seal: %s", err)
+			}
+			pt, err := c.Open(nil, ct, items...)
+			if err != nil || !bytes.Equal(pt, plaintext) {
+				t.Fatalf("Open: expected: %x\ngot: %x (%v)", plaintext, pt, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"crypto/aes"
+	"errors"
+
+	"github.com/miscreant/miscreant/go/cmac"
+)
+
+var ErrIndexSize = errors.New("siv: index length must be from 1 to 16 bytes")
+
+// indexLabel identifies the index key derived from an AES-SIV key.
+const indexLabel = "miscreant DeriveIndex v1"
+
+// DeriveIndex returns a keyed, deterministic index of outLen bytes for the
+// given data items: S2V over the items, truncated to outLen bytes, which must
+// be from 1 to 16. key is an AES-SIV key of 32, 48 or 64 bytes, as for
+// NewAES. Like S2V, the items are kept apart, so splitting the same bytes
+// differently between items gives an unrelated index.
+//
+// An index allows a stored value to be looked up without being decrypted,
+// by comparing the index of a search term with the stored index of each
+// value. This only works because equal inputs give equal indexes, so anyone
+// who can see the indexes learns which values are equal, and how often each
+// occurs, though not the values themselves. Including a field name as the
+// first item keeps equal values in different fields apart. Shorter indexes
+// take less space, but unequal values then collide more often, and lookups
+// must check each match.
+//
+// S2V is computed under a key derived from the MAC half of key with AES-CMAC,
+// not under the MAC half itself, so the same key may be used to encrypt with
+// Seal without any index also being the synthetic IV of some ciphertext.
+func DeriveIndex(key []byte, outLen int, data ...[]byte) ([]byte, error) {
+	if outLen < 1 || outLen > TagSize {
+		return nil, ErrIndexSize
+	}
+	n := len(key)
+	if n != 32 && n != 48 && n != 64 {
+		return nil, KeySizeError(n)
+	}
+	ik, err := deriveIndexKey(key[:n/2])
+	if err != nil {
+		return nil, err
+	}
+	defer zero(ik)
+	v, err := S2V(ik, data...)
+	if err != nil {
+		return nil, err
+	}
+	index := append([]byte(nil), v[:outLen]...)
+	zero(v[:])
+	return index, nil
+}
+
+// deriveIndexKey derives a key as long as macKey from it, with AES-CMAC in
+// counter mode (NIST SP 800-108) over indexLabel.
+func deriveIndexKey(macKey []byte) ([]byte, error) {
+	b, err := aes.NewCipher(macKey)
+	if err != nil {
+		return nil, err
+	}
+	h, err := cmac.New(b)
+	if err != nil {
+		return nil, err
+	}
+	defer h.(wiper).Wipe()
+
+	out := make([]byte, 0, 2*aes.BlockSize)
+	for i := byte(1); len(out) < len(macKey); i++ {
+		h.Reset()
+		h.Write([]byte{i})
+		h.Write([]byte(indexLabel))
+		out = h.Sum(out)
+	}
+	zero(out[len(macKey):])
+	return out[:len(macKey)], nil
+}
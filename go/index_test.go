@@ -0,0 +1,87 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"crypto/aes"
+	"errors"
+	"testing"
+
+	"github.com/miscreant/miscreant/go/cmac"
+)
+
+// countingKey returns a key of n bytes 0x00, 0x01, 0x02, ...
+func countingKey(n int) []byte {
+	key := make([]byte, n)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return key
+}
+
+func TestDeriveIndex(t *testing.T) {
+	// Produced by this implementation, so that changes to the derivation are
+	// caught; there are no vectors from elsewhere to compare with
+	for _, tt := range []struct {
+		key    []byte
+		outLen int
+		data   [][]byte
+		want   string
+	}{
+		{countingKey(32), 16, [][]byte{[]byte("email"), []byte("alice@example.com")}, "9e20af42620cfb085fc5c00e6972e7c7"},
+		{countingKey(32), 8, [][]byte{[]byte("email"), []byte("alice@example.com")}, "9e20af42620cfb08"},
+		{countingKey(64), 16, [][]byte{[]byte("ssn"), []byte("078-05-1120")}, "66aec9d0bf4eeda4a3e1eb25279da8b2"},
+		{countingKey(48), 12, nil, "2b4d89cd0046dfc66ff1027c"},
+	} {
+		got, err := DeriveIndex(tt.key, tt.outLen, tt.data...)
+		if err != nil || !bytes.Equal(got, decode(tt.want)) {
+			t.Errorf("DeriveIndex: %d-byte key, %d bytes: expected: %s\ngot: %x (%v)", len(tt.key), tt.outLen, tt.want, got, err)
+		}
+
+		// S2V under CMAC(key[:n/2], i || label) for i = 1, 2, ...
+		n := len(tt.key) / 2
+		b, _ := aes.NewCipher(tt.key[:n])
+		h, _ := cmac.New(b)
+		var ik []byte
+		for i := byte(1); len(ik) < n; i++ {
+			h.Reset()
+			h.Write(append([]byte{i}, indexLabel...))
+			ik = h.Sum(ik)
+		}
+		want, _ := S2V(ik[:n], tt.data...)
+		if !bytes.Equal(got, want[:tt.outLen]) {
+			t.Errorf("DeriveIndex: %d-byte key: expected S2V under the derived key: %x\ngot: %x", len(tt.key), want[:tt.outLen], got)
+		}
+	}
+
+	// Distinct from the synthetic IV Seal gives the same items under the same key
+	key := countingKey(32)
+	index, _ := DeriveIndex(key, 16, []byte("email"), []byte("alice@example.com"))
+	c, _ := NewAES(key)
+	ct, _ := c.Seal(nil, []byte("alice@example.com"), []byte("email"))
+	iv := append([]byte(nil), index...)
+	zeroIVBits(iv)
+	if bytes.Equal(ct[:16], index) || bytes.Equal(ct[:16], iv) {
+		t.Errorf("DeriveIndex: index is the synthetic IV of Seal")
+	}
+
+	// The items are kept apart
+	a, _ := DeriveIndex(key, 16, []byte("ab"), []byte("c"))
+	b, _ := DeriveIndex(key, 16, []byte("a"), []byte("bc"))
+	if bytes.Equal(a, b) {
+		t.Errorf("DeriveIndex: items split differently give the same index")
+	}
+
+	for _, n := range []int{0, -1, 17} {
+		if _, err := DeriveIndex(key, n, []byte("x")); err != ErrIndexSize {
+			t.Errorf("DeriveIndex: %d bytes: expected ErrIndexSize, got %v", n, err)
+		}
+	}
+	if _, err := DeriveIndex(key[:16], 16, []byte("x")); !errors.Is(err, ErrKeySize) {
+		t.Errorf("DeriveIndex: expected ErrKeySize, got %v", err)
+	}
+	if _, err := DeriveIndex(key, 16, make([][]byte, MaxAssociatedDataItems+2)...); err != ErrTooManyAssociatedDataItems {
+		t.Errorf("DeriveIndex: expected ErrTooManyAssociatedDataItems, got %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+//go:build go1.18
+// +build go1.18
+
+package tjson
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// FuzzParse checks that the test vector parser never panics.
+func FuzzParse(f *testing.F) {
+	files, _ := filepath.Glob(filepath.Join("..", "..", "..", "vectors", "*.tjson"))
+	for _, file := range files {
+		if data, err := ioutil.ReadFile(file); err == nil {
+			f.Add(data)
+		}
+	}
+	f.Add([]byte(`{"a:A<d16>":["00ff",""],"b:s":"x","c:O":{"d:i":"-3"},"e:A<A<u>>":[["1"]]}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Parse(data)
+	})
+}
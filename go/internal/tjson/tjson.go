@@ -0,0 +1,129 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+// Package tjson parses the TJSON (https://www.tjson.org/) documents in which
+// the cross-language test vectors are written.
+package tjson
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parse parses a TJSON document, returning its members with the type
+// tags removed from their names and their values decoded accordingly.
+//
+// Binary data may be tagged d16 for lower case hex, or d, d64 or b64 for
+// base64url; b64 is not part of TJSON, but is used by some vector files.
+// TJSON's base64url is unpadded, but padded values are accepted too, so long
+// as the padding is correct. Unused trailing bits must be zero.
+func Parse(data []byte) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return parseObject(m)
+}
+
+func parseObject(m map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		i := strings.LastIndex(k, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("tjson: member %q has no tag", k)
+		}
+		name, tag := k[:i], k[i+1:]
+		if _, ok := out[name]; ok {
+			return nil, fmt.Errorf("tjson: duplicate member %q", name)
+		}
+		x, err := parseValue(tag, v)
+		if err != nil {
+			return nil, fmt.Errorf("tjson: %s: %s", name, err)
+		}
+		out[name] = x
+	}
+	return out, nil
+}
+
+func parseValue(tag string, v interface{}) (interface{}, error) {
+	if strings.HasPrefix(tag, "A<") && strings.HasSuffix(tag, ">") {
+		a, ok := v.([]interface{})
+		if !ok {
+			return nil, errors.New("expected an array")
+		}
+		// Arrays of binary data, the common case, get a more useful type
+		inner := tag[2 : len(tag)-1]
+		if isBinary(inner) {
+			out := make([][]byte, len(a))
+			for i, x := range a {
+				b, err := parseValue(inner, x)
+				if err != nil {
+					return nil, err
+				}
+				out[i] = b.([]byte)
+			}
+			return out, nil
+		}
+		out := make([]interface{}, len(a))
+		for i, x := range a {
+			var err error
+			if out[i], err = parseValue(inner, x); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+
+	if tag == "O" {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("expected an object")
+		}
+		return parseObject(m)
+	}
+	if tag == "b" {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errors.New("expected a boolean")
+		}
+		return b, nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected a string for tag %q", tag)
+	}
+	switch tag {
+	case "s":
+		return s, nil
+	case "d16":
+		if strings.ToLower(s) != s {
+			return nil, errors.New("hex must be lower case")
+		}
+		return hex.DecodeString(s)
+	case "d", "d64", "b64":
+		if strings.HasSuffix(s, "=") {
+			return base64.URLEncoding.Strict().DecodeString(s)
+		}
+		return base64.RawURLEncoding.Strict().DecodeString(s)
+	case "i":
+		return strconv.ParseInt(s, 10, 64)
+	case "u":
+		return strconv.ParseUint(s, 10, 64)
+	default:
+		return nil, fmt.Errorf("unsupported tag %q", tag)
+	}
+}
+
+// isBinary reports whether tag is one of the tags for binary data.
+func isBinary(tag string) bool {
+	switch tag {
+	case "d16", "d", "d64", "b64":
+		return true
+	}
+	return false
+}
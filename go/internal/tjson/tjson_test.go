@@ -0,0 +1,76 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package tjson
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	doc, err := Parse([]byte(`{"a:A<d16>":["00ff",""],"b:s":"x","c:O":{"d:i":"-3"},"e:A<A<u>>":[["1"]]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := doc["a"].([][]byte); len(a) != 2 || !bytes.Equal(a[0], []byte{0, 0xff}) || len(a[1]) != 0 {
+		t.Errorf("A<d16>: got %x", a)
+	}
+	if doc["b"] != "x" {
+		t.Errorf("s: got %v", doc["b"])
+	}
+	if d := doc["c"].(map[string]interface{})["d"]; d != int64(-3) {
+		t.Errorf("i: got %v", d)
+	}
+	if e := doc["e"].([]interface{})[0].([]interface{})[0]; e != uint64(1) {
+		t.Errorf("u: got %v", e)
+	}
+
+	// base64url, with and without padding, under each of its tags
+	for _, tt := range []struct{ doc, want string }{
+		{`{"a:d":"_-8"}`, "\xff\xef"},
+		{`{"a:d64":"_-8="}`, "\xff\xef"},
+		{`{"a:b64":"AA"}`, "\x00"},
+		{`{"a:b64":"AA=="}`, "\x00"},
+		{`{"a:b64":"AAAA"}`, "\x00\x00\x00"},
+		{`{"a:d64":""}`, ""},
+		{`{"a:A<b64>":["AQ","Ag=="]}`, ""},
+	} {
+		doc, err := Parse([]byte(tt.doc))
+		if err != nil {
+			t.Errorf("Parse(%s): %s", tt.doc, err)
+			continue
+		}
+		switch a := doc["a"].(type) {
+		case []byte:
+			if string(a) != tt.want {
+				t.Errorf("Parse(%s): expected %x, got %x", tt.doc, tt.want, a)
+			}
+		case [][]byte:
+			if len(a) != 2 || !bytes.Equal(a[0], []byte{1}) || !bytes.Equal(a[1], []byte{2}) {
+				t.Errorf("Parse(%s): got %x", tt.doc, a)
+			}
+		default:
+			t.Errorf("Parse(%s): got %T", tt.doc, a)
+		}
+	}
+
+	for _, bad := range []string{
+		`{"a":"untagged"}`,
+		`{"a:d64":"AA="}`,
+		`{"a:d64":"AA==="}`,
+		`{"a:b64":"A"}`,
+		`{"a:b64":"+/8"}`,
+		`{"a:d":"AB"}`,
+		`{"a:d16":"0g"}`,
+		`{"a:d16":"FF"}`,
+		`{"a:s":1}`,
+		`{"a:x":"unknown tag"}`,
+		`{"a:A<s>":"not an array"}`,
+		`{"a:s":"dup","a:d16":""}`,
+		`[]`,
+	} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("Parse(%s): expected an error", bad)
+		}
+	}
+}
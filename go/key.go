@@ -0,0 +1,130 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrKeyWiped    = errors.New("siv: key has been wiped")
+	ErrKeyEncoding = errors.New("siv: key is not hex or base64")
+)
+
+// GenerateKey returns a new SIV key of the given size, read from rand, which
+// should usually be crypto/rand.Reader. The size must be 32, 48, or 64 bytes,
+// as accepted by NewAES and the other constructors, or a KeySizeError is
+// returned.
+func GenerateKey(rand io.Reader, size int) ([]byte, error) {
+	if size != 32 && size != 48 && size != 64 {
+		return nil, KeySizeError(size)
+	}
+	key := make([]byte, size)
+	if _, err := io.ReadFull(rand, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Key holds SIV key material so that it can be wiped once it is no longer
+// needed. Ciphers constructed from a Key keep working after it is wiped, as
+// they hold their own expanded copies of it.
+//
+// A Key must not be wiped concurrently with its other methods.
+type Key struct {
+	b []byte
+}
+
+// NewKey returns a Key holding a copy of b, which must be 32, 48, or 64 bytes
+// long, so that b may be wiped or reused by the caller.
+func NewKey(b []byte) (*Key, error) {
+	if n := len(b); n != 32 && n != 48 && n != 64 {
+		return nil, KeySizeError(n)
+	}
+	return &Key{b: append([]byte(nil), b...)}, nil
+}
+
+// Wipe overwrites the key material with zeros. The constructors on k return
+// ErrKeyWiped afterwards. It is safe to call Wipe more than once.
+func (k *Key) Wipe() {
+	zero(k.b)
+	k.b = nil
+}
+
+// bytes returns the key material, or ErrKeyWiped.
+func (k *Key) bytes() ([]byte, error) {
+	if k.b == nil {
+		return nil, ErrKeyWiped
+	}
+	return k.b, nil
+}
+
+// NewAES is like the package function NewAES, using the key held by k.
+func (k *Key) NewAES() (*Cipher, error) {
+	b, err := k.bytes()
+	if err != nil {
+		return nil, err
+	}
+	return NewAES(b)
+}
+
+// NewPMACSIV is like the package function NewPMACSIV, using the key held by k.
+func (k *Key) NewPMACSIV() (*Cipher, error) {
+	b, err := k.bytes()
+	if err != nil {
+		return nil, err
+	}
+	return NewPMACSIV(b)
+}
+
+// NewAEADAES is like the package function NewAEADAES, using the key held by k.
+func (k *Key) NewAEADAES(nonceSize int) (cipher.AEAD, error) {
+	b, err := k.bytes()
+	if err != nil {
+		return nil, err
+	}
+	return NewAEADAES(b, nonceSize)
+}
+
+// NewAEADAESPMACSIV is like the package function NewAEADAESPMACSIV, using the
+// key held by k.
+func (k *Key) NewAEADAESPMACSIV(nonceSize int) (cipher.AEAD, error) {
+	b, err := k.bytes()
+	if err != nil {
+		return nil, err
+	}
+	return NewAEADAESPMACSIV(b, nonceSize)
+}
+
+// ParseKey decodes a textual SIV key, as kept in configuration files and
+// environment variables: either hex, in upper, lower or mixed case, or
+// base64 with the standard or URL-safe alphabet, padded or not. Leading and
+// trailing whitespace is ignored. A string which is valid hex is decoded as
+// hex, though it might also be valid base64.
+//
+// ParseKey returns ErrKeyEncoding if s is none of these encodings, and a
+// KeySizeError if the key it decodes to isn't 32, 48, or 64 bytes long.
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+			if key, err = enc.Strict().DecodeString(s); err == nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, ErrKeyEncoding
+	}
+	if n := len(key); n != 32 && n != 48 && n != 64 {
+		zero(key)
+		return nil, KeySizeError(n)
+	}
+	return key, nil
+}
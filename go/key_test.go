@@ -0,0 +1,138 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGenerateKey(t *testing.T) {
+	for _, size := range []int{32, 48, 64} {
+		key, err := GenerateKey(rand.Reader, size)
+		if err != nil {
+			t.Fatalf("GenerateKey: %d bytes: %s", size, err)
+		}
+		if len(key) != size {
+			t.Errorf("GenerateKey: %d bytes: got %d", size, len(key))
+		}
+		if _, err := NewAES(key); err != nil {
+			t.Errorf("NewAES: %d byte generated key: %s", size, err)
+		}
+	}
+	for _, size := range []int{0, 16, 31, 33, 128} {
+		if _, err := GenerateKey(rand.Reader, size); err != KeySizeError(size) {
+			t.Errorf("GenerateKey: %d bytes: expected KeySizeError, got %v", size, err)
+		}
+	}
+	if _, err := GenerateKey(bytes.NewReader(make([]byte, 31)), 32); err == nil {
+		t.Errorf("GenerateKey: short read: expected an error")
+	}
+}
+
+func TestKey(t *testing.T) {
+	raw := decode(testVectors[0].key)
+	k, err := NewKey(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := k.NewAEADAES(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither the caller's buffer nor k is needed once the AEAD exists
+	b, _ := NewAEADAES(raw, 0)
+	zero(raw)
+	held := k.b
+	k.Wipe()
+	if !bytes.Equal(held, make([]byte, len(held))) {
+		t.Errorf("Wipe: key not zeroed: %x", held)
+	}
+	k.Wipe()
+
+	want, _ := NewAEADAES(decode(testVectors[0].key), 0)
+	pt := []byte("plaintext")
+	if got := a.Seal(nil, nil, pt, nil); !bytes.Equal(got, want.Seal(nil, nil, pt, nil)) {
+		t.Errorf("Seal: AEAD changed after Wipe: %x", got)
+	}
+	if got := b.Seal(nil, nil, pt, nil); !bytes.Equal(got, want.Seal(nil, nil, pt, nil)) {
+		t.Errorf("Seal: AEAD changed after its key was zeroed: %x", got)
+	}
+
+	if _, err := k.NewAES(); err != ErrKeyWiped {
+		t.Errorf("NewAES: expected ErrKeyWiped, got %v", err)
+	}
+	if _, err := k.NewPMACSIV(); err != ErrKeyWiped {
+		t.Errorf("NewPMACSIV: expected ErrKeyWiped, got %v", err)
+	}
+	if _, err := k.NewAEADAES(0); err != ErrKeyWiped {
+		t.Errorf("NewAEADAES: expected ErrKeyWiped, got %v", err)
+	}
+	if _, err := k.NewAEADAESPMACSIV(0); err != ErrKeyWiped {
+		t.Errorf("NewAEADAESPMACSIV: expected ErrKeyWiped, got %v", err)
+	}
+
+	if _, err := NewKey(make([]byte, 16)); !errors.Is(err, ErrKeySize) {
+		t.Errorf("NewKey: expected ErrKeySize, got %v", err)
+	}
+}
+
+// patternKey returns a key of n bytes, whose encodings use most of the hex
+// and base64 alphabets.
+func patternKey(n int) []byte {
+	key := make([]byte, n)
+	for i := range key {
+		key[i] = byte(i*37 + 250)
+	}
+	return key
+}
+
+func TestParseKey(t *testing.T) {
+	key := patternKey(32)
+	mixed := []byte(hex.EncodeToString(key))
+	for i := 0; i < len(mixed); i += 2 {
+		mixed[i] = strings.ToUpper(string(mixed[i]))[0]
+	}
+	for _, s := range []string{
+		hex.EncodeToString(key),
+		strings.ToUpper(hex.EncodeToString(key)),
+		string(mixed),
+		base64.StdEncoding.EncodeToString(key),
+		base64.RawStdEncoding.EncodeToString(key),
+		base64.URLEncoding.EncodeToString(key),
+		base64.RawURLEncoding.EncodeToString(key),
+		" \t" + base64.StdEncoding.EncodeToString(key) + "\n",
+		hex.EncodeToString(key) + "\r\n",
+	} {
+		if got, err := ParseKey(s); err != nil || !bytes.Equal(got, key) {
+			t.Errorf("ParseKey(%q): expected: %x\ngot: %x (%v)", s, key, got, err)
+		}
+	}
+	for _, n := range []int{48, 64} {
+		if got, err := ParseKey(base64.StdEncoding.EncodeToString(patternKey(n))); err != nil || !bytes.Equal(got, patternKey(n)) {
+			t.Errorf("ParseKey: %d-byte key: %v", n, err)
+		}
+	}
+
+	for _, n := range []int{31, 33} {
+		for _, s := range []string{hex.EncodeToString(patternKey(n)), base64.StdEncoding.EncodeToString(patternKey(n))} {
+			if _, err := ParseKey(s); err != KeySizeError(n) || !errors.Is(err, ErrKeySize) {
+				t.Errorf("ParseKey: %d-byte key %q: expected KeySizeError(%d), got %v", n, s, n, err)
+			}
+		}
+	}
+	for _, s := range []string{"not a key!", hex.EncodeToString(key)[1:] + "!", base64.StdEncoding.EncodeToString(key)[1:]} {
+		if _, err := ParseKey(s); err != ErrKeyEncoding {
+			t.Errorf("ParseKey(%q): expected ErrKeyEncoding, got %v", s, err)
+		}
+	}
+	if _, err := ParseKey(" \n"); err != KeySizeError(0) {
+		t.Errorf("ParseKey: empty: expected KeySizeError(0), got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import "errors"
+
+var (
+	ErrKeyToWrapSize  = errors.New("siv: key to wrap must not be empty")
+	ErrWrappedKeySize = errors.New("siv: wrapped key too short")
+)
+
+// KeyWrapper wraps keys under a fixed key-encrypting key using deterministic
+// AES-SIV (RFC 5297 section 4): S2V is computed over the associated data items
+// and the key alone, with no nonce, so wrapping the same key with the same
+// associated data always gives the same result. The associated data can bind
+// the wrapped key to a header, as in RFC 5297 appendix A.1.
+//
+// A KeyWrapper is safe for concurrent use by multiple goroutines.
+type KeyWrapper struct {
+	c *Cipher
+}
+
+// NewKeyWrapper returns a KeyWrapper using the key-encrypting key kek, which
+// must be a valid AES-SIV key (32, 48, or 64 bytes).
+func NewKeyWrapper(kek []byte) (*KeyWrapper, error) {
+	c, err := NewAES(kek)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyWrapper{c: c}, nil
+}
+
+// Wrap encrypts key and authenticates it along with the given associated data
+// items. The result is the 16-byte synthetic IV followed by the encrypted key.
+func (w *KeyWrapper) Wrap(key []byte, data ...[]byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyToWrapSize
+	}
+	return w.c.Seal(nil, key, data...)
+}
+
+// Unwrap decrypts a key wrapped by Wrap with the same associated data items.
+// It returns ErrWrappedKeySize if wrapped is too short to contain a key and
+// ErrNotAuthentic if wrapped or the associated data have been altered.
+func (w *KeyWrapper) Unwrap(wrapped []byte, data ...[]byte) ([]byte, error) {
+	if len(wrapped) <= w.c.Overhead() {
+		return nil, ErrWrappedKeySize
+	}
+	return w.c.Open(nil, wrapped, data...)
+}
+
+// Reset wipes the key material of the KeyWrapper. See Cipher.Reset.
+func (w *KeyWrapper) Reset() { w.c.Reset() }
+
+// WrapKey encrypts key under the key-encrypting key kek, which must be a
+// valid AES-SIV key (32, 48, or 64 bytes), using deterministic AES-SIV with no
+// nonce or associated data (RFC 5297 section 4). The result is the 16-byte
+// synthetic IV followed by the encrypted key.
+func WrapKey(kek, key []byte) ([]byte, error) {
+	w, err := NewKeyWrapper(kek)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Reset()
+	return w.Wrap(key)
+}
+
+// UnwrapKey decrypts a key wrapped by WrapKey under the same kek. It returns
+// ErrWrappedKeySize if wrapped is too short to contain a key and
+// ErrNotAuthentic if wrapped was not produced by WrapKey under kek.
+func UnwrapKey(kek, wrapped []byte) ([]byte, error) {
+	w, err := NewKeyWrapper(kek)
+	if err != nil {
+		return nil, err
+	}
+	defer w.Reset()
+	return w.Unwrap(wrapped)
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrapKey(t *testing.T) {
+	kek := decode(testVectors[0].key)
+	for _, n := range []int{16, 24, 32} {
+		key := make([]byte, n)
+		for i := range key {
+			key[i] = byte(i)
+		}
+		wrapped, err := WrapKey(kek, key)
+		if err != nil {
+			t.Fatalf("WrapKey: %d: %s", n, err)
+		}
+		if len(wrapped) != n+16 {
+			t.Errorf("WrapKey: %d: expected %d bytes, got %d", n, n+16, len(wrapped))
+		}
+		again, err := WrapKey(kek, key)
+		if err != nil || !bytes.Equal(wrapped, again) {
+			t.Errorf("WrapKey: %d: wrapping isn't deterministic: %x != %x", n, wrapped, again)
+		}
+		unwrapped, err := UnwrapKey(kek, wrapped)
+		if err != nil {
+			t.Fatalf("UnwrapKey: %d: %s", n, err)
+		}
+		if !bytes.Equal(unwrapped, key) {
+			t.Errorf("UnwrapKey: %d: expected: %x\ngot: %x", n, key, unwrapped)
+		}
+
+		wrapped[len(wrapped)-1] ^= 1
+		if _, err := UnwrapKey(kek, wrapped); err != ErrNotAuthentic {
+			t.Errorf("UnwrapKey: %d: tampered: expected ErrNotAuthentic, got %v", n, err)
+		}
+	}
+}
+
+func TestKeyWrapper(t *testing.T) {
+	// RFC 5297 A.1 wraps a key bound to a header, without a nonce
+	v := testVectors[0]
+	w, err := NewKeyWrapper(decode(v.key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, header, want := decode(v.plaintext), decode(v.adata[0]), decode(v.output)
+	for i := 0; i < 2; i++ {
+		wrapped, err := w.Wrap(key, header)
+		if err != nil {
+			t.Fatalf("Wrap: %d: %s", i, err)
+		}
+		if !bytes.Equal(wrapped, want) {
+			t.Errorf("Wrap: %d: expected: %x\ngot: %x", i, want, wrapped)
+		}
+	}
+	unwrapped, err := w.Unwrap(want, header)
+	if err != nil {
+		t.Fatalf("Unwrap: %s", err)
+	}
+	if !bytes.Equal(unwrapped, key) {
+		t.Errorf("Unwrap: expected: %x\ngot: %x", key, unwrapped)
+	}
+	if _, err := w.Unwrap(want); err != ErrNotAuthentic {
+		t.Errorf("Unwrap: missing header: expected ErrNotAuthentic, got %v", err)
+	}
+	if _, err := w.Unwrap(want, header[1:]); err != ErrNotAuthentic {
+		t.Errorf("Unwrap: altered header: expected ErrNotAuthentic, got %v", err)
+	}
+}
+
+func TestWrapKeyErrors(t *testing.T) {
+	kek := decode(testVectors[0].key)
+	if _, err := WrapKey(kek, nil); err != ErrKeyToWrapSize {
+		t.Errorf("WrapKey: empty key: expected ErrKeyToWrapSize, got %v", err)
+	}
+	if _, err := WrapKey(kek[:31], make([]byte, 16)); err != KeySizeError(31) {
+		t.Errorf("WrapKey: short kek: expected KeySizeError(31), got %v", err)
+	}
+	for _, n := range []int{0, 1, 15, 16} {
+		if _, err := UnwrapKey(kek, make([]byte, n)); err != ErrWrappedKeySize {
+			t.Errorf("UnwrapKey: %d bytes: expected ErrWrappedKeySize, got %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"crypto/cipher"
+	"hash"
+
+	"github.com/miscreant/miscreant/go/cmac"
+	"github.com/miscreant/miscreant/go/pmac"
+)
+
+// NewCMAC returns a new CMAC (RFC 4493, NIST SP 800-38B) message
+// authentication code using the given block cipher, e.g. AES-CMAC when
+// passed a cipher.Block returned by aes.NewCipher. It is the same MAC S2V
+// uses in AES-SIV, and can be used on its own to authenticate messages.
+func NewCMAC(c cipher.Block) (hash.Hash, error) {
+	return cmac.New(c)
+}
+
+// NewPMAC returns a new PMAC message authentication code using the given
+// block cipher, which must have a 16-byte block size, e.g. PMAC-AES when
+// passed a cipher.Block returned by aes.NewCipher. It is the same MAC S2V
+// uses in AES-PMAC-SIV. The L values it is keyed with are computed once here
+// and reused for every message, including after Reset.
+func NewPMAC(c cipher.Block) (hash.Hash, error) {
+	return pmac.New(c)
+}
+
+// NewPMACParallel returns a PMAC like NewPMAC, which splits large writes
+// between up to workers goroutines and gives the same result. c must be safe
+// for concurrent use, as the block ciphers returned by aes.NewCipher are.
+func NewPMACParallel(c cipher.Block, workers int) (hash.Hash, error) {
+	return pmac.NewParallel(c, workers)
+}
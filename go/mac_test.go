@@ -0,0 +1,147 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"crypto/aes"
+	"strings"
+	"testing"
+)
+
+// RFC 4493 section 4 examples
+var cmacTestVectors = []struct {
+	key, message, tag string
+}{
+	{
+		"2b7e1516 28aed2a6 abf71588 09cf4f3c",
+		"",
+		"bb1d6929 e9593728 7fa37d12 9b756746",
+	},
+	{
+		"2b7e1516 28aed2a6 abf71588 09cf4f3c",
+		"6bc1bee2 2e409f96 e93d7e11 7393172a",
+		"070a16b4 6b4d4144 f79bdd9d d04a287c",
+	},
+	{
+		"2b7e1516 28aed2a6 abf71588 09cf4f3c",
+		"6bc1bee2 2e409f96 e93d7e11 7393172a ae2d8a57 1e03ac9c 9eb76fac 45af8e51 30c81c46 a35ce411",
+		"dfa66747 de9ae630 30ca3261 1497c827",
+	},
+	{
+		"2b7e1516 28aed2a6 abf71588 09cf4f3c",
+		"6bc1bee2 2e409f96 e93d7e11 7393172a ae2d8a57 1e03ac9c 9eb76fac 45af8e51 30c81c46 a35ce411 e5fbc119 1a0a52ef f69f2445 df4f9b17 ad2b417b e66c3710",
+		"51f0bebf 7e3b9d92 fc497417 79363cfe",
+	},
+}
+
+func TestCMAC(t *testing.T) {
+	for i, v := range cmacTestVectors {
+		b, err := aes.NewCipher(decode(v.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := NewCMAC(b)
+		if err != nil {
+			t.Fatalf("NewCMAC: %d: %s", i, err)
+		}
+		if h.Size() != 16 || h.BlockSize() != 16 {
+			t.Errorf("NewCMAC: %d: expected Size and BlockSize 16, got %d and %d", i, h.Size(), h.BlockSize())
+		}
+		msg, tag := decode(v.message), decode(v.tag)
+
+		// Write byte by byte, summing after every byte to check Sum leaves
+		// the state intact
+		for _, c := range msg {
+			h.Write([]byte{c})
+			h.Sum(nil)
+		}
+		if sum := h.Sum(nil); !bytes.Equal(sum, tag) {
+			t.Errorf("Sum: %d: expected: %x\ngot: %x", i, tag, sum)
+		}
+		if sum := h.Sum([]byte("prefix")); !bytes.Equal(sum, append([]byte("prefix"), tag...)) {
+			t.Errorf("Sum: %d: didn't append to the given slice: %x", i, sum)
+		}
+
+		h.Reset()
+		h.Write(msg)
+		if sum := h.Sum(nil); !bytes.Equal(sum, tag) {
+			t.Errorf("Sum: %d: after Reset: expected: %x\ngot: %x", i, tag, sum)
+		}
+	}
+}
+
+// PMAC reference examples, from
+// http://web.cs.ucdavis.edu/~rogaway/ocb/pmac-test.htm
+var pmacAESTestVectors = []struct {
+	key, message, tag string
+}{
+	{
+		"00010203 04050607 08090a0b 0c0d0e0f",
+		"",
+		"4399572c d6ea5341 b8d35876 a7098af7",
+	},
+	{
+		"00010203 04050607 08090a0b 0c0d0e0f",
+		"000102",
+		"256ba519 3c1b991b 4df0c51f 388a9e27",
+	},
+	{
+		"00010203 04050607 08090a0b 0c0d0e0f",
+		"00010203 04050607 08090a0b 0c0d0e0f",
+		"ebbd822f a458daf6 dfdad7c2 7da76338",
+	},
+	{
+		"00010203 04050607 08090a0b 0c0d0e0f",
+		"00010203 04050607 08090a0b 0c0d0e0f 10111213 14151617 18191a1b 1c1d1e1f 2021",
+		"5cba7d5e b24f7c86 ccc54604 e53d5512",
+	},
+	{
+		"00010203 04050607 08090a0b 0c0d0e0f",
+		strings.Repeat("00", 1000),
+		"c2c9fa1d 9985f6f0 d2aff915 a0e8d910",
+	},
+	{
+		"00010203 04050607 08090a0b 0c0d0e0f 10111213 14151617 18191a1b 1c1d1e1f",
+		"",
+		"e620f52f e75bbe87 ab758c06 24943d8b",
+	},
+	{
+		"00010203 04050607 08090a0b 0c0d0e0f 10111213 14151617 18191a1b 1c1d1e1f",
+		"00010203 04050607 08090a0b 0c0d0e0f 10111213",
+		"7711395f be9dec19 861aeb96 e052cd1b",
+	},
+}
+
+func TestPMAC(t *testing.T) {
+	for i, v := range pmacAESTestVectors {
+		b, err := aes.NewCipher(decode(v.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := NewPMAC(b)
+		if err != nil {
+			t.Fatalf("NewPMAC: %d: %s", i, err)
+		}
+		if h.Size() != 16 || h.BlockSize() != 16 {
+			t.Errorf("NewPMAC: %d: expected Size and BlockSize 16, got %d and %d", i, h.Size(), h.BlockSize())
+		}
+		msg, tag := decode(v.message), decode(v.tag)
+
+		// Write byte by byte, summing after every byte to check Sum leaves
+		// the state intact
+		for _, c := range msg {
+			h.Write([]byte{c})
+			h.Sum(nil)
+		}
+		if sum := h.Sum(nil); !bytes.Equal(sum, tag) {
+			t.Errorf("Sum: %d: expected: %x\ngot: %x", i, tag, sum)
+		}
+
+		h.Reset()
+		h.Write(msg)
+		if sum := h.Sum(nil); !bytes.Equal(sum, tag) {
+			t.Errorf("Sum: %d: after Reset: expected: %x\ngot: %x", i, tag, sum)
+		}
+	}
+}
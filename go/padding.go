@@ -0,0 +1,66 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"crypto/cipher"
+	"errors"
+)
+
+var (
+	ErrPaddingSize = errors.New("siv: padding block multiple must be positive")
+	ErrPadding     = errors.New("siv: invalid padding")
+)
+
+// paddingMarker is the byte which ends the plaintext within padding.
+const paddingMarker = 0x80
+
+// SealPadded pads plaintext to a multiple of blockMultiple bytes and seals
+// it with a, appending the result to dst, so that the ciphertext only reveals
+// which multiple of blockMultiple the plaintext's length rounds up to. The
+// padding is a 0x80 byte followed by as many zero bytes as are needed, so at
+// least one byte is always added, and a plaintext of an exact multiple gains
+// a whole block of padding.
+//
+// The padded plaintext is what a seals, so the padding is authenticated
+// along with it. OpenPadded opens and unpads the result.
+func SealPadded(a cipher.AEAD, dst, nonce, plaintext, data []byte, blockMultiple int) ([]byte, error) {
+	if blockMultiple < 1 {
+		return nil, ErrPaddingSize
+	}
+	if len(plaintext) >= maxInt-blockMultiple {
+		return nil, ErrPlaintextTooLong
+	}
+	n := (len(plaintext)/blockMultiple + 1) * blockMultiple
+	if err := checkSealSize(len(dst), a.Overhead(), n); err != nil {
+		return nil, err
+	}
+	padded := make([]byte, n)
+	defer zero(padded)
+	copy(padded, plaintext)
+	padded[len(plaintext)] = paddingMarker
+	return a.Seal(dst, nonce, padded, data), nil
+}
+
+// OpenPadded opens ciphertext produced by SealPadded with a, removes the
+// padding and appends the plaintext to dst. The padding is only examined once
+// the ciphertext has been authenticated, so ErrPadding, returned if it isn't
+// a 0x80 byte followed by zero bytes, means the ciphertext was sealed under
+// the same key without padding, and not that it was altered.
+func OpenPadded(a cipher.AEAD, dst, nonce, ciphertext, data []byte) ([]byte, error) {
+	out, err := a.Open(dst, nonce, ciphertext, data)
+	if err != nil {
+		return nil, err
+	}
+	padded := out[len(dst):]
+	i := len(padded) - 1
+	for i >= 0 && padded[i] == 0 {
+		i--
+	}
+	if i < 0 || padded[i] != paddingMarker {
+		zero(padded)
+		return nil, ErrPadding
+	}
+	zero(padded[i:])
+	return out[:len(dst)+i], nil
+}
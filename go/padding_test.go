@@ -0,0 +1,77 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealPadded(t *testing.T) {
+	key, nonce, ad := make([]byte, 32), make([]byte, 16), []byte("header")
+	a, _ := NewAEADAES(key, len(nonce))
+	for _, bm := range []int{1, 16, 100, 256} {
+		for _, n := range []int{0, 1, bm - 1, bm, bm + 1, 2 * bm, 300} {
+			pt := bytes.Repeat([]byte{0xaa}, n)
+			ct, err := SealPadded(a, []byte("prefix"), nonce, pt, ad, bm)
+			if err != nil {
+				t.Fatalf("SealPadded: multiple %d, %d bytes: %s", bm, n, err)
+			}
+			if string(ct[:6]) != "prefix" {
+				t.Fatalf("SealPadded: multiple %d, %d bytes: bad append: %x", bm, n, ct)
+			}
+			ct = ct[6:]
+
+			// Exact multiples gain a whole block
+			want := (n/bm + 1) * bm
+			if len(ct) != a.Overhead()+want {
+				t.Errorf("SealPadded: multiple %d, %d bytes: expected %d padded bytes, got %d", bm, n, want, len(ct)-a.Overhead())
+			}
+			padded, err := a.Open(nil, nonce, ct, ad)
+			if err != nil || !bytes.Equal(padded[:n], pt) || padded[n] != 0x80 || !bytes.Equal(padded[n+1:], make([]byte, want-n-1)) {
+				t.Errorf("SealPadded: multiple %d, %d bytes: padded plaintext: %x (%v)", bm, n, padded, err)
+			}
+
+			out, err := OpenPadded(a, []byte("prefix"), nonce, ct, ad)
+			if err != nil || string(out[:6]) != "prefix" || !bytes.Equal(out[6:], pt) {
+				t.Errorf("OpenPadded: multiple %d, %d bytes: expected: %x\ngot: %x (%v)", bm, n, pt, out, err)
+			}
+		}
+	}
+
+	ct, _ := SealPadded(a, nil, nonce, []byte("message"), ad, 16)
+	ct[len(ct)-1] ^= 1
+	if _, err := OpenPadded(a, nil, nonce, ct, ad); err != ErrNotAuthentic {
+		t.Errorf("OpenPadded: modified ciphertext: expected ErrNotAuthentic, got %v", err)
+	}
+	for _, bm := range []int{0, -1} {
+		if _, err := SealPadded(a, nil, nonce, nil, ad, bm); err != ErrPaddingSize {
+			t.Errorf("SealPadded: multiple %d: expected ErrPaddingSize, got %v", bm, err)
+		}
+	}
+}
+
+func TestOpenPaddedInvalid(t *testing.T) {
+	key, nonce, ad := make([]byte, 32), make([]byte, 16), []byte("header")
+	a, _ := NewAEADAES(key, len(nonce))
+	for _, pt := range [][]byte{
+		nil,
+		{0, 0, 0},
+		[]byte("no padding"),
+		{'x', 0x80, 1},
+		{'x', 0x81, 0},
+	} {
+		// Authentic, but without valid padding
+		ct := a.Seal(nil, nonce, pt, ad)
+		dst := []byte("prefix")
+		if out, err := OpenPadded(a, dst, nonce, ct, ad); err != ErrPadding || out != nil {
+			t.Errorf("OpenPadded: %x: expected ErrPadding, got %x (%v)", pt, out, err)
+		}
+		if string(dst) != "prefix" {
+			t.Errorf("OpenPadded: %x: dst modified: %x", pt, dst)
+		}
+	}
+	if out, err := OpenPadded(a, nil, nonce, a.Seal(nil, nonce, []byte{'x', 0x80, 0, 0}, ad), ad); err != nil || string(out) != "x" {
+		t.Errorf("OpenPadded: %q (%v)", out, err)
+	}
+}
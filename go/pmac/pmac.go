@@ -0,0 +1,387 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+// PMAC message authentication code, defined in
+// http://web.cs.ucdavis.edu/~rogaway/ocb/pmac.pdf
+
+package pmac
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"hash"
+	"math/bits"
+	"sync"
+)
+
+// Number of L blocks New precomputes (i.e. µ in the PMAC paper), enough for
+// messages of 2^precomputedBlocks blocks. Those needed by longer messages are
+// computed the first time one is MACed, and kept for later messages.
+const precomputedBlocks = 31
+
+// maxBlocks is the number of L blocks needed by the longest possible message,
+// as the block counter is 64 bits.
+const maxBlocks = 64
+
+// parallelBlocks is the smallest number of blocks worth handing to a
+// goroutine of its own, in digests returned by NewParallel.
+const parallelBlocks = 4096
+
+type pmac struct {
+	// c is the block cipher we're using (i.e. AES-128 or AES-256)
+	c cipher.Block
+
+	// l is defined as follows (quoted from the PMAC paper):
+	//
+	// Equation 1:
+	//
+	//     a · x =
+	//         a<<1 if firstbit(a)=0
+	//         (a<<1) ⊕ 0¹²⁰10000111 if firstbit(a)=1
+	//
+	// Equation 2:
+	//
+	//     a · x⁻¹ =
+	//         a>>1 if lastbit(a)=0
+	//         (a>>1) ⊕ 10¹²⁰1000011 if lastbit(a)=1
+	//
+	// Let L(0) ← L. For i ∈ [1..µ], compute L(i) ← L(i − 1) · x by
+	// Equation (1) using a shift and a conditional xor.
+	//
+	// Compute L(−1) ← L · x⁻¹ by Equation (2), using a shift and a
+	// conditional xor.
+	//
+	// Save the values L(−1), L(0), L(1), L(2), ..., L(µ) in a table.
+	// (Alternatively, [ed: as we have done in this codebase] defer computing
+	// some or all of these L(i) values until the value is actually needed.)
+	//
+	// l has room for maxBlocks values, of which the first ln are known to
+	// have been computed. It is shared with clones, guarded by lt.
+	l  []byte
+	ln int
+	lt *lTable
+
+	// lInv contains the multiplicative inverse (i.e. right shift) of
+	// the first l-value
+	lInv []byte
+
+	// digest contains the PMAC tag-in-progress
+	digest []byte
+
+	// offset is a block specific tweak to the input message
+	offset []byte
+
+	// buf contains a part of the input message, processed in 16-byte blocks
+	buf []byte
+	pos int
+
+	// tag is scratch space for computing the final tag in Sum
+	tag []byte
+
+	// ctr is the number of blocks we have MAC'd so far
+	ctr uint64
+
+	// workers is the number of goroutines large writes are split between,
+	// each of which is given at least minBlocks blocks
+	workers, minBlocks int
+}
+
+// lTable records how many of the L values shared by a digest and its clones
+// have been computed, so that only one of them computes each.
+type lTable struct {
+	mu sync.Mutex
+	n  int
+}
+
+// New returns a new instance of a PMAC message authentication code
+// digest using the given cipher.Block.
+func New(c cipher.Block) (hash.Hash, error) {
+	return NewPrecomputed(c, precomputedBlocks)
+}
+
+// NewPrecomputed returns a new PMAC digest like New, which precomputes the
+// L values needed by messages of up to 2^blocks blocks, rather than the 2^31
+// New does. The rest are computed as they are needed, so the choice only
+// affects how much work is done up front. blocks must be from 1 to 64.
+func NewPrecomputed(c cipher.Block, blocks int) (hash.Hash, error) {
+	n := c.BlockSize()
+	if n != 128/8 {
+		return nil, errors.New("pmac: invalid cipher block size")
+	}
+	if blocks < 1 || blocks > maxBlocks {
+		return nil, errors.New("pmac: invalid number of precomputed blocks")
+	}
+
+	d := new(pmac)
+	d.c = c
+	d.l = make([]byte, n*maxBlocks)
+	d.lt = &lTable{n: 1}
+	d.ln = 1
+	d.lInv = make([]byte, n)
+	d.digest = make([]byte, n)
+	d.offset = make([]byte, n)
+	d.buf = make([]byte, n)
+	d.tag = make([]byte, n)
+
+	tmp := d.l[:n]
+	c.Encrypt(tmp, tmp)
+	d.extendL(blocks)
+
+	copy(d.lInv, tmp)
+	lastBit := int(d.lInv[n-1] & 0x01)
+	for i := n - 1; i > 0; i-- {
+		d.lInv[i] = d.lInv[i]>>1 | d.lInv[i-1]<<7
+	}
+	d.lInv[0] >>= 1
+	d.lInv[0] ^= byte(subtle.ConstantTimeSelect(lastBit, 0x80, 0))
+	d.lInv[n-1] ^= byte(subtle.ConstantTimeSelect(lastBit, 0x43, 0))
+
+	return d, nil
+}
+
+// NewParallel returns a new PMAC digest like New, which splits large writes
+// between up to workers goroutines. Block encryptions in PMAC are independent
+// of each other, so the result is the same as that of New. c must be safe for
+// concurrent use, as the block ciphers in crypto/aes are.
+func NewParallel(c cipher.Block, workers int) (hash.Hash, error) {
+	h, err := New(c)
+	if err != nil {
+		return nil, err
+	}
+	d := h.(*pmac)
+	d.workers = workers
+	d.minBlocks = parallelBlocks
+	return d, nil
+}
+
+// Reset clears the digest state, starting a new digest.
+func (d *pmac) Reset() {
+	zero(d.digest)
+	zero(d.offset)
+	zero(d.buf)
+	d.pos = 0
+	d.ctr = 0
+}
+
+// Wipe overwrites the precomputed L values and digest state with zeros. The
+// digest must not be used afterwards.
+func (d *pmac) Wipe() {
+	d.Reset()
+	d.lt.mu.Lock()
+	zero(d.l)
+	d.lt.mu.Unlock()
+	zero(d.lInv)
+	zero(d.tag)
+}
+
+// Clone returns a copy of the digest in its current state. The copy shares
+// the L values of d, including any computed later by either digest, so
+// messages can be digested concurrently without computing them again, and
+// Wipe on either digest wipes them for both.
+func (d *pmac) Clone() hash.Hash {
+	c := *d
+	c.digest = append([]byte(nil), d.digest...)
+	c.offset = append([]byte(nil), d.offset...)
+	c.buf = append([]byte(nil), d.buf...)
+	c.tag = make([]byte, len(d.tag))
+	return &c
+}
+
+// Copy is like Clone, but the copy has L values of its own, computed so far
+// by d or its clones, so Wipe on either digest leaves the other's intact. The
+// block cipher is still shared.
+func (d *pmac) Copy() hash.Hash {
+	c := d.Clone().(*pmac)
+	d.lt.mu.Lock()
+	c.l = append([]byte(nil), d.l...)
+	c.lt = &lTable{n: d.lt.n}
+	d.lt.mu.Unlock()
+	c.ln = c.lt.n
+	c.lInv = append([]byte(nil), d.lInv...)
+	return c
+}
+
+// Write adds the given data to the digest state.
+func (d *pmac) Write(msg []byte) (nn int, err error) {
+	nn = len(msg)
+	bs := len(d.buf)
+	left := bs - d.pos
+
+	// The final block is processed by Sum, so a full block is only
+	// consumed once we know more input follows it.
+	if len(msg) > left {
+		copy(d.buf[d.pos:], msg[:left])
+		msg = msg[left:]
+		d.processBuffer()
+	}
+
+	if n := (len(msg) - 1) / bs; d.workers > 1 && n >= 2*d.minBlocks {
+		d.processBlocks(msg[:n*bs])
+		msg = msg[n*bs:]
+	}
+
+	for len(msg) > bs {
+		copy(d.buf, msg[:bs])
+		msg = msg[bs:]
+		d.processBuffer()
+	}
+
+	if len(msg) > 0 {
+		copy(d.buf[d.pos:], msg)
+		d.pos += len(msg)
+	}
+	return
+}
+
+// Sum returns the PMAC digest, one cipher block in length,
+// of the data written with Write.
+func (d *pmac) Sum(in []byte) []byte {
+	// Don't edit digest or buf, in case caller wants
+	// to keep digesting after call to Sum.
+	bs := len(d.buf)
+	tag := d.tag
+	copy(tag, d.digest)
+
+	if d.pos == bs {
+		xor(tag, d.buf)
+		xor(tag, d.lInv)
+	} else {
+		xor(tag, d.buf[:d.pos])
+		tag[d.pos] ^= 0x80
+	}
+
+	d.c.Encrypt(tag, tag)
+	return append(in, tag...)
+}
+
+func (d *pmac) Size() int { return len(d.digest) }
+
+func (d *pmac) BlockSize() int { return len(d.buf) }
+
+// processBuffer MACs the (full) block in buf, updating offset and digest.
+func (d *pmac) processBuffer() {
+	d.ctr++
+	xor(d.offset, d.lBlock(ntz(d.ctr)))
+	xor(d.buf, d.offset)
+	d.c.Encrypt(d.buf, d.buf)
+	xor(d.digest, d.buf)
+	d.pos = 0
+}
+
+// processBlocks MACs msg, a whole number of blocks, on several goroutines,
+// updating offset and digest as processBuffer would for each block in turn.
+func (d *pmac) processBlocks(msg []byte) {
+	bs := len(d.buf)
+	n := len(msg) / bs
+	workers := d.workers
+	if max := n / d.minBlocks; workers > max {
+		workers = max
+	}
+	per := (n + workers - 1) / workers
+	workers = (n + per - 1) / per
+	sums := make([]byte, workers*bs)
+
+	// The goroutines mustn't extend the table, so compute every value
+	// the blocks up to the last one need first
+	d.extendL(bits.Len64(d.ctr + uint64(n)))
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		start, end := w*per, (w+1)*per
+		if end > n {
+			end = n
+		}
+		wg.Add(1)
+		go func(sum, msg []byte, ctr uint64) {
+			defer wg.Done()
+			offset, buf := make([]byte, bs), make([]byte, bs)
+			d.offsetAt(ctr, offset)
+			for len(msg) > 0 {
+				ctr++
+				xor(offset, d.lBlock(ntz(ctr)))
+				copy(buf, msg[:bs])
+				xor(buf, offset)
+				d.c.Encrypt(buf, buf)
+				xor(sum, buf)
+				msg = msg[bs:]
+			}
+		}(sums[w*bs:(w+1)*bs], msg[start*bs:end*bs], d.ctr+uint64(start))
+	}
+	wg.Wait()
+
+	for w := 0; w < workers; w++ {
+		xor(d.digest, sums[w*bs:(w+1)*bs])
+	}
+	d.ctr += uint64(n)
+	d.offsetAt(d.ctr, d.offset)
+}
+
+// offsetAt stores the offset of block i (counting from one) in dst: the xor
+// of L(j) for each bit j set in the Gray code of i.
+func (d *pmac) offsetAt(i uint64, dst []byte) {
+	zero(dst)
+	for g, j := i^(i>>1), 0; g != 0; g, j = g>>1, j+1 {
+		if g&1 == 1 {
+			xor(dst, d.lBlock(j))
+		}
+	}
+}
+
+// lBlock returns L(i), extending the table if the message is the first long
+// enough to require it.
+func (d *pmac) lBlock(i int) []byte {
+	if i >= d.ln {
+		d.extendL(i + 1)
+	}
+	bs := len(d.buf)
+	return d.l[i*bs : (i+1)*bs]
+}
+
+// extendL computes L values by doubling until the table holds at least n,
+// unless this digest or a clone of it already has.
+func (d *pmac) extendL(n int) {
+	if n <= d.ln {
+		return
+	}
+	bs := len(d.buf)
+	d.lt.mu.Lock()
+	for ; d.lt.n < n; d.lt.n++ {
+		i := d.lt.n
+		dbl(d.l[(i-1)*bs:i*bs], d.l[i*bs:(i+1)*bs])
+	}
+	d.ln = d.lt.n
+	d.lt.mu.Unlock()
+}
+
+// ntz returns the number of trailing zero bits in i.
+func ntz(i uint64) int {
+	n := 0
+	for i&1 == 0 {
+		i >>= 1
+		n++
+	}
+	return n
+}
+
+// dbl computes src · x in GF(2^128) and stores the result in dst.
+func dbl(src, dst []byte) {
+	var b byte
+	for i := len(src) - 1; i >= 0; i-- {
+		bb := src[i] >> 7
+		dst[i] = src[i]<<1 | b
+		b = bb
+	}
+	dst[len(dst)-1] ^= byte(subtle.ConstantTimeSelect(int(b), 0x87, 0))
+}
+
+func xor(a, b []byte) {
+	for i, v := range b {
+		a[i] ^= v
+	}
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,434 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+// PMAC test vectors. See http://web.cs.ucdavis.edu/~rogaway/ocb/pmac-test.htm
+
+package pmac
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"hash"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+type pmacAESTest struct {
+	key    []byte
+	in     []byte
+	digest []byte
+}
+
+var commonKey128 = []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f}
+
+var commonKey256 = []byte{
+	0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+	0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f,
+}
+
+// counting returns a message of n bytes 0x00, 0x01, 0x02, ...
+func counting(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+func decode(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err.Error())
+	}
+	return b
+}
+
+var pmacAESTests = []pmacAESTest{
+	{commonKey128, nil, decode("4399572cd6ea5341b8d35876a7098af7")},
+	{commonKey128, counting(3), decode("256ba5193c1b991b4df0c51f388a9e27")},
+	{commonKey128, counting(16), decode("ebbd822fa458daf6dfdad7c27da76338")},
+	{commonKey128, counting(20), decode("0412ca150bbf79058d8c75a58c993f55")},
+	{commonKey128, counting(32), decode("e97ac04e9e5e3399ce5355cd7407bc75")},
+	{commonKey128, counting(34), decode("5cba7d5eb24f7c86ccc54604e53d5512")},
+	{commonKey128, make([]byte, 1000), decode("c2c9fa1d9985f6f0d2aff915a0e8d910")},
+	{commonKey256, nil, decode("e620f52fe75bbe87ab758c0624943d8b")},
+	{commonKey256, counting(3), decode("ffe124cc152cfb2bf1ef5409333c1c9a")},
+	{commonKey256, counting(16), decode("853fdbf3f91dcd36380d698a64770bab")},
+	{commonKey256, counting(20), decode("7711395fbe9dec19861aeb96e052cd1b")},
+	{commonKey256, counting(32), decode("08fa25c28678c84d383130653e77f4c0")},
+	{commonKey256, counting(34), decode("edd8a05f4b66761f9eee4feb4ed0c3a1")},
+	{commonKey256, make([]byte, 1000), decode("69aa77f231eb0cdff960f5561d29a96e")},
+}
+
+func TestPMAC_AES(t *testing.T) {
+	for i, tt := range pmacAESTests {
+		c, err := aes.NewCipher(tt.key)
+		if err != nil {
+			t.Errorf("test %d: NewCipher: %s", i, err)
+			continue
+		}
+		d, err := New(c)
+		if err != nil {
+			t.Errorf("test %d: NewPMAC: %s", i, err)
+			continue
+		}
+		n, err := d.Write(tt.in)
+		if err != nil || n != len(tt.in) {
+			t.Errorf("test %d: Write %d: %d, %s", i, len(tt.in), n, err)
+			continue
+		}
+		sum := d.Sum(nil)
+		if !bytes.Equal(sum, tt.digest) {
+			t.Errorf("test %d: digest mismatch\n\twant %x\n\thave %x", i, tt.digest, sum)
+			continue
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tt := pmacAESTests[5]
+	c, err := aes.NewCipher(tt.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test writing byte-by-byte
+	for _, b := range tt.in {
+		d.Write([]byte{b})
+	}
+	sum := d.Sum(nil)
+	if !bytes.Equal(sum, tt.digest) {
+		t.Fatalf("write bytes: digest mismatch\n\twant %x\n\thave %x", tt.digest, sum)
+	}
+
+	// Test writing halves
+	d.Reset()
+	d.Write(tt.in[:len(tt.in)/2])
+	d.Write(tt.in[len(tt.in)/2:])
+	sum = d.Sum(nil)
+	if !bytes.Equal(sum, tt.digest) {
+		t.Fatalf("write halves: digest mismatch\n\twant %x\n\thave %x", tt.digest, sum)
+	}
+
+	// Test writing a full block, then the rest
+	d.Reset()
+	d.Write(tt.in[:16])
+	d.Write(tt.in[16:])
+	sum = d.Sum(nil)
+	if !bytes.Equal(sum, tt.digest) {
+		t.Fatalf("write block: digest mismatch\n\twant %x\n\thave %x", tt.digest, sum)
+	}
+
+	// Test continuing after Sum
+	d.Reset()
+	d.Write(tt.in[:len(tt.in)/2])
+	sum = d.Sum(nil)
+	d.Write(tt.in[len(tt.in)/2:])
+	sum = d.Sum(nil)
+	if !bytes.Equal(sum, tt.digest) {
+		t.Fatalf("continue after Sum: digest mismatch\n\twant %x\n\thave %x", tt.digest, sum)
+	}
+}
+
+func BenchmarkPMAC_AES128(b *testing.B) {
+	c, _ := aes.NewCipher(commonKey128)
+	v := make([]byte, 1024)
+	out := make([]byte, 16)
+	b.SetBytes(int64(len(v)))
+	for i := 0; i < b.N; i++ {
+		d, _ := New(c)
+		d.Write(v)
+		out = d.Sum(out[:0])
+	}
+}
+
+func TestWipe(t *testing.T) {
+	c, err := aes.NewCipher(commonKey128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Write(counting(34))
+
+	x := d.(*pmac)
+	x.Wipe()
+	for _, b := range [][]byte{x.l, x.lInv, x.digest, x.offset, x.buf, x.tag} {
+		if !bytes.Equal(b, make([]byte, len(b))) {
+			t.Fatalf("Wipe: state not zeroed\n\tl %x\n\tlInv %x", x.l, x.lInv)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	tt := pmacAESTests[5]
+	c, err := aes.NewCipher(tt.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Write(tt.in[:len(tt.in)/2])
+
+	// The clone continues from the state of the original, independently of it
+	x := d.(*pmac).Clone()
+	x.Write(tt.in[len(tt.in)/2:])
+	if sum := x.Sum(nil); !bytes.Equal(sum, tt.digest) {
+		t.Fatalf("clone: digest mismatch\n\twant %x\n\thave %x", tt.digest, sum)
+	}
+	d.Write(tt.in[len(tt.in)/2:])
+	if sum := d.Sum(nil); !bytes.Equal(sum, tt.digest) {
+		t.Fatalf("original: digest mismatch\n\twant %x\n\thave %x", tt.digest, sum)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	tt := pmacAESTests[5]
+	c, err := aes.NewCipher(tt.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Write(tt.in[:len(tt.in)/2])
+
+	// The copy continues from the state of the original, and still works
+	// once the original is wiped
+	x := d.(*pmac).Copy()
+	d.(*pmac).Wipe()
+	x.Write(tt.in[len(tt.in)/2:])
+	if sum := x.Sum(nil); !bytes.Equal(sum, tt.digest) {
+		t.Fatalf("copy: digest mismatch\n\twant %x\n\thave %x", tt.digest, sum)
+	}
+
+	// And wiping the copy leaves a clone of it alone, unlike Clone
+	y := x.(*pmac).Copy()
+	x.(*pmac).Wipe()
+	y.Reset()
+	y.Write(tt.in)
+	if sum := y.Sum(nil); !bytes.Equal(sum, tt.digest) {
+		t.Fatalf("copy of copy: digest mismatch\n\twant %x\n\thave %x", tt.digest, sum)
+	}
+}
+
+func TestParallel(t *testing.T) {
+	c, err := aes.NewCipher(commonKey128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := New(c)
+	msg := counting(100*16 + 7)
+	for _, workers := range []int{2, 3, 8} {
+		h, err := NewParallel(c, workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Let small writes go parallel, so every split of the message is tried
+		h.(*pmac).minBlocks = 2
+		for n := 0; n <= len(msg); n += 5 {
+			for _, split := range []int{0, 1, 16, 17, n / 2} {
+				if split > n {
+					continue
+				}
+				serial.Reset()
+				serial.Write(msg[:n])
+				want := serial.Sum(nil)
+
+				h.Reset()
+				h.Write(msg[:split])
+				h.Write(msg[split:n])
+				if sum := h.Sum(nil); !bytes.Equal(sum, want) {
+					t.Fatalf("%d workers: %d bytes split at %d: digest mismatch\n\twant %x\n\thave %x", workers, n, split, want, sum)
+				}
+			}
+		}
+	}
+
+	// The default threshold, with a reference vector
+	tt := pmacAESTests[6]
+	h, _ := NewParallel(c, 4)
+	h.Write(make([]byte, 2*parallelBlocks*16+len(tt.in)))
+	serial.Reset()
+	serial.Write(make([]byte, 2*parallelBlocks*16+len(tt.in)))
+	if sum, want := h.Sum(nil), serial.Sum(nil); !bytes.Equal(sum, want) {
+		t.Fatalf("large message: digest mismatch\n\twant %x\n\thave %x", want, sum)
+	}
+	h.Reset()
+	h.Write(tt.in)
+	if sum := h.Sum(nil); !bytes.Equal(sum, tt.digest) {
+		t.Fatalf("digest mismatch\n\twant %x\n\thave %x", tt.digest, sum)
+	}
+}
+
+func TestOffsetAt(t *testing.T) {
+	c, _ := aes.NewCipher(commonKey128)
+	h, _ := New(c)
+	d := h.(*pmac)
+	offset := make([]byte, 16)
+	want := make([]byte, 16)
+	for i := uint64(1); i < 1000; i++ {
+		xor(want, d.lBlock(ntz(i)))
+		d.offsetAt(i, offset)
+		if !bytes.Equal(offset, want) {
+			t.Fatalf("offset %d: want %x, have %x", i, want, offset)
+		}
+	}
+}
+
+// referencePMAC computes PMAC as the paper describes it, block by block,
+// deriving each L(i) from L afresh rather than from a table.
+func referencePMAC(c cipher.Block, msg []byte) []byte {
+	var l, offset, sum, block [16]byte
+	c.Encrypt(l[:], l[:])
+	lx := func(i int) [16]byte {
+		v := l
+		for ; i > 0; i-- {
+			carry := v[0] >> 7
+			for j := 0; j < 15; j++ {
+				v[j] = v[j]<<1 | v[j+1]>>7
+			}
+			v[15] <<= 1
+			if carry == 1 {
+				v[15] ^= 0x87
+			}
+		}
+		return v
+	}
+
+	i := 0
+	for ; len(msg) > 16; msg = msg[16:] {
+		i++
+		x := lx(ntz(uint64(i)))
+		for j := range block {
+			offset[j] ^= x[j]
+			block[j] = msg[j] ^ offset[j]
+		}
+		c.Encrypt(block[:], block[:])
+		for j := range sum {
+			sum[j] ^= block[j]
+		}
+	}
+	for j, b := range msg {
+		sum[j] ^= b
+	}
+	if len(msg) == 16 {
+		// L · x⁻¹
+		lInv := l
+		for j := 15; j > 0; j-- {
+			lInv[j] = lInv[j]>>1 | lInv[j-1]<<7
+		}
+		lInv[0] >>= 1
+		if l[15]&1 == 1 {
+			lInv[0] ^= 0x80
+			lInv[15] ^= 0x43
+		}
+		for j := range sum {
+			sum[j] ^= lInv[j]
+		}
+	} else {
+		sum[len(msg)] ^= 0x80
+	}
+	c.Encrypt(sum[:], sum[:])
+	return sum[:]
+}
+
+func TestPrecomputed(t *testing.T) {
+	c, err := aes.NewCipher(commonKey128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := referencePMAC(c, pmacAESTests[6].in); !bytes.Equal(sum, pmacAESTests[6].digest) {
+		t.Fatalf("referencePMAC: digest mismatch\n\twant %x\n\thave %x", pmacAESTests[6].digest, sum)
+	}
+
+	// Messages which need no more than the precomputed values, exactly one
+	// more, and several more, so the table is extended across its end
+	for _, blocks := range []int{1, 2, 3, 4} {
+		h, err := NewPrecomputed(c, blocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := counting(16<<uint(blocks+2) + 1)
+		for n := 0; n <= len(msg); n++ {
+			h.Reset()
+			h.Write(msg[:n])
+			if sum, want := h.Sum(nil), referencePMAC(c, msg[:n]); !bytes.Equal(sum, want) {
+				t.Fatalf("%d precomputed: %d bytes: digest mismatch\n\twant %x\n\thave %x", blocks, n, want, sum)
+			}
+		}
+	}
+
+	// The values either side of the end of the default table
+	h, _ := New(c)
+	d := h.(*pmac)
+	if d.ln != precomputedBlocks {
+		t.Errorf("New: %d values precomputed, want %d", d.ln, precomputedBlocks)
+	}
+	ref, _ := NewPrecomputed(c, maxBlocks)
+	for i := precomputedBlocks - 1; i < maxBlocks; i++ {
+		if want := ref.(*pmac).lBlock(i); !bytes.Equal(d.lBlock(i), want) {
+			t.Fatalf("L(%d): want %x, have %x", i, want, d.lBlock(i))
+		}
+	}
+
+	for _, blocks := range []int{0, maxBlocks + 1} {
+		if _, err := NewPrecomputed(c, blocks); err == nil {
+			t.Errorf("NewPrecomputed: %d blocks: expected an error", blocks)
+		}
+	}
+}
+
+func TestPrecomputedClones(t *testing.T) {
+	// Clones extending the shared table at once, to be run with -race
+	c, _ := aes.NewCipher(commonKey128)
+	h, _ := NewPrecomputed(c, 1)
+	msg := counting(16 << 6)
+	want := referencePMAC(c, msg)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		d := h.(*pmac).Clone()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.Write(msg)
+			if sum := d.Sum(nil); !bytes.Equal(sum, want) {
+				t.Errorf("clone: digest mismatch\n\twant %x\n\thave %x", want, sum)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func benchmarkPMAC1M(b *testing.B, newPMAC func() (hash.Hash, error)) {
+	d, _ := newPMAC()
+	v := make([]byte, 1<<20)
+	out := make([]byte, 16)
+	b.SetBytes(int64(len(v)))
+	for i := 0; i < b.N; i++ {
+		d.Reset()
+		d.Write(v)
+		out = d.Sum(out[:0])
+	}
+}
+
+func BenchmarkPMAC_AES128_1M(b *testing.B) {
+	c, _ := aes.NewCipher(commonKey128)
+	benchmarkPMAC1M(b, func() (hash.Hash, error) { return New(c) })
+}
+
+func BenchmarkPMAC_AES128_1M_Parallel(b *testing.B) {
+	c, _ := aes.NewCipher(commonKey128)
+	benchmarkPMAC1M(b, func() (hash.Hash, error) { return NewParallel(c, runtime.GOMAXPROCS(0)) })
+}
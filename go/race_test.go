@@ -0,0 +1,12 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+//go:build race
+// +build race
+
+package miscreant
+
+func init() {
+	// The race detector makes sync.Pool drop items at random, so Seal and
+	// Open allocate scratch space they would otherwise reuse.
+	raceEnabled = true
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"crypto/aes"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/miscreant/miscreant/go/cmac"
+)
+
+// This file holds slow reference implementations of AES-CMAC (RFC 4493) and
+// S2V (RFC 5297), written for clarity straight from the pseudocode of the
+// RFCs, to check the package against on random inputs.
+
+// refDbl is dbl of RFC 5297 section 2.3, multiplication by x in GF(2^128),
+// on a block as a 128-bit big endian integer.
+func refDbl(b []byte) []byte {
+	n := new(big.Int).SetBytes(b)
+	n.Lsh(n, 1)
+	if n.Bit(128) == 1 {
+		n.SetBit(n, 128, 0)
+		n.Xor(n, big.NewInt(0x87))
+	}
+	out := make([]byte, 16)
+	v := n.Bytes()
+	copy(out[16-len(v):], v)
+	return out
+}
+
+func refXor(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+// refCMAC is AES-CMAC of RFC 4493 section 2.4.
+func refCMAC(key, m []byte) []byte {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	// Generate_Subkey
+	l := make([]byte, 16)
+	b.Encrypt(l, l)
+	k1 := refDbl(l)
+	k2 := refDbl(k1)
+
+	n := (len(m) + 15) / 16
+	var last []byte
+	if n == 0 {
+		n = 1
+	}
+	if len(m) > 0 && len(m)%16 == 0 {
+		last = refXor(m[16*(n-1):], k1)
+	} else {
+		padded := make([]byte, 16)
+		copy(padded, m[16*(n-1):])
+		padded[len(m)-16*(n-1)] = 0x80
+		last = refXor(padded, k2)
+	}
+	x := make([]byte, 16)
+	for i := 0; i < n-1; i++ {
+		b.Encrypt(x, refXor(x, m[16*i:16*(i+1)]))
+	}
+	b.Encrypt(x, refXor(x, last))
+	return x
+}
+
+// refS2V is S2V of RFC 5297 section 2.4.
+func refS2V(key []byte, s ...[]byte) []byte {
+	if len(s) == 0 {
+		one := make([]byte, 16)
+		one[15] = 1
+		return refCMAC(key, one)
+	}
+	d := refCMAC(key, make([]byte, 16))
+	for _, si := range s[:len(s)-1] {
+		d = refXor(refDbl(d), refCMAC(key, si))
+	}
+	sn := s[len(s)-1]
+	var t []byte
+	if len(sn) >= 16 {
+		// xorend
+		t = append([]byte(nil), sn...)
+		copy(t[len(t)-16:], refXor(t[len(t)-16:], d))
+	} else {
+		padded := make([]byte, 16)
+		copy(padded, sn)
+		padded[len(sn)] = 0x80
+		t = refXor(refDbl(d), padded)
+	}
+	return refCMAC(key, t)
+}
+
+func TestReferenceVectors(t *testing.T) {
+	// RFC 4493 section 4 and RFC 5297 appendix A.1
+	key := decode("2b7e1516 28aed2a6 abf71588 09cf4f3c")
+	m := decode("6bc1bee2 2e409f96 e93d7e11 7393172a ae2d8a57 1e03ac9c 9eb76fac 45af8e51 30c81c46 a35ce411")
+	for _, tt := range []struct {
+		n    int
+		want string
+	}{
+		{0, "bb1d6929 e9593728 7fa37d12 9b756746"},
+		{16, "070a16b4 6b4d4144 f79bdd9d d04a287c"},
+		{40, "dfa66747 de9ae630 30ca3261 1497c827"},
+	} {
+		if got := refCMAC(key, m[:tt.n]); !bytes.Equal(got, decode(tt.want)) {
+			t.Errorf("refCMAC: %d bytes: expected: %s\ngot: %x", tt.n, tt.want, got)
+		}
+	}
+
+	v := testVectors[0]
+	key = decode(v.key)
+	got := refS2V(key[:16], append(decodeAD(v.adata), decode(v.plaintext))...)
+	if want := decode(v.output)[:16]; !bytes.Equal(got, want) {
+		t.Errorf("refS2V: expected: %x\ngot: %x", want, got)
+	}
+}
+
+// TestReference checks CMAC, S2V and the synthetic IV of Seal against the
+// reference implementations on random keys, associated data and plaintexts.
+func TestReference(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		r.Read(b)
+		return b
+	}
+	cases := 3000
+	if testing.Short() {
+		cases = 300
+	}
+	for i := 0; i < cases; i++ {
+		key := random([]int{32, 48, 64}[r.Intn(3)])
+		macKey := key[:len(key)/2]
+		ad := make([][]byte, r.Intn(6))
+		for j := range ad {
+			ad[j] = random(r.Intn(101))
+		}
+		// Lengths around the block size are the likeliest to go wrong
+		n := r.Intn(1001)
+		if r.Intn(4) == 0 {
+			n = 15 + r.Intn(3)
+		}
+		pt := random(n)
+
+		b, _ := aes.NewCipher(macKey)
+		h, _ := cmac.New(b)
+		h.Write(pt)
+		if got, want := h.Sum(nil), refCMAC(macKey, pt); !bytes.Equal(got, want) {
+			t.Fatalf("CMAC: %d-byte key, %d bytes: expected: %x\ngot: %x", len(macKey), n, want, got)
+		}
+
+		want := refS2V(macKey, append(ad, pt)...)
+		if got, err := S2V(macKey, append(ad, pt)...); err != nil || !bytes.Equal(got[:], want) {
+			t.Fatalf("S2V: %d-byte key, %d items, %d bytes: expected: %x\ngot: %x (%v)", len(macKey), len(ad), n, want, got, err)
+		}
+		c, _ := NewAES(key)
+		if ct, err := c.Seal(nil, pt, ad...); err != nil || !bytes.Equal(ct[:TagSize], want) {
+			t.Fatalf("Seal: %d-byte key, %d items, %d bytes: expected IV: %x\ngot: %x (%v)", len(key), len(ad), n, want, ct, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"crypto/aes"
+
+	"github.com/miscreant/miscreant/go/cmac"
+)
+
+// S2V computes the S2V pseudo-random function of RFC 5297 section 2.4 over
+// the given strings, using AES-CMAC with key, which must be 16, 24, or 32
+// bytes long. At most MaxAssociatedDataItems+1 strings may be given.
+//
+// Every string but the last is MACed and folded into the doubled running
+// value. If the last string is at least 16 bytes long, the running value is
+// xored into its final 16 bytes (xorend); otherwise the running value is
+// doubled once more and xored with the string padded by 10*. S2V of no
+// strings is defined separately, as the MAC of the block 0^127 1.
+//
+// When key is the first half of an AES-SIV key, and strings are the
+// associated data items followed by the plaintext, the result is the
+// synthetic IV that Seal places in front of the ciphertext.
+func S2V(key []byte, strings ...[]byte) (v [TagSize]byte, err error) {
+	if len(strings) > MaxAssociatedDataItems+1 {
+		return v, ErrTooManyAssociatedDataItems
+	}
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return v, err
+	}
+	h, err := cmac.New(b)
+	if err != nil {
+		return v, err
+	}
+	defer h.(wiper).Wipe()
+
+	if len(strings) == 0 {
+		// S2V of no strings is CMAC(<one>)
+		one := make([]byte, aes.BlockSize)
+		one[len(one)-1] = 1
+		h.Write(one)
+		h.Sum(v[:0])
+		return v, nil
+	}
+
+	st := newState(h, aes.BlockSize)
+	st.s2vStart(zeroBlockMAC(h))
+	for _, v := range strings[:len(strings)-1] {
+		h.Write(v)
+		st.s2vNext()
+	}
+	copy(v[:], st.s2vFinish(strings[len(strings)-1]))
+	zero(st.tmp1)
+	zero(st.tmp2)
+	return v, nil
+}
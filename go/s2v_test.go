@@ -0,0 +1,135 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"crypto/aes"
+	"testing"
+)
+
+type s2vTestVector struct {
+	key     string
+	strings []string
+	output  string
+}
+
+var s2vTestVectors = []s2vTestVector{
+	// RFC 5297 A.1: the header followed by the plaintext
+	{
+		"fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0",
+		[]string{
+			"10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627",
+			"11223344 55667788 99aabbcc ddee",
+		},
+		"85632d07 c6e8f37f 950acd32 0a2ecc93",
+	},
+	// RFC 5297 A.2: two headers, the nonce and the plaintext
+	{
+		"7f7e7d7c 7b7a7978 77767574 73727170",
+		[]string{
+			"00112233 44556677 8899aabb ccddeeff deaddada deaddada ffeeddcc bbaa9988 77665544 33221100",
+			"10203040 50607080 90a0",
+			"09f91102 9d74e35b d84156c5 635688c0",
+			"74686973 20697320 736f6d65 20706c61 696e7465 78742074 6f20656e 63727970 74207573 696e6720 5349562d 414553",
+		},
+		"7bdb6e3b 432667eb 06f4d14b ff2fbd0f",
+	},
+	// A single empty string: the zero block's CMAC is doubled and padded
+	{
+		"fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0",
+		[]string{""},
+		"f2007a5b eb2b8900 c588a7ad f599f172",
+	},
+}
+
+func TestS2V(t *testing.T) {
+	for i, v := range s2vTestVectors {
+		out, err := S2V(decode(v.key), decodeAD(v.strings)...)
+		if err != nil {
+			t.Fatalf("S2V: %d: %s", i, err)
+		}
+		if want := decode(v.output); !bytes.Equal(want, out[:]) {
+			t.Errorf("S2V: %d: expected: %x\ngot: %x", i, want, out)
+		}
+	}
+}
+
+func TestS2VIntermediateValues(t *testing.T) {
+	// RFC 5297 A.1 lists S2V's running value after each step
+	v := s2vTestVectors[0]
+	b, err := aes.NewCipher(decode(v.key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewCMAC(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := newState(h, 16)
+	check := func(step string, got []byte, want string) {
+		if !bytes.Equal(got, decode(want)) {
+			t.Errorf("%s: expected: %s\ngot: %x", step, want, got)
+		}
+	}
+
+	st.s2vStart(zeroBlockMAC(h))
+	check("CMAC(zero)", st.tmp2, "0e04dfaf c1efbf04 01405828 59bf073a")
+	h.Write(decode(v.strings[0]))
+	check("CMAC(ad)", h.Sum(nil), "f1f922b7 f5193ce6 4ff80cb4 7d93f23b")
+	st.s2vNext()
+	check("xor", st.tmp2, "edf09de8 76c642ee 4d78bce4 ceedfc4f")
+	check("CMAC(final)", st.s2vFinish(decode(v.strings[1])), "85632d07 c6e8f37f 950acd32 0a2ecc93")
+}
+
+func TestS2VNoStrings(t *testing.T) {
+	// RFC 5297 section 2.4: S2V of no strings is CMAC(<one>)
+	key := decode(s2vTestVectors[0].key)
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewCMAC(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	one := make([]byte, 16)
+	one[15] = 1
+	h.Write(one)
+	want := h.Sum(nil)
+
+	out, err := S2V(key)
+	if err != nil {
+		t.Fatalf("S2V: %s", err)
+	}
+	if !bytes.Equal(want, out[:]) {
+		t.Errorf("S2V: expected: %x\ngot: %x", want, out)
+	}
+}
+
+func TestS2VMatchesSeal(t *testing.T) {
+	for i, v := range testVectors {
+		key := decode(v.key)
+		strings := append(decodeAD(v.adata), decode(v.plaintext))
+		out, err := S2V(key[:len(key)/2], strings...)
+		if err != nil {
+			t.Fatalf("S2V: %d: %s", i, err)
+		}
+		if want := decode(v.output)[:16]; !bytes.Equal(want, out[:]) {
+			t.Errorf("S2V: %d: expected: %x\ngot: %x", i, want, out)
+		}
+	}
+}
+
+func TestS2VErrors(t *testing.T) {
+	if _, err := S2V(make([]byte, 15), nil); err == nil {
+		t.Error("S2V: 15 byte key: expected an error")
+	}
+	strings := make([][]byte, MaxAssociatedDataItems+2)
+	if _, err := S2V(make([]byte, 16), strings...); err != ErrTooManyAssociatedDataItems {
+		t.Errorf("S2V: expected ErrTooManyAssociatedDataItems, got %v", err)
+	}
+	if _, err := S2V(make([]byte, 16), strings[1:]...); err != nil {
+		t.Errorf("S2V: maximum number of strings: %s", err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import "errors"
+
+var ErrSealWriterFinalized = errors.New("siv: SealWriter already finalized")
+
+// SealWriter seals a plaintext which is written to it in chunks, for callers
+// which don't have the whole message at hand when they start producing it.
+//
+// SIV takes two passes over the plaintext, so a SealWriter keeps a copy of
+// everything written to it until Finalize. The first pass, computing the MAC
+// of the plaintext for S2V, is done as the chunks arrive; Finalize only needs
+// to finish S2V and encrypt. As the associated data is folded into S2V
+// separately from the plaintext, it needn't be known until Finalize.
+//
+// A SealWriter must not be used by multiple goroutines at once, but any
+// number of them may be in progress on the same Cipher.
+type SealWriter struct {
+	c    *Cipher
+	st   *state
+	buf  []byte
+	mac  int // the number of bytes of buf written to the MAC
+	done bool
+}
+
+// NewSealWriter returns a SealWriter for a single message sealed with c.
+func (c *Cipher) NewSealWriter() *SealWriter {
+	return &SealWriter{c: c}
+}
+
+// Write adds p to the plaintext. It returns ErrPlaintextTooLong if the
+// plaintext would exceed MaxPlaintextSize.
+func (w *SealWriter) Write(p []byte) (n int, err error) {
+	switch {
+	case w.done:
+		return 0, ErrSealWriterFinalized
+	case w.c.b == nil:
+		return 0, ErrReset
+	case w.st == nil:
+		w.st = w.c.getState()
+		w.st.h.Reset()
+	}
+	// The first check means the sum in the second can't overflow
+	if err := checkSealSize(len(w.buf), TagSize, len(p)); err != nil {
+		return 0, err
+	}
+	size := len(w.buf) + len(p)
+	if err := checkSealSize(0, TagSize, size); err != nil {
+		return 0, err
+	}
+	if size > cap(w.buf) {
+		// Grow by hand, so the old copy can be zeroed
+		newCap := size
+		if cap(w.buf) <= (maxInt-size)/2 {
+			newCap += cap(w.buf) * 2
+		}
+		buf := make([]byte, len(w.buf), newCap)
+		copy(buf, w.buf)
+		zero(w.buf)
+		w.buf = buf
+	}
+	w.buf = append(w.buf, p...)
+
+	// The last block is held back, as S2V xors it with the result of the
+	// associated data before it is MACed (RFC 5297 section 2.4)
+	if n := len(w.buf) - w.st.h.BlockSize(); n > w.mac {
+		w.st.h.Write(w.buf[w.mac:n])
+		w.mac = n
+	}
+	return len(p), nil
+}
+
+// Finalize authenticates the given associated data items along with the
+// plaintext written so far, encrypts the plaintext and appends the result to
+// dst, giving the same result as Cipher.Seal. The retained copy of the
+// plaintext is then overwritten with zeros, and the SealWriter can't be used
+// again.
+//
+// For nonce-based encryption, the nonce should be the last associated data item.
+func (w *SealWriter) Finalize(dst []byte, data ...[]byte) ([]byte, error) {
+	switch {
+	case w.done:
+		return nil, ErrSealWriterFinalized
+	case w.c.b == nil:
+		w.release()
+		return nil, ErrReset
+	case len(data) > MaxAssociatedDataItems:
+		return nil, ErrTooManyAssociatedDataItems
+	case checkSealSize(len(dst), TagSize, len(w.buf)) != nil:
+		w.release()
+		return nil, ErrPlaintextTooLong
+	case w.st == nil:
+		w.st = w.c.getState()
+		w.st.h.Reset()
+	}
+	c, st := w.c, w.st
+	defer w.release()
+
+	// S2V of the associated data, computed with scratch space of its own
+	// since st.h holds the MAC of the plaintext so far
+	ad := c.getState()
+	ad.s2vStart(c.zeroMAC)
+	for _, v := range data {
+		ad.h.Write(v)
+		ad.s2vNext()
+	}
+	copy(st.tmp2, ad.tmp2)
+	c.putState(ad)
+
+	iv := st.s2vFinish(w.buf[w.mac:])
+	ret, out := sliceForAppend(dst, len(iv)+len(w.buf))
+	copy(out, iv)
+	zeroIVBits(iv)
+	c.xorKeyStream(st, out[len(iv):], w.buf, iv)
+	return ret, nil
+}
+
+// release zeroes the retained plaintext and returns the scratch space to the
+// pool, after which the SealWriter can't be used.
+func (w *SealWriter) release() {
+	zero(w.buf)
+	w.buf = nil
+	if w.st != nil {
+		w.c.putState(w.st)
+		w.st = nil
+	}
+	w.done = true
+}
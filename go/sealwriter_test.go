@@ -0,0 +1,66 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealWriter(t *testing.T) {
+	for _, newCipher := range []func([]byte) (*Cipher, error){NewAES, NewPMACSIV} {
+		c, err := newCipher(make([]byte, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ad, nonce := []byte("header"), []byte("nonce")
+		for _, n := range []int{0, 1, 15, 16, 17, 31, 32, 33, 47, 48, 49, aesCTRSize + 5} {
+			m := make([]byte, n)
+			for i := range m {
+				m[i] = byte(i)
+			}
+			want, _ := c.Seal([]byte("prefix"), m, ad, nonce)
+			for _, chunk := range []int{1, 3, 16, 17, n + 1} {
+				w := c.NewSealWriter()
+				for i := 0; i < n; i += chunk {
+					end := i + chunk
+					if end > n {
+						end = n
+					}
+					if _, err := w.Write(m[i:end]); err != nil {
+						t.Fatalf("Write: %s", err)
+					}
+				}
+				retained := w.buf
+				ct, err := w.Finalize([]byte("prefix"), ad, nonce)
+				if err != nil || !bytes.Equal(want, ct) {
+					t.Errorf("Finalize: %d bytes in %d byte chunks: expected: %x\ngot: %x (%v)", n, chunk, want, ct, err)
+				}
+				if !bytes.Equal(retained, make([]byte, len(retained))) {
+					t.Errorf("Finalize: %d bytes: retained plaintext not zeroed", n)
+				}
+			}
+		}
+
+		// No associated data at all
+		w := c.NewSealWriter()
+		w.Write([]byte("plaintext"))
+		want, _ := c.Seal(nil, []byte("plaintext"))
+		if ct, _ := w.Finalize(nil); !bytes.Equal(want, ct) {
+			t.Errorf("Finalize: no associated data: expected: %x\ngot: %x", want, ct)
+		}
+		if _, err := w.Finalize(nil); err != ErrSealWriterFinalized {
+			t.Errorf("Finalize: reused: expected ErrSealWriterFinalized, got %v", err)
+		}
+		if _, err := w.Write([]byte("more")); err != ErrSealWriterFinalized {
+			t.Errorf("Write: reused: expected ErrSealWriterFinalized, got %v", err)
+		}
+
+		w = c.NewSealWriter()
+		w.Write([]byte("plaintext"))
+		c.Reset()
+		if _, err := w.Finalize(nil); err != ErrReset {
+			t.Errorf("Finalize: expected ErrReset, got %v", err)
+		}
+	}
+}
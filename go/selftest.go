@@ -0,0 +1,117 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"encoding/hex"
+)
+
+// selfTestVector is a known answer for SelfTest, with all fields in hex.
+type selfTestVector struct {
+	name       string
+	alg        Algorithm
+	key        string
+	ad         []string
+	plaintext  string
+	ciphertext string
+}
+
+// selfTestVectors are the examples of RFC 5297 appendix A and their
+// AES-PMAC-SIV counterparts, which are also in the vectors directory.
+var selfTestVectors = []selfTestVector{
+	{
+		"AES-SIV RFC 5297 A.1", AlgorithmAESSIV,
+		"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff",
+		[]string{"101112131415161718191a1b1c1d1e1f2021222324252627"},
+		"112233445566778899aabbccddee",
+		"85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c",
+	},
+	{
+		"AES-SIV RFC 5297 A.2", AlgorithmAESSIV,
+		"7f7e7d7c7b7a79787776757473727170404142434445464748494a4b4c4d4e4f",
+		[]string{
+			"00112233445566778899aabbccddeeffdeaddadadeaddadaffeeddccbbaa99887766554433221100",
+			"102030405060708090a0",
+			"09f911029d74e35bd84156c5635688c0",
+		},
+		"7468697320697320736f6d6520706c61696e7465787420746f20656e6372797074207573696e67205349562d414553",
+		"7bdb6e3b432667eb06f4d14bff2fbd0fcb900f2fddbe404326601965c889bf17dba77ceb094fa663b7a3f748ba8af829ea64ad544a272e9c485b62a3fd5c0d",
+	},
+	{
+		"AES-PMAC-SIV A.1", AlgorithmAESPMACSIV,
+		"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff",
+		[]string{"101112131415161718191a1b1c1d1e1f2021222324252627"},
+		"112233445566778899aabbccddee",
+		"8c4b814216140fc9b34a41716aa61633ea66abe16b2f6e4bceeda6e9077f",
+	},
+	{
+		"AES-PMAC-SIV A.2", AlgorithmAESPMACSIV,
+		"7f7e7d7c7b7a79787776757473727170404142434445464748494a4b4c4d4e4f",
+		[]string{
+			"00112233445566778899aabbccddeeffdeaddadadeaddadaffeeddccbbaa99887766554433221100",
+			"102030405060708090a0",
+			"09f911029d74e35bd84156c5635688c0",
+		},
+		"7468697320697320736f6d6520706c61696e7465787420746f20656e6372797074207573696e67205349562d414553",
+		"acb9cbc95dbed8e766d25ad59deb65bcda7aff9214153273f88e89ebe580c77defc15d28448f420e0a17d42722e6d42776849aa3bec375c5a05e54f519e9fd",
+	},
+}
+
+// SelfTestError is returned by SelfTest when a known answer test fails.
+type SelfTestError struct {
+	// Vector names the known answer which failed, and Step what went wrong
+	Vector, Step string
+}
+
+func (e *SelfTestError) Error() string {
+	return "siv: self-test failed: " + e.Vector + ": " + e.Step
+}
+
+// SelfTest runs known answer tests of AES-SIV and AES-PMAC-SIV, as a
+// power-on self-test: the examples of RFC 5297 appendix A, and their
+// AES-PMAC-SIV counterparts, are sealed and opened, and each must fail to
+// open with a corrupted synthetic IV. It returns a *SelfTestError naming the
+// first which fails, or nil. SelfTest takes a few tens of microseconds, so it
+// can be run from init or main.
+func SelfTest() error {
+	return selfTest(func(alg Algorithm, key []byte) (*Cipher, error) {
+		if alg == AlgorithmAESPMACSIV {
+			return NewPMACSIV(key)
+		}
+		return NewAES(key)
+	})
+}
+
+// selfTest is SelfTest with the ciphers made by newCipher.
+func selfTest(newCipher func(alg Algorithm, key []byte) (*Cipher, error)) error {
+	for _, v := range selfTestVectors {
+		fail := func(step string) error { return &SelfTestError{Vector: v.name, Step: step} }
+		key, _ := hex.DecodeString(v.key)
+		pt, _ := hex.DecodeString(v.plaintext)
+		want, _ := hex.DecodeString(v.ciphertext)
+		ad := make([][]byte, len(v.ad))
+		for i, s := range v.ad {
+			ad[i], _ = hex.DecodeString(s)
+		}
+
+		c, err := newCipher(v.alg, key)
+		if err != nil {
+			return fail("creating the cipher: " + err.Error())
+		}
+		ct, err := c.Seal(nil, pt, ad...)
+		if err != nil || !bytes.Equal(ct, want) {
+			return fail("Seal gave the wrong ciphertext")
+		}
+		out, err := c.Open(nil, ct, ad...)
+		if err != nil || !bytes.Equal(out, pt) {
+			return fail("Open of the ciphertext failed")
+		}
+		ct[0] ^= 1
+		if out, err := c.Open(nil, ct, ad...); err != ErrNotAuthentic || out != nil {
+			return fail("Open accepted a corrupted synthetic IV")
+		}
+		c.Reset()
+	}
+	return nil
+}
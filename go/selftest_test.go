@@ -0,0 +1,79 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"testing"
+
+	"github.com/miscreant/miscreant/go/pmac"
+)
+
+func TestSelfTest(t *testing.T) {
+	if err := SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+	// The vectors are the first two of each algorithm in the tests
+	for i, tv := range []testVector{testVectors[0], testVectors[1], pmacTestVectors[0], pmacTestVectors[1]} {
+		if v := selfTestVectors[i]; !bytes.Equal(decode(v.ciphertext), decode(tv.output)) {
+			t.Errorf("%s: ciphertext differs from the test vectors", v.name)
+		}
+	}
+}
+
+// brokenBlock is AES with one bit of each output block flipped.
+type brokenBlock struct{ cipher.Block }
+
+func (b brokenBlock) Encrypt(dst, src []byte) {
+	b.Block.Encrypt(dst, src)
+	dst[0] ^= 1
+}
+
+func TestSelfTestBroken(t *testing.T) {
+	newBroken := func(key []byte) (cipher.Block, error) {
+		b, err := aes.NewCipher(key)
+		return brokenBlock{b}, err
+	}
+	// The PMAC-SIV vectors only fail with the AES-SIV ones working
+	for _, tt := range []struct {
+		brokenAlg Algorithm
+		want      string
+	}{
+		{AlgorithmAESSIV, "siv: self-test failed: AES-SIV RFC 5297 A.1: Seal gave the wrong ciphertext"},
+		{AlgorithmAESPMACSIV, "siv: self-test failed: AES-PMAC-SIV A.1: Seal gave the wrong ciphertext"},
+	} {
+		err := selfTest(func(alg Algorithm, key []byte) (*Cipher, error) {
+			newBlock := aes.NewCipher
+			if alg == tt.brokenAlg {
+				newBlock = newBroken
+			}
+			macBlock, ctrBlock, err := newBlocks(newBlock, key)
+			if err != nil {
+				return nil, err
+			}
+			if alg == AlgorithmAESPMACSIV {
+				h, _ := pmac.New(macBlock)
+				return newCipher(h, ctrBlock, alg), nil
+			}
+			return NewSIV(macBlock, ctrBlock)
+		})
+		var se *SelfTestError
+		if !errors.As(err, &se) || err.Error() != tt.want {
+			t.Errorf("selfTest: broken %s: expected %q, got %v", tt.brokenAlg, tt.want, err)
+		}
+	}
+
+	err := selfTest(func(Algorithm, []byte) (*Cipher, error) { return nil, ErrKeySize })
+	if want := "siv: self-test failed: AES-SIV RFC 5297 A.1: creating the cipher: siv: bad key size"; err == nil || err.Error() != want {
+		t.Errorf("selfTest: expected %q, got %v", want, err)
+	}
+}
+
+func BenchmarkSelfTest(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		SelfTest()
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"crypto/cipher"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrCounterSize            = errors.New("siv: nonce counter must be 8 or 12 bytes")
+	ErrNonceSequenceExhausted = errors.New("siv: nonce sequence exhausted")
+	ErrNonceSequenceAEADSize  = errors.New("siv: AEAD nonce size doesn't match the nonce sequence")
+)
+
+// NonceSequence produces the nonces of a sender, each a fixed prefix, such
+// as a random salt, followed by a big endian counter which is incremented
+// for each nonce, so no nonce is produced twice. Once the counter has taken
+// its largest value, Next returns ErrNonceSequenceExhausted rather than
+// wrapping around.
+//
+// A NonceSequence may be used by multiple goroutines at once. Session
+// manages nonces in much the same way, with an 8-byte session ID and
+// counter, along with the AEAD they are used with.
+type NonceSequence struct {
+	mu     sync.Mutex
+	next   []byte // prefix followed by the next counter
+	prefix int
+	done   bool
+}
+
+// NewNonceSequence returns a NonceSequence of nonces made of prefix followed
+// by a counter of counterSize bytes, which must be 8 or 12, for a 64- or
+// 96-bit counter. The counter starts from start, which must be nil, for
+// zero, or counterSize bytes, big endian. The nonces, of
+// len(prefix)+counterSize bytes, must be at most MaxNonceSize bytes long, or
+// ErrNonceSize is returned.
+func NewNonceSequence(prefix []byte, counterSize int, start []byte) (*NonceSequence, error) {
+	if counterSize != 8 && counterSize != 12 {
+		return nil, ErrCounterSize
+	}
+	if start != nil && len(start) != counterSize {
+		return nil, ErrCounterSize
+	}
+	if len(prefix) > MaxNonceSize-counterSize {
+		return nil, ErrNonceSize
+	}
+	next := make([]byte, len(prefix)+counterSize)
+	copy(next, prefix)
+	copy(next[len(prefix):], start)
+	return &NonceSequence{next: next, prefix: len(prefix)}, nil
+}
+
+// NonceSize returns the size of the nonces produced.
+func (s *NonceSequence) NonceSize() int { return len(s.next) }
+
+// Next returns the next nonce of the sequence, or ErrNonceSequenceExhausted
+// once the largest counter has been used.
+func (s *NonceSequence) Next() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return nil, ErrNonceSequenceExhausted
+	}
+	nonce := append([]byte(nil), s.next...)
+	counter := s.next[s.prefix:]
+	incCounter(counter)
+	// The counter wrapped to zero, so the nonce just returned was the last
+	s.done = isZero(counter)
+	return nonce, nil
+}
+
+// Seal seals plaintext and data with a under the next nonce of the
+// sequence, appends the result to dst and returns it along with the nonce,
+// which must be passed to Open along with the ciphertext. a must take
+// nonces of the size the sequence produces, or ErrNonceSequenceAEADSize is
+// returned.
+func (s *NonceSequence) Seal(a cipher.AEAD, dst, plaintext, data []byte) (ciphertext, nonce []byte, err error) {
+	if a.NonceSize() != s.NonceSize() {
+		return nil, nil, ErrNonceSequenceAEADSize
+	}
+	if err := checkSealSize(len(dst), a.Overhead(), len(plaintext)); err != nil {
+		return nil, nil, err
+	}
+	if nonce, err = s.Next(); err != nil {
+		return nil, nil, err
+	}
+	return a.Seal(dst, nonce, plaintext, data), nonce, nil
+}
+
+// isZero reports whether b is all zero bytes.
+func isZero(b []byte) bool {
+	var x byte
+	for _, v := range b {
+		x |= v
+	}
+	return x == 0
+}
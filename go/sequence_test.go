@@ -0,0 +1,95 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNonceSequence(t *testing.T) {
+	salt := decode("a0a1a2a3")
+	s, err := NewNonceSequence(salt, 12, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.NonceSize() != 16 {
+		t.Errorf("NonceSize: %d", s.NonceSize())
+	}
+	for _, want := range []string{
+		"a0a1a2a3 00000000 00000000 00000000",
+		"a0a1a2a3 00000000 00000000 00000001",
+		"a0a1a2a3 00000000 00000000 00000002",
+	} {
+		if nonce, err := s.Next(); err != nil || !bytes.Equal(nonce, decode(want)) {
+			t.Errorf("Next: expected: %s\ngot: %x (%v)", want, nonce, err)
+		}
+	}
+
+	// A message sealed under the next nonce opens with it
+	a, _ := NewAEADAES(make([]byte, 32), s.NonceSize())
+	ct, nonce, err := s.Seal(a, nil, []byte("message"), []byte("header"))
+	if err != nil || !bytes.Equal(nonce, decode("a0a1a2a3 00000000 00000000 00000003")) {
+		t.Fatalf("Seal: %x (%v)", nonce, err)
+	}
+	if out, err := a.Open(nil, nonce, ct, []byte("header")); err != nil || string(out) != "message" {
+		t.Errorf("Open: %q (%v)", out, err)
+	}
+	other, _ := NewAEADAES(make([]byte, 32), 12)
+	if _, _, err := s.Seal(other, nil, nil, nil); err != ErrNonceSequenceAEADSize {
+		t.Errorf("Seal: expected ErrNonceSequenceAEADSize, got %v", err)
+	}
+
+	for _, tt := range []struct {
+		prefix      []byte
+		counterSize int
+		start       []byte
+		err         error
+	}{
+		{nil, 4, nil, ErrCounterSize},
+		{nil, 16, nil, ErrCounterSize},
+		{nil, 8, make([]byte, 12), ErrCounterSize},
+		{make([]byte, MaxNonceSize-7), 8, nil, ErrNonceSize},
+	} {
+		if _, err := NewNonceSequence(tt.prefix, tt.counterSize, tt.start); err != tt.err {
+			t.Errorf("NewNonceSequence: %d byte prefix, %d byte counter: expected %v, got %v", len(tt.prefix), tt.counterSize, tt.err, err)
+		}
+	}
+	if s, err := NewNonceSequence(make([]byte, MaxNonceSize-8), 8, nil); err != nil || s.NonceSize() != MaxNonceSize {
+		t.Errorf("NewNonceSequence: longest nonces: %v", err)
+	}
+}
+
+func TestNonceSequenceOverflow(t *testing.T) {
+	for _, tt := range []struct {
+		start string
+		last  string
+	}{
+		{"ffffffff fffffffd", "ffffffff ffffffff"},
+		{"ffffffff ffffffff fffffffd", "ffffffff ffffffff ffffffff"},
+	} {
+		s, err := NewNonceSequence([]byte("salt"), len(decode(tt.start)), decode(tt.start))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var nonce []byte
+		for i := 0; i < 3; i++ {
+			if nonce, err = s.Next(); err != nil {
+				t.Fatalf("Next: %s: %d: %s", tt.start, i, err)
+			}
+		}
+		if want := append([]byte("salt"), decode(tt.last)...); !bytes.Equal(nonce, want) {
+			t.Errorf("Next: %s: expected the largest counter: %x\ngot: %x", tt.start, want, nonce)
+		}
+		// No wrap around to zero, however often asked
+		for i := 0; i < 2; i++ {
+			if nonce, err := s.Next(); err != ErrNonceSequenceExhausted || nonce != nil {
+				t.Errorf("Next: %s: expected ErrNonceSequenceExhausted, got %x (%v)", tt.start, nonce, err)
+			}
+		}
+		a, _ := NewAEADAES(make([]byte, 32), s.NonceSize())
+		if _, _, err := s.Seal(a, nil, nil, nil); err != ErrNonceSequenceExhausted {
+			t.Errorf("Seal: %s: expected ErrNonceSequenceExhausted, got %v", tt.start, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sync/atomic"
+)
+
+// SessionIDSize is the size of the session ID a Session is created with.
+const SessionIDSize = 8
+
+// SessionNonceSize is the size of the nonces of a Session: the session ID
+// followed by a 64-bit big endian counter.
+const SessionNonceSize = SessionIDSize + 8
+
+var (
+	ErrSessionIDSize    = errors.New("siv: session ID must be 8 bytes")
+	ErrSessionExhausted = errors.New("siv: session counter exhausted")
+)
+
+// Session seals messages under nonces it manages itself, for long-lived
+// connections. Each nonce is the session ID followed by a counter, which
+// starts at zero and is incremented for each message, so no nonce is used
+// twice within the session. Seal returns the nonce used, which must be sent
+// along with the ciphertext for the peer to Open it.
+//
+// A Session may be used by multiple goroutines at once. Sessions sharing a
+// key must have different session IDs, or their nonces will repeat; with
+// random IDs from NewSessionID, a repeat is likely only after some 2^32
+// sessions under one key. A repeated nonce only reveals whether the same
+// message was sealed again, as SIV is misuse-resistant.
+type Session struct {
+	counter uint64 // the next counter, accessed atomically
+	a       *aead
+	id      [SessionIDSize]byte
+}
+
+// NewSession returns a Session using AES-SIV with the given key, as for
+// NewAES, and 8-byte session ID.
+func NewSession(key, sessionID []byte) (*Session, error) {
+	if len(sessionID) != SessionIDSize {
+		return nil, ErrSessionIDSize
+	}
+	a, err := NewAEADAES(key, SessionNonceSize)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{a: a.(*aead)}
+	copy(s.id[:], sessionID)
+	return s, nil
+}
+
+// NewSessionID returns a random session ID read from crypto/rand.
+func NewSessionID() ([]byte, error) {
+	id := make([]byte, SessionIDSize)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// Overhead returns the difference between plaintext and ciphertext lengths.
+// The nonce isn't part of the ciphertext.
+func (s *Session) Overhead() int { return s.a.Overhead() }
+
+// Seal encrypts and authenticates plaintext and data under the next nonce
+// of the session, appends the result to dst and returns it along with the
+// nonce. Once 2^64-1 messages have been sealed, the counter would wrap, and
+// Seal returns ErrSessionExhausted instead.
+func (s *Session) Seal(dst, plaintext, data []byte) (ciphertext, nonce []byte, err error) {
+	if err := checkSealSize(len(dst), s.Overhead(), len(plaintext)); err != nil {
+		return nil, nil, err
+	}
+	var n uint64
+	for {
+		n = atomic.LoadUint64(&s.counter)
+		if n == math.MaxUint64 {
+			return nil, nil, ErrSessionExhausted
+		}
+		if atomic.CompareAndSwapUint64(&s.counter, n, n+1) {
+			break
+		}
+	}
+	nonce = make([]byte, SessionNonceSize)
+	copy(nonce, s.id[:])
+	binary.BigEndian.PutUint64(nonce[SessionIDSize:], n)
+	return s.a.Seal(dst, nonce, plaintext, data), nonce, nil
+}
+
+// Open decrypts and authenticates ciphertext sealed by a Session with the
+// same key and session ID under the given nonce. It returns ErrNonceLength
+// for a nonce of the wrong size, and ErrNotAuthentic for one from another
+// session.
+func (s *Session) Open(dst, nonce, ciphertext, data []byte) ([]byte, error) {
+	if len(nonce) != SessionNonceSize {
+		return nil, ErrNonceLength
+	}
+	if subtle.ConstantTimeCompare(nonce[:SessionIDSize], s.id[:]) != 1 {
+		return nil, ErrNotAuthentic
+	}
+	return s.a.Open(dst, nonce, ciphertext, data)
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	key, id := countingKey(32), decode("01020304 05060708")
+	s, err := NewSession(key, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, _ := NewSession(key, id)
+	a, _ := NewAEADAES(key, SessionNonceSize)
+	for i := 0; i < 3; i++ {
+		ct, nonce, err := s.Seal([]byte("prefix"), []byte("message"), []byte("header"))
+		if err != nil {
+			t.Fatalf("Seal: %s", err)
+		}
+		want := append(append([]byte(nil), id...), 0, 0, 0, 0, 0, 0, 0, byte(i))
+		if !bytes.Equal(nonce, want) {
+			t.Errorf("Seal: %d: expected nonce: %x\ngot: %x", i, want, nonce)
+		}
+		if w := a.Seal([]byte("prefix"), nonce, []byte("message"), []byte("header")); !bytes.Equal(ct, w) {
+			t.Errorf("Seal: %d: expected: %x\ngot: %x", i, w, ct)
+		}
+		if out, err := peer.Open(nil, nonce, ct[6:], []byte("header")); err != nil || string(out) != "message" {
+			t.Errorf("Open: %d: %q (%v)", i, out, err)
+		}
+	}
+
+	ct, nonce, _ := s.Seal(nil, []byte("message"), nil)
+	other := append([]byte(nil), nonce...)
+	other[0] ^= 1
+	if _, err := peer.Open(nil, other, ct, nil); err != ErrNotAuthentic {
+		t.Errorf("Open: another session's nonce: expected ErrNotAuthentic, got %v", err)
+	}
+	if _, err := peer.Open(nil, nonce[:SessionNonceSize-1], ct, nil); err != ErrNonceLength {
+		t.Errorf("Open: short nonce: expected ErrNonceLength, got %v", err)
+	}
+
+	for _, n := range []int{0, 7, 9, 16} {
+		if _, err := NewSession(key, make([]byte, n)); err != ErrSessionIDSize {
+			t.Errorf("NewSession: %d-byte ID: expected ErrSessionIDSize, got %v", n, err)
+		}
+	}
+	if _, err := NewSession(key[:16], id); !errors.Is(err, ErrKeySize) {
+		t.Errorf("NewSession: expected ErrKeySize, got %v", err)
+	}
+	if a, _ := NewSessionID(); len(a) != SessionIDSize {
+		t.Errorf("NewSessionID: %x", a)
+	} else if b, _ := NewSessionID(); bytes.Equal(a, b) {
+		t.Errorf("NewSessionID: repeated %x", a)
+	}
+}
+
+func TestSessionExhausted(t *testing.T) {
+	s, _ := NewSession(make([]byte, 32), make([]byte, SessionIDSize))
+	s.counter = math.MaxUint64 - 1
+	_, nonce, err := s.Seal(nil, nil, nil)
+	if err != nil || !bytes.Equal(nonce[SessionIDSize:], decode("ffffffff fffffffe")) {
+		t.Fatalf("Seal: last counter: %x (%v)", nonce, err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := s.Seal(nil, nil, nil); err != ErrSessionExhausted {
+			t.Errorf("Seal: expected ErrSessionExhausted, got %v", err)
+		}
+	}
+}
+
+func TestSessionConcurrentSeal(t *testing.T) {
+	const goroutines, messages = 16, 200
+	s, _ := NewSession(make([]byte, 32), make([]byte, SessionIDSize))
+	nonces := make([][][]byte, goroutines)
+	var wg sync.WaitGroup
+	for g := range nonces {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < messages; i++ {
+				_, nonce, err := s.Seal(nil, []byte("message"), nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				nonces[g] = append(nonces[g], nonce)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for _, ns := range nonces {
+		for _, n := range ns {
+			if seen[string(n)] {
+				t.Fatalf("Seal: nonce %x used twice", n)
+			}
+			seen[string(n)] = true
+		}
+	}
+	if len(seen) != goroutines*messages || s.counter != goroutines*messages {
+		t.Errorf("Seal: expected %d nonces, got %d (counter %d)", goroutines*messages, len(seen), s.counter)
+	}
+}
@@ -6,146 +6,781 @@
 package miscreant
 
 import (
-	"github.com/miscreant/miscreant/go/cmac"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/subtle"
+	"encoding/binary"
 	"errors"
 	"hash"
+	"strconv"
+	"sync"
+	"unsafe"
+
+	"github.com/miscreant/miscreant/go/cmac"
+	"github.com/miscreant/miscreant/go/pmac"
 )
 
-const MaxAssociatedDataItems = 126 // maximum number of associated data items
+// MaxAssociatedDataItems is the maximum number of associated data items
+// which may be passed to Seal and Open. S2V accepts at most 127 input
+// vectors (RFC 5297 section 7), one of which is always the plaintext.
+const MaxAssociatedDataItems = 126
+
+// MaxPlaintextSize is the length in bytes of the longest plaintext Seal will
+// encrypt: 2^31 blocks. Clearing bits 31 and 63 of the synthetic IV (RFC 5297
+// section 2.5) guarantees that many blocks can be encrypted before the lowest
+// 32 bits of the CTR counter carry, which implementations using a 32-bit
+// counter rely on, so a longer ciphertext may not decrypt elsewhere.
+const MaxPlaintextSize = 1 << 35
+
+// TagSize is the length in bytes of the synthetic IV which Seal prepends to
+// each ciphertext, and so the value Overhead returns for every Cipher and
+// AEAD in this package.
+const TagSize = 16
 
 var (
 	ErrKeySize                    = errors.New("siv: bad key size")
 	ErrNotAuthentic               = errors.New("siv: authentication failed")
-	ErrTooManyAssociatedDataItems = errors.New("siv: too many associated data items")
+	ErrTooShort                   = errors.New("siv: ciphertext too short")
+	ErrTooManyAssociatedDataItems = errors.New("siv: too many associated data items (maximum is 126)")
+	ErrReset                      = errors.New("siv: cipher has been reset")
+	ErrBlockSize                  = errors.New("siv: block size must be 16 bytes")
+	ErrPlaintextTooLong           = errors.New("siv: plaintext too long")
+	ErrShortBuffer                = errors.New("siv: output buffer too small")
+	ErrTagSize                    = errors.New("siv: tag must be 16 bytes")
 )
 
+// ErrAuthFailed is returned by Open when the ciphertext or associated data
+// has been altered or the key is wrong, as opposed to the errors for malformed
+// arguments such as ErrTooShort. It is the same error as ErrNotAuthentic.
+var ErrAuthFailed = ErrNotAuthentic
+
+// KeySizeError is returned by the constructors when the key is not 32, 48,
+// or 64 bytes long. Its value is the length of the rejected key, and it
+// matches ErrKeySize with errors.Is.
+type KeySizeError int
+
+func (k KeySizeError) Error() string {
+	return "siv: invalid key length " + strconv.Itoa(int(k)) + ", expected 32, 48, or 64"
+}
+
+// Is reports whether target is ErrKeySize.
+func (k KeySizeError) Is(target error) bool {
+	return target == ErrKeySize
+}
+
+// wiper is implemented by MACs which can overwrite their key material.
+type wiper interface {
+	Wipe()
+}
+
+// cloner is implemented by MACs which can be copied without repeating their
+// key setup. Copies share the key material of the original.
+type cloner interface {
+	Clone() hash.Hash
+}
+
+// copier is implemented by MACs which can also be copied with key material of
+// their own, which Wipe on the copy leaves alone in the original.
+type copier interface {
+	Copy() hash.Hash
+}
+
+// Cipher is an instance of AES-SIV, configured with either AES-CMAC or
+// AES-PMAC as the message authentication code used by S2V.
+//
+// Unlike the cipher.AEAD wrapper returned by NewAEADAES, Cipher exposes the
+// full S2V vector of associated data items: each item passed to Seal and Open
+// is fed to S2V in the order given, which matches the other Miscreant
+// implementations so ciphertexts interoperate.
+//
+// The constructors expand the key schedules of both block ciphers and derive
+// the MAC subkeys once, and every call to Seal and Open reuses them.
+//
+// A Cipher is safe for concurrent use by multiple goroutines, provided each
+// call is given its own buffers and the block ciphers are themselves safe for
+// concurrent use, as those of crypto/aes are. The scratch space Seal and Open
+// need is kept in a pool, so that in the steady state they don't allocate
+// beyond growing dst, except for one small allocation for crypto/aes's CTR
+// mode when a Cipher from NewAES or NewPMACSIV encrypts a message of 256
+// bytes or more.
 type Cipher struct {
-	h          hash.Hash
-	b          cipher.Block
-	tmp1, tmp2 []byte
+	// h holds the key material of the MAC. It is never written to after
+	// newCipher; each call to Seal or Open computes S2V with a clone of it.
+	h    hash.Hash
+	b    cipher.Block
+	size int
+	alg  Algorithm
+
+	// aesCTR is set when b comes from crypto/aes, whose CTR mode encrypts
+	// several blocks at once and is much faster than xorKeyStream.
+	aesCTR bool
+
+	// newCTR, if set, makes the CTR mode streams in place of b, from the
+	// CTR half of the key, which is then retained in ctrKey
+	newCTR func(key, iv []byte) cipher.Stream
+	ctrKey []byte
+
+	// zeroMAC is the MAC of the all-zero block, which S2V starts from. It
+	// depends only on the key, so it is computed once by newCipher.
+	zeroMAC []byte
+
+	// states is a pool of *state
+	states sync.Pool
+}
+
+// state is the scratch space used by a single call to Seal or Open.
+type state struct {
+	h               hash.Hash
+	tmp1, tmp2, tag []byte
+
+	// ctr and ks are the CTR mode counter and keystream blocks
+	ctr, ks []byte
 }
 
-func newCipher(macBlock, ctrBlock cipher.Block) (c *Cipher, err error) {
-	c = new(Cipher)
-	c.h, err = cmac.New(macBlock)
+func newCipher(h hash.Hash, ctrBlock cipher.Block, alg Algorithm) *Cipher {
+	c := new(Cipher)
+	c.h = h
+	c.b = ctrBlock
+	c.size = c.b.BlockSize()
+	c.alg = alg
+	c.zeroMAC = zeroBlockMAC(h)
+	c.states.New = func() interface{} {
+		return newState(c.h.(cloner).Clone(), c.size)
+	}
+	return c
+}
+
+// zeroBlockMAC returns the MAC of the all-zero block, leaving h reset.
+func zeroBlockMAC(h hash.Hash) []byte {
+	h.Write(make([]byte, h.BlockSize()))
+	sum := h.Sum(nil)
+	h.Reset()
+	return sum
+}
+
+func newState(h hash.Hash, size int) *state {
+	return &state{
+		h:    h,
+		tmp1: make([]byte, size),
+		tmp2: make([]byte, size),
+		tag:  make([]byte, size),
+		ctr:  make([]byte, size),
+		ks:   make([]byte, size),
+	}
+}
+
+// newAESBlocks splits the given SIV key in half and returns AES block ciphers
+// for the MAC and CTR halves respectively.
+func newAESBlocks(key []byte) (macBlock, ctrBlock cipher.Block, err error) {
+	n := len(key)
+	if n != 32 && n != 48 && n != 64 {
+		return nil, nil, KeySizeError(n)
+	}
+	return newBlocks(aes.NewCipher, key)
+}
+
+// newBlocks splits the given SIV key in half and returns the block ciphers
+// made by newBlock for the MAC and CTR halves respectively.
+func newBlocks(newBlock func([]byte) (cipher.Block, error), key []byte) (macBlock, ctrBlock cipher.Block, err error) {
+	n := len(key)
+	if n == 0 || n%2 != 0 {
+		return nil, nil, ErrKeySize
+	}
+	macBlock, err = newBlock(key[:n/2])
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	c.b = ctrBlock
-	c.tmp1 = make([]byte, c.b.BlockSize())
-	c.tmp2 = make([]byte, c.b.BlockSize())
-	return c, nil
+	ctrBlock, err = newBlock(key[n/2:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return macBlock, ctrBlock, nil
 }
 
 // NewAES returns a new AES-SIV cipher with the given key, which must be
 // twice as long as an AES key, either 32, 48, or 64 bytes to select AES-128
 // (AES-SIV-CMAC-256), AES-192 (AES-SIV-CMAC-384), or AES-256 (AES-SIV-CMAC-512).
+// A key of any other length is rejected with a KeySizeError. The key isn't
+// retained, so the caller may wipe or reuse it once NewAES returns.
 func NewAES(key []byte) (c *Cipher, err error) {
-	n := len(key)
-	if n != 32 && n != 48 && n != 64 {
-		return nil, ErrKeySize
+	macBlock, ctrBlock, err := newAESBlocks(key)
+	if err != nil {
+		return nil, err
 	}
-	c1, err := aes.NewCipher(key[:n/2])
+	c, err = NewSIV(macBlock, ctrBlock)
 	if err != nil {
 		return nil, err
 	}
-	c2, err := aes.NewCipher(key[n/2:])
+	c.aesCTR = true
+	return c, nil
+}
+
+// NewAESWithCTR is like NewAES, but encrypts with the CTR mode streams
+// returned by newCTR rather than crypto/aes, for callers required to use a
+// particular AES-CTR implementation. S2V still uses crypto/aes.
+//
+// newCTR is called once for each non-empty message to be encrypted or
+// decrypted, with the CTR half of the key and the synthetic IV with bits 31
+// and 63 already cleared (RFC 5297 section 2.5) as the initial counter block,
+// and must return a stream which increments the whole block as a 128-bit big
+// endian counter, as cipher.NewCTR does. It must not modify or retain key or
+// iv. Unlike NewAES, the Cipher keeps a copy of the CTR half of the key to
+// pass to newCTR, which Reset overwrites with zeros.
+func NewAESWithCTR(key []byte, newCTR func(key, iv []byte) cipher.Stream) (c *Cipher, err error) {
+	c, err = NewAES(key)
+	if err != nil {
+		return nil, err
+	}
+	c.newCTR = newCTR
+	c.ctrKey = append([]byte(nil), key[len(key)/2:]...)
+	return c, nil
+}
+
+// NewSIV returns a new SIV cipher using CMAC with macBlock for S2V and CTR
+// mode with ctrBlock, so that block ciphers other than crypto/aes, such as
+// hardware-backed implementations, can be used. Both must have 16-byte blocks,
+// which S2V and the SIV counter both rely on; otherwise ErrBlockSize is
+// returned. macBlock and ctrBlock must use independent keys.
+//
+// The Cipher reports AlgorithmAESSIV whatever the block ciphers are, as the
+// construction is the same; the name is only accurate if they implement AES.
+func NewSIV(macBlock, ctrBlock cipher.Block) (c *Cipher, err error) {
+	if macBlock.BlockSize() != aes.BlockSize || ctrBlock.BlockSize() != aes.BlockSize {
+		return nil, ErrBlockSize
+	}
+	h, err := cmac.New(macBlock)
 	if err != nil {
 		return nil, err
 	}
-	return newCipher(c1, c2)
+	return newCipher(h, ctrBlock, AlgorithmAESSIV), nil
 }
 
-// Overhead returns the difference between plaintext and ciphertext lengths.
+// NewPMACSIV returns a new AES-PMAC-SIV cipher with the given key, which
+// must be twice as long as an AES key, either 32, 48, or 64 bytes to select
+// AES-128, AES-192, or AES-256. AES-PMAC-SIV replaces the CMAC used by S2V
+// with PMAC, which can process blocks in parallel.
+func NewPMACSIV(key []byte) (c *Cipher, err error) {
+	macBlock, ctrBlock, err := newAESBlocks(key)
+	if err != nil {
+		return nil, err
+	}
+	h, err := pmac.New(macBlock)
+	if err != nil {
+		return nil, err
+	}
+	c = newCipher(h, ctrBlock, AlgorithmAESPMACSIV)
+	c.aesCTR = true
+	return c, nil
+}
+
+// Algorithm returns the name of the algorithm c implements.
+func (c *Cipher) Algorithm() Algorithm {
+	return c.alg
+}
+
+// Overhead returns the difference between plaintext and ciphertext lengths,
+// which is always TagSize.
 func (c *Cipher) Overhead() int {
-	return c.h.Size()
+	return TagSize
+}
+
+// Clone returns a Cipher with the same key as c, without repeating the AES
+// key expansion. The clone shares c's block ciphers, whose key schedules are
+// never written to, but has its own copy of the derived MAC key material and
+// its own scratch space, so Reset on either Cipher leaves the other usable.
+// As a Cipher may already be used by multiple goroutines at once, Clone is
+// only needed where each must be Reset independently. It returns ErrReset if
+// c has been Reset.
+func (c *Cipher) Clone() (*Cipher, error) {
+	if c.b == nil {
+		return nil, ErrReset
+	}
+	clone := newCipher(c.h.(copier).Copy(), c.b, c.alg)
+	clone.aesCTR = c.aesCTR
+	clone.newCTR = c.newCTR
+	clone.ctrKey = append([]byte(nil), c.ctrKey...)
+	return clone, nil
+}
+
+// Reset overwrites the derived MAC key material with zeros and releases the
+// block ciphers. Subsequent calls to Seal and Open return ErrReset. It is safe
+// to call Reset more than once, but not concurrently with Seal or Open.
+//
+// The AES key schedules are owned by crypto/aes, which provides no means of
+// clearing them; Reset drops the references so they may be collected.
+func (c *Cipher) Reset() {
+	if w, ok := c.h.(wiper); ok {
+		w.Wipe()
+	}
+	zero(c.zeroMAC)
+	zero(c.ctrKey)
+	c.h = nil
+	c.b = nil
+	c.newCTR = nil
 }
 
 // Seal encrypts and authenticates plaintext, authenticates the given
 // associated data items, and appends the result to dst, returning the updated
-// slice.
+// slice. The contents of dst are preserved, and nothing is allocated if dst has
+// enough spare capacity for the result.
 //
-// The ciphertext and dst may alias exactly or not at all.
+// Each associated data item is a separate S2V input vector, so their order is
+// significant and items are never ambiguous with their concatenation.
+//
+// To encrypt in place, pass either plaintext[:0] as dst, or reserve
+// Overhead() bytes in front of the plaintext and pass a slice of them with
+// zero length as dst. Seal panics if dst and plaintext overlap in any other way.
 //
 // For nonce-based encryption, the nonce should be the last associated data item.
+// Seal returns ErrPlaintextTooLong if plaintext is longer than MaxPlaintextSize,
+// or if the result would be longer than the largest slice, before doing any work.
 func (c *Cipher) Seal(dst []byte, plaintext []byte, data ...[]byte) ([]byte, error) {
+	if c.b == nil {
+		return nil, ErrReset
+	}
 	if len(data) > MaxAssociatedDataItems {
 		return nil, ErrTooManyAssociatedDataItems
 	}
+	// Checked again by seal, but before the associated data is MACed here
+	if err := checkSealSize(len(dst), c.Overhead(), len(plaintext)); err != nil {
+		return nil, err
+	}
+
+	st := c.getState()
+	defer c.putState(st)
+	st.s2vStart(c.zeroMAC)
+	for _, v := range data {
+		st.h.Write(v)
+		st.s2vNext()
+	}
+	return c.seal(st, dst, plaintext)
+}
+
+// sealPair is Seal with exactly the two associated data items x and y,
+// which are folded into S2V directly rather than through a slice. The AEAD
+// wrapper takes this path whenever it is given both a nonce and associated
+// data, which is the common case for small records.
+func (c *Cipher) sealPair(dst, plaintext, x, y []byte) ([]byte, error) {
+	if c.b == nil {
+		return nil, ErrReset
+	}
+	if err := checkSealSize(len(dst), c.Overhead(), len(plaintext)); err != nil {
+		return nil, err
+	}
+	st := c.getState()
+	defer c.putState(st)
+	st.s2vPair(c.zeroMAC, x, y)
+	return c.seal(st, dst, plaintext)
+}
+
+// seal encrypts plaintext once st holds S2V of the associated data items.
+func (c *Cipher) seal(st *state, dst, plaintext []byte) ([]byte, error) {
+	if err := checkSealSize(len(dst), c.Overhead(), len(plaintext)); err != nil {
+		return nil, err
+	}
+	ret, out := sliceForAppend(dst, c.Overhead()+len(plaintext))
+	body := out[c.Overhead():]
+	if anyOverlap(out, plaintext) {
+		// Encrypting in place: the plaintext must start either where the
+		// output does, or right after the space reserved for the IV.
+		if !sameStart(out, plaintext) && !sameStart(body, plaintext) {
+			panic("siv: invalid buffer overlap")
+		}
+		copy(body, plaintext)
+		plaintext = body
+	}
+	copy(out, c.sealDetached(st, body, plaintext))
+	return ret, nil
+}
 
+// sealDetached computes the synthetic IV of plaintext, encrypts plaintext
+// into out, which must either not overlap it or alias it exactly, and returns
+// the IV, which is held in st.tag.
+func (c *Cipher) sealDetached(st *state, out, plaintext []byte) []byte {
 	// Authenticate
-	iv := c.s2v(data, plaintext)
-	ret, out := sliceForAppend(dst, len(iv)+len(plaintext))
-	copy(out, iv)
+	iv := st.s2vFinish(plaintext)
+	copy(st.tag, iv)
 
 	// Encrypt
 	zeroIVBits(iv)
-	ctr := cipher.NewCTR(c.b, iv)
-	ctr.XORKeyStream(out[len(iv):], plaintext)
+	c.xorKeyStream(st, out, plaintext, iv)
+	return st.tag
+}
 
-	return ret, nil
+// SealWithIV is like Seal, but also returns the synthetic IV, which is the
+// tag SealDetached would return. The IV isn't secret, as it is part of the
+// ciphertext, so it is safe to log, for instance as a fingerprint of each
+// message sealed; equal IVs under the same key mean the same plaintext and
+// associated data were sealed.
+func (c *Cipher) SealWithIV(dst []byte, plaintext []byte, data ...[]byte) (ciphertext []byte, iv [TagSize]byte, err error) {
+	ret, err := c.Seal(dst, plaintext, data...)
+	if err != nil {
+		return nil, iv, err
+	}
+	copy(iv[:], ret[len(dst):])
+	return ret, iv, nil
+}
+
+// SealDetached is like Seal, but returns the synthetic IV separately as tag
+// rather than in front of the ciphertext, which is exactly as long as
+// plaintext and is appended to dst. The tag followed by the ciphertext is
+// what Seal would produce. To encrypt in place, pass plaintext[:0] as dst.
+// SealDetached panics if dst and plaintext overlap in any other way.
+func (c *Cipher) SealDetached(dst []byte, plaintext []byte, data ...[]byte) (ciphertext []byte, tag [TagSize]byte, err error) {
+	if c.b == nil {
+		return nil, tag, ErrReset
+	}
+	if len(data) > MaxAssociatedDataItems {
+		return nil, tag, ErrTooManyAssociatedDataItems
+	}
+	if err := checkSealSize(len(dst), 0, len(plaintext)); err != nil {
+		return nil, tag, err
+	}
+	ret, out := sliceForAppend(dst, len(plaintext))
+	if anyOverlap(out, plaintext) && !sameStart(out, plaintext) {
+		panic("siv: invalid buffer overlap")
+	}
+
+	st := c.getState()
+	defer c.putState(st)
+	st.s2vStart(c.zeroMAC)
+	for _, v := range data {
+		st.h.Write(v)
+		st.s2vNext()
+	}
+	copy(tag[:], c.sealDetached(st, out, plaintext))
+	return ret, tag, nil
 }
 
 // Open decrypts ciphertext, authenticates the decrypted plaintext and the given
 // associated data items and, if successful, appends the resulting plaintext
-// to dst, returning the updated slice. The additional data items must match the
-// items passed to Seal.
+// to dst, returning the updated slice. As with Seal, the contents of dst are
+// preserved and its spare capacity is used if large enough. The additional
+// data items must match the items passed to Seal. A ciphertext of exactly
+// Overhead() bytes opens to an empty, non-nil plaintext.
 //
-// The ciphertext and dst may alias exactly or not at all.
+// Since SIV decrypts before it can authenticate, the unauthenticated
+// plaintext is overwritten with zeros before ErrAuthFailed is returned, so
+// any spare capacity of dst never holds it, and the returned slice is nil.
+// The contents of dst are left as they were. Ciphertexts shorter than
+// Overhead() are rejected with ErrTooShort, which is not ErrAuthFailed.
+//
+// The synthetic IV is compared with crypto/subtle.ConstantTimeCompare, so
+// the time Open takes doesn't reveal how much of a forged tag was correct.
+// The only check before then is that of the ciphertext's length against
+// Overhead(), which reveals nothing an attacker doesn't already know: any
+// ciphertext of at least that length is decrypted in full, and S2V computed
+// over all of the plaintext, before the tags are compared. The work done
+// thus depends on the lengths of the ciphertext and associated data, but not
+// on their contents or where a forgery differs from an authentic ciphertext.
+// Only the zeroing that follows a failure makes it take longer than success,
+// which the result reveals anyway.
+//
+// To decrypt in place, pass either ciphertext[:0] as dst, or
+// ciphertext[Overhead():Overhead()] to leave the plaintext where it is in the
+// ciphertext. Open panics if dst and ciphertext overlap in any other way.
 //
 // For nonce-based encryption, the nonce should be the last associated data item.
 func (c *Cipher) Open(dst []byte, ciphertext []byte, data ...[]byte) ([]byte, error) {
+	if c.b == nil {
+		return nil, ErrReset
+	}
 	if len(data) > MaxAssociatedDataItems {
 		return nil, ErrTooManyAssociatedDataItems
 	}
+	// Checked again by open, but before the associated data is MACed here
 	if len(ciphertext) < c.Overhead() {
-		return nil, ErrNotAuthentic
+		return nil, ErrTooShort
 	}
 
-	// Decrypt
-	iv := c.tmp1[:c.Overhead()]
-	copy(iv, ciphertext)
+	st := c.getState()
+	defer c.putState(st)
+	st.s2vStart(c.zeroMAC)
+	for _, v := range data {
+		st.h.Write(v)
+		st.s2vNext()
+	}
+	return c.open(st, dst, ciphertext)
+}
+
+// SealInto is like Seal, but writes the ciphertext to the start of dst rather
+// than appending to it, and returns its length, so a preallocated buffer is
+// used as is. It returns ErrShortBuffer, leaving dst untouched, if dst is
+// shorter than len(plaintext)+Overhead().
+//
+// To encrypt in place, pass plaintext, with Overhead() spare bytes after it,
+// as dst, or slice dst from Overhead() bytes before plaintext.
+func (c *Cipher) SealInto(dst, plaintext []byte, data ...[]byte) (n int, err error) {
+	if err := checkSealSize(0, c.Overhead(), len(plaintext)); err != nil {
+		return 0, err
+	}
+	if len(dst) < len(plaintext)+c.Overhead() {
+		return 0, ErrShortBuffer
+	}
+	out, err := c.Seal(dst[:0], plaintext, data...)
+	return len(out), err
+}
+
+// OpenInto is like Open, but writes the plaintext to the start of dst rather
+// than appending to it, and returns its length. It returns ErrShortBuffer,
+// leaving dst untouched, if dst is shorter than len(ciphertext)-Overhead().
+// If authentication fails, the part of dst the plaintext would occupy is zeroed.
+//
+// To decrypt in place, pass either ciphertext or ciphertext[Overhead():] as
+// dst.
+func (c *Cipher) OpenInto(dst, ciphertext []byte, data ...[]byte) (n int, err error) {
+	if len(ciphertext) < c.Overhead() {
+		return 0, ErrTooShort
+	}
+	if len(dst) < len(ciphertext)-c.Overhead() {
+		return 0, ErrShortBuffer
+	}
+	out, err := c.Open(dst[:0], ciphertext, data...)
+	return len(out), err
+}
+
+// openPair is Open with exactly the two associated data items x and y, as
+// for sealPair.
+func (c *Cipher) openPair(dst, ciphertext, x, y []byte) ([]byte, error) {
+	if c.b == nil {
+		return nil, ErrReset
+	}
+	if len(ciphertext) < c.Overhead() {
+		return nil, ErrTooShort
+	}
+	st := c.getState()
+	defer c.putState(st)
+	st.s2vPair(c.zeroMAC, x, y)
+	return c.open(st, dst, ciphertext)
+}
+
+// open decrypts ciphertext once st holds S2V of the associated data items.
+func (c *Cipher) open(st *state, dst, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < c.Overhead() {
+		return nil, ErrTooShort
+	}
+	tag, body := ciphertext[:c.Overhead()], ciphertext[c.Overhead():]
+	ret, out := sliceForAppend(dst, len(body))
+	if anyOverlap(out, ciphertext) {
+		switch {
+		case sameStart(out, body):
+		case sameStart(out, ciphertext):
+			// Move the ciphertext over the IV, having kept a copy of it,
+			// then decrypt in place
+			copy(st.tag, tag)
+			tag = st.tag
+			copy(out, body)
+			body = out
+		default:
+			panic("siv: invalid buffer overlap")
+		}
+	}
+	if err := c.openDetached(st, out, body, tag); err != nil {
+		return nil, err
+	}
+	if ret == nil {
+		ret = []byte{}
+	}
+	return ret, nil
+}
+
+// openDetached decrypts ciphertext into out, which must either not overlap
+// it or alias it exactly, and authenticates the result against tag. If that
+// fails, out is zeroed.
+func (c *Cipher) openDetached(st *state, out, ciphertext, tag []byte) error {
+	// Decrypt, keeping the tag in case out overlaps it
+	copy(st.tag, tag)
+	iv := st.tmp1
+	copy(iv, st.tag)
 	zeroIVBits(iv)
-	ctr := cipher.NewCTR(c.b, iv)
-	ret, out := sliceForAppend(dst, len(ciphertext)-len(iv))
-	ctr.XORKeyStream(out, ciphertext[len(iv):])
+	c.xorKeyStream(st, out, ciphertext, iv)
 
 	// Authenticate
-	expected := c.s2v(data, out)
-	if subtle.ConstantTimeCompare(ciphertext[:len(iv)], expected) != 1 {
+	expected := st.s2vFinish(out)
+	if subtle.ConstantTimeCompare(st.tag, expected) != 1 {
 		zero(out)
-		return nil, ErrNotAuthentic
+		return ErrNotAuthentic
+	}
+	return nil
+}
+
+// OpenDetached is like Open, for a ciphertext and synthetic IV produced by
+// SealDetached. The plaintext, which is as long as ciphertext, is appended to
+// dst. It returns ErrTagSize unless tag is TagSize bytes long. To decrypt in
+// place, pass ciphertext[:0] as dst. OpenDetached panics if dst and
+// ciphertext overlap in any other way.
+func (c *Cipher) OpenDetached(dst, ciphertext, tag []byte, data ...[]byte) ([]byte, error) {
+	if c.b == nil {
+		return nil, ErrReset
+	}
+	if len(data) > MaxAssociatedDataItems {
+		return nil, ErrTooManyAssociatedDataItems
+	}
+	if len(tag) != TagSize {
+		return nil, ErrTagSize
+	}
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	if anyOverlap(out, ciphertext) && !sameStart(out, ciphertext) {
+		panic("siv: invalid buffer overlap")
+	}
+
+	st := c.getState()
+	defer c.putState(st)
+	st.s2vStart(c.zeroMAC)
+	for _, v := range data {
+		st.h.Write(v)
+		st.s2vNext()
+	}
+	if err := c.openDetached(st, out, ciphertext, tag); err != nil {
+		return nil, err
+	}
+	if ret == nil {
+		ret = []byte{}
 	}
 	return ret, nil
 }
 
-func (c *Cipher) s2v(s [][]byte, sn []byte) []byte {
-	h := c.h
-	h.Reset()
+// Verify reports whether ciphertext and the given associated data items are
+// authentic, returning nil if Open would succeed and the error it would return
+// otherwise, without returning the plaintext or allocating.
+//
+// SIV authenticates the plaintext, so Verify still decrypts: it is no cheaper
+// than Open, and somewhat slower, but the plaintext is only ever held a block
+// at a time in scratch space which is zeroed before Verify returns.
+func (c *Cipher) Verify(ciphertext []byte, data ...[]byte) error {
+	if c.b == nil {
+		return ErrReset
+	}
+	if len(data) > MaxAssociatedDataItems {
+		return ErrTooManyAssociatedDataItems
+	}
+	if len(ciphertext) < c.Overhead() {
+		return ErrTooShort
+	}
 
-	tmp, d := c.tmp1, c.tmp2
-	zero(tmp)
+	st := c.getState()
+	defer c.putState(st)
+	st.s2vStart(c.zeroMAC)
+	for _, v := range data {
+		st.h.Write(v)
+		st.s2vNext()
+	}
+	if subtle.ConstantTimeCompare(ciphertext[:len(st.tag)], st.verify(c, ciphertext)) != 1 {
+		return ErrNotAuthentic
+	}
+	return nil
+}
+
+// verify decrypts the body of ciphertext a block at a time, finishing S2V
+// over the plaintext as s2vFinish would, and returns the result, which is
+// held in st.tmp1.
+func (st *state) verify(c *Cipher, ciphertext []byte) []byte {
+	h, ctr, ks := st.h, st.ctr, st.ks
+	final, d := st.tmp1, st.tmp2
+	copy(ctr, ciphertext)
+	zeroIVBits(ctr)
+	body := ciphertext[len(ctr):]
+	var stream cipher.Stream
+	if c.newCTR != nil && len(body) > 0 {
+		stream = c.newCTR(c.ctrKey, ctr)
+	}
+
+	// All but the last block's worth of plaintext is written to the MAC, and
+	// the rest is collected in final
+	split := len(body) - len(final)
+	if split < 0 {
+		split = 0
+	}
+	zero(final)
+	for off := 0; off < len(body); off += len(ks) {
+		p := ks
+		if n := len(body) - off; n < len(p) {
+			p = p[:n]
+		}
+		if stream != nil {
+			stream.XORKeyStream(p, body[off:off+len(p)])
+		} else {
+			c.b.Encrypt(ks, ctr)
+			incCounter(ctr)
+			xor(p, body[off:off+len(p)])
+		}
+		pos := off
+		if pos < split {
+			n := split - pos
+			if n > len(p) {
+				n = len(p)
+			}
+			h.Write(p[:n])
+			p, pos = p[n:], pos+n
+		}
+		if len(p) > 0 {
+			copy(final[pos-split:], p)
+		}
+	}
 
+	if len(body) < len(final) {
+		final[len(body)] = 0x80
+		dbl(d)
+	}
+	xor(final, d)
+	h.Write(final)
+	return h.Sum(final[:0])
+}
+
+// getState returns scratch space for a call to Seal or Open from the pool.
+func (c *Cipher) getState() *state {
+	return c.states.Get().(*state)
+}
+
+// putState zeroes st, which may hold key dependent intermediate values, and
+// returns it to the pool.
+func (c *Cipher) putState(st *state) {
+	st.h.Reset()
+	zero(st.tmp1)
+	zero(st.tmp2)
+	zero(st.tag)
+	zero(st.ctr)
+	zero(st.ks)
+	c.states.Put(st)
+}
+
+// s2vStart begins computing S2V from zeroMAC, the MAC of the all-zero block.
+// Each associated data item is then written to st.h and followed by a call to
+// s2vNext, and s2vFinish returns the result.
+func (st *state) s2vStart(zeroMAC []byte) {
 	// NOTE(dchest): The standalone S2V returns CMAC(1) if the number of
 	// passed vectors is zero, however in SIV contruction this case is
 	// never triggered, since we always pass plaintext as the last vector
 	// (even if it's zero-length), so we omit this case.
 
-	h.Write(tmp)
-	d = h.Sum(d[:0])
+	st.h.Reset()
+	copy(st.tmp2, zeroMAC)
+}
+
+// s2vNext folds the MAC of the item written to st.h into S2V.
+func (st *state) s2vNext() {
+	h := st.h
+	tmp, d := st.tmp1, st.tmp2
+	h.Sum(tmp[:0])
 	h.Reset()
+	dbl(d)
+	xor(d, tmp)
+}
 
-	for _, v := range s {
-		h.Write(v)
-		tmp = h.Sum(tmp[:0])
-		h.Reset()
-		dbl(d)
-		xor(d, tmp)
-	}
+// s2vPair is s2vStart followed by the two items x and y.
+func (st *state) s2vPair(zeroMAC, x, y []byte) {
+	st.s2vStart(zeroMAC)
+	st.h.Write(x)
+	st.s2vNext()
+	st.h.Write(y)
+	st.s2vNext()
+}
 
+// s2vFinish folds in the final vector sn and returns S2V, which is held in
+// st.tmp1.
+func (st *state) s2vFinish(sn []byte) []byte {
+	h := st.h
+	tmp, d := st.tmp1, st.tmp2
 	zero(tmp)
 
 	if len(sn) >= h.BlockSize() {
@@ -162,17 +797,117 @@ func (c *Cipher) s2v(s [][]byte, sn []byte) []byte {
 	return h.Sum(tmp[:0])
 }
 
+// aesCTRSize is the shortest message encrypted with crypto/aes's CTR mode
+// rather than xorKeyStream. The stream it returns is allocated, which costs
+// more than it saves for shorter messages.
+const aesCTRSize = 256
+
+// xorKeyStream XORs src with the CTR keystream starting at the counter block
+// iv into dst, using crypto/aes's CTR mode for long enough messages when c
+// uses crypto/aes. Both increment the whole block as a 128-bit big endian
+// counter, so they give the same result.
+func (c *Cipher) xorKeyStream(st *state, dst, src, iv []byte) {
+	if c.newCTR != nil {
+		if len(src) > 0 {
+			c.newCTR(c.ctrKey, iv).XORKeyStream(dst, src)
+		}
+		return
+	}
+	if c.aesCTR && len(src) >= aesCTRSize {
+		cipher.NewCTR(c.b, iv).XORKeyStream(dst, src)
+		return
+	}
+	st.xorKeyStream(c.b, dst, src, iv)
+}
+
+// xorKeyStream XORs src with the AES-CTR keystream starting at the counter
+// block iv into dst, which must either not overlap src or alias it exactly.
+func (st *state) xorKeyStream(b cipher.Block, dst, src, iv []byte) {
+	ctr, ks := st.ctr, st.ks
+	copy(ctr, iv)
+	for len(src) > 0 {
+		b.Encrypt(ks, ctr)
+		n := len(ks)
+		if len(src) < n {
+			n = len(src)
+		}
+		if n == len(ks) && len(ks) == 16 {
+			// Whole blocks a word at a time, as for xor
+			binary.LittleEndian.PutUint64(dst, binary.LittleEndian.Uint64(src)^binary.LittleEndian.Uint64(ks))
+			binary.LittleEndian.PutUint64(dst[8:], binary.LittleEndian.Uint64(src[8:])^binary.LittleEndian.Uint64(ks[8:]))
+		} else {
+			for i := 0; i < n; i++ {
+				dst[i] = src[i] ^ ks[i]
+			}
+		}
+		dst, src = dst[n:], src[n:]
+		incCounter(ctr)
+	}
+}
+
+// maxInt is the largest value of an int, which bounds the length of a slice.
+const maxInt = int(^uint(0) >> 1)
+
+// checkSealSize returns ErrPlaintextTooLong if a plaintext of n bytes exceeds
+// MaxPlaintextSize, or if appending it and overhead more bytes to dstLen bytes
+// would overflow int. The limit is compared in 64 bits, as it doesn't fit in a
+// 32-bit int, where the sum can also overflow well within the limit; checking
+// it here means a length that wrapped around never reaches sliceForAppend.
+func checkSealSize(dstLen, overhead, n int) error {
+	if uint64(n) > MaxPlaintextSize || n > maxInt-overhead-dstLen {
+		return ErrPlaintextTooLong
+	}
+	return nil
+}
+
+// incCounter increments the big endian counter block x.
+func incCounter(x []byte) {
+	for i := len(x) - 1; i >= 0; i-- {
+		x[i]++
+		if x[i] != 0 {
+			break
+		}
+	}
+}
+
+// Double returns block multiplied by the generator of GF(2^128), the dbl
+// operation of RFC 5297 section 2.3 which S2V, CMAC and PMAC all use to
+// derive values from one another: block is shifted left by one bit, and if
+// the bit shifted out was set the result is xored with 0x87 in its last byte.
+func Double(block [16]byte) [16]byte {
+	dbl(block[:])
+	return block
+}
+
+// dbl multiplies x by the generator of GF(2^128) in place. It doesn't branch
+// on x: the reduction by 0x87 is applied through a mask made from the bit
+// shifted out at the top.
 func dbl(x []byte) {
+	if len(x) == 16 {
+		// A word at a time, as the compiler turns these into single loads
+		// and stores
+		hi, lo := binary.BigEndian.Uint64(x), binary.BigEndian.Uint64(x[8:])
+		b := hi >> 63
+		binary.BigEndian.PutUint64(x, hi<<1|lo>>63)
+		binary.BigEndian.PutUint64(x[8:], lo<<1^0x87&-b)
+		return
+	}
 	var b byte
 	for i := len(x) - 1; i >= 0; i-- {
 		bb := x[i] >> 7
 		x[i] = x[i]<<1 | b
 		b = bb
 	}
-	x[len(x)-1] ^= byte(subtle.ConstantTimeSelect(int(b), 0x87, 0))
+	x[len(x)-1] ^= 0x87 & -b
 }
 
 func xor(a, b []byte) {
+	if len(b) == 16 && len(a) >= 16 {
+		// A block at a time, as for dbl
+		binary.LittleEndian.PutUint64(a, binary.LittleEndian.Uint64(a)^binary.LittleEndian.Uint64(b))
+		binary.LittleEndian.PutUint64(a[8:], binary.LittleEndian.Uint64(a[8:])^binary.LittleEndian.Uint64(b[8:]))
+		return
+	}
 	for i, v := range b {
 		a[i] ^= v
 	}
@@ -192,6 +927,18 @@ func zeroIVBits(iv []byte) {
 	iv[len(iv)-4] &= 0x7f
 }
 
+// anyOverlap reports whether x and y share memory at any index.
+func anyOverlap(x, y []byte) bool {
+	return len(x) > 0 && len(y) > 0 &&
+		uintptr(unsafe.Pointer(&x[0])) <= uintptr(unsafe.Pointer(&y[len(y)-1])) &&
+		uintptr(unsafe.Pointer(&y[0])) <= uintptr(unsafe.Pointer(&x[len(x)-1]))
+}
+
+// sameStart reports whether x and y begin at the same address.
+func sameStart(x, y []byte) bool {
+	return len(x) > 0 && len(y) > 0 && &x[0] == &y[0]
+}
+
 // sliceForAppend takes a slice and a requested number of bytes. It returns a
 // slice with the contents of the given slice followed by that many bytes and a
 // second slice that aliases into it and contains only the extra bytes. If the
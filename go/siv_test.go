@@ -9,17 +9,29 @@ package miscreant
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"unsafe"
 )
 
-var testVectors = []struct {
+type testVector struct {
 	key       string
 	adata     []string
 	plaintext string
 	output    string
-}{
+}
+
+var testVectors = []testVector{
 	// A.1.  Deterministic Authenticated Encryption Example
 	{
 		"fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0 f0f1f2f3 f4f5f6f7 f8f9fafb fcfdfeff",
@@ -86,6 +98,49 @@ var testVectors = []struct {
 	//TODO(dchest): find more test vectors.
 }
 
+var pmacTestVectors = []testVector{
+	// AES-PMAC-SIV counterparts of the RFC 5297 examples
+	{
+		"fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0 f0f1f2f3 f4f5f6f7 f8f9fafb fcfdfeff",
+		[]string{"10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627"},
+		"11223344 55667788 99aabbcc ddee",
+		"8c4b8142 16140fc9 b34a4171 6aa61633 ea66abe1 6b2f6e4b ceeda6e9 077f",
+	},
+	{
+		"7f7e7d7c 7b7a7978 77767574 73727170 40414243 44454647 48494a4b 4c4d4e4f",
+		[]string{
+			"00112233 44556677 8899aabb ccddeeff deaddada deaddada ffeeddcc bbaa9988 77665544 33221100",
+			"10203040 50607080 90a0",
+			"09f91102 9d74e35b d84156c5 635688c0",
+		},
+		"74686973 20697320 736f6d65 20706c61 696e7465 78742074 6f20656e 63727970 74207573 696e6720 5349562d 414553",
+		"acb9cbc9 5dbed8e7 66d25ad5 9deb65bc da7aff92 14153273 f88e89eb e580c77d efc15d28 448f420e 0a17d427 22e6d427 76849aa3 bec375c5 a05e54f5 19e9fd",
+	},
+	{
+		"fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0 f0f1f2f3 f4f5f6f7 f8f9fafb fcfdfeff",
+		[]string{},
+		"",
+		"19f25e5e a8a96ef2 7067d462 6fdd3677",
+	},
+	// 256 bit subkeys
+	{
+		"fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0 6f6e6d6c 6b6a6968 67666564 63626160 f0f1f2f3 f4f5f6f7 f8f9fafb fcfdfeff 00010203 04050607 08090a0b 0c0d0e0f",
+		[]string{"10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627"},
+		"11223344 55667788 99aabbcc ddee",
+		"77097bb3 e160988e 8b262c19 42f98388 5f826d0d 7e047e97 5e2fc4ea 6776",
+	},
+	{
+		"7f7e7d7c 7b7a7978 77767574 73727170 6f6e6d6c 6b6a6968 67666564 63626160 40414243 44454647 48494a4b 4c4d4e4f 50515253 54555657 58595a5b 5b5d5e5f",
+		[]string{
+			"00112233 44556677 8899aabb ccddeeff deaddada deaddada ffeeddcc bbaa9988 77665544 33221100",
+			"10203040 50607080 90a0",
+			"09f91102 9d74e35b d84156c5 635688c0",
+		},
+		"74686973 20697320 736f6d65 20706c61 696e7465 78742074 6f20656e 63727970 74207573 696e6720 5349562d 414553",
+		"cd07d56d ca0fe156 9b8ecb3c f2346604 290726e1 2529fc59 48546b6b e39fed9c d8652256 c594c8f5 6208c749 6789de8d fb4f1616 27c91482 f9ecf809 652a9e",
+	},
+}
+
 func decode(s string) []byte {
 	b, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
 	if err != nil {
@@ -102,30 +157,58 @@ func decodeAD(ad []string) [][]byte {
 	return b
 }
 
-func TestAES(t *testing.T) {
-	for i, v := range testVectors {
-		c, err := NewAES(decode(v.key))
+func testSIV(t *testing.T, newCipher func([]byte) (*Cipher, error), vectors []testVector) {
+	for i, v := range vectors {
+		key := decode(v.key)
+		c, err := newCipher(key)
 		if err != nil {
-			t.Errorf("NewAES: %d: %s", i, err)
+			t.Fatalf("NewCipher: %d: %s", i, err)
 		}
 		gpt, gct, ad := decode(v.plaintext), decode(v.output), decodeAD(v.adata)
 		ct, err := c.Seal(nil, gpt, ad...)
 		if err != nil {
-			t.Errorf("Seal: %d: %s", i, err)
+			t.Errorf("Seal: %d: %d byte key: %s", i, len(key), err)
 		}
 		if !bytes.Equal(gct, ct) {
-			t.Errorf("Seal: %d: expected: %x\ngot: %x", i, gct, ct)
+			t.Errorf("Seal: %d: %d byte key: expected: %x\ngot: %x", i, len(key), gct, ct)
 		}
 		pt, err := c.Open(nil, ct, ad...)
 		if err != nil {
-			t.Errorf("Open: %d: %s", i, err)
+			t.Errorf("Open: %d: %d byte key: %s", i, len(key), err)
 		}
 		if !bytes.Equal(gpt, pt) {
-			t.Errorf("Open: %d: expected: %x\ngot: %x", i, gpt, pt)
+			t.Errorf("Open: %d: %d byte key: expected: %x\ngot: %x", i, len(key), gpt, pt)
 		}
 	}
 }
 
+// testKeySizes checks that vectors cover each of the given key sizes, so that
+// the key splitting is exercised for every AES variant.
+func testKeySizes(t *testing.T, vectors []testVector, sizes ...int) {
+	for _, n := range sizes {
+		found := false
+		for _, v := range vectors {
+			if len(decode(v.key)) == n {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("no test vectors with a %d byte key", n)
+		}
+	}
+}
+
+func TestAES(t *testing.T) {
+	testKeySizes(t, testVectors, 32, 48, 64)
+	testSIV(t, NewAES, testVectors)
+}
+
+func TestPMACSIV(t *testing.T) {
+	testKeySizes(t, pmacTestVectors, 32, 64)
+	testSIV(t, NewPMACSIV, pmacTestVectors)
+}
+
 func TestAppend(t *testing.T) {
 	v := testVectors[0]
 	m := decode(v.plaintext)
@@ -172,12 +255,311 @@ func TestAppend(t *testing.T) {
 	}
 }
 
+func TestVerify(t *testing.T) {
+	for _, newCipher := range []func([]byte) (*Cipher, error){NewAES, NewPMACSIV} {
+		c, err := newCipher(make([]byte, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ad := [][]byte{[]byte("header"), []byte("nonce")}
+		for n := 0; n <= 50; n++ {
+			m := make([]byte, n)
+			for i := range m {
+				m[i] = byte(i)
+			}
+			ct, _ := c.Seal(nil, m, ad...)
+			check := func(what string, ct []byte, ad ...[]byte) {
+				_, openErr := c.Open(nil, ct, ad...)
+				if err := c.Verify(ct, ad...); err != openErr {
+					t.Errorf("Verify: %d bytes: %s: Open returned %v, Verify %v", n, what, openErr, err)
+				}
+			}
+			check("valid", ct, ad...)
+			check("wrong items", ct, ad[0])
+			check("short", ct[:len(ct)-1], ad...)
+			for i := range ct {
+				x := append([]byte(nil), ct...)
+				x[i] ^= 1
+				check("byte "+strconv.Itoa(i)+" flipped", x, ad...)
+			}
+		}
+	}
+
+	v := testVectors[1]
+	c, _ := NewAES(decode(v.key))
+	if err := c.Verify(decode(v.output), decodeAD(v.adata)...); err != nil {
+		t.Errorf("Verify: RFC 5297 A.2: %s", err)
+	}
+	if err := c.Verify(make([]byte, 15)); err != ErrTooShort {
+		t.Errorf("Verify: expected ErrTooShort, got %v", err)
+	}
+	if raceEnabled {
+		return
+	}
+	ct, ad := decode(v.output), decodeAD(v.adata)
+	if allocs := testing.AllocsPerRun(100, func() { c.Verify(ct, ad...) }); allocs != 0 {
+		t.Errorf("Verify: expected no allocations, got %v", allocs)
+	}
+}
+
+func TestDouble(t *testing.T) {
+	for _, tt := range []struct{ in, out string }{
+		// High bit clear: a plain shift
+		{"00000000000000000000000000000000", "00000000000000000000000000000000"},
+		{"40000000000000000000000000000001", "80000000000000000000000000000002"},
+		// High bit set: the shifted-out bit is reduced by 0x87
+		{"80000000000000000000000000000000", "00000000000000000000000000000087"},
+		{"c0000000000000000000000000000000", "80000000000000000000000000000087"},
+		// Carries across every byte boundary
+		{"7fffffffffffffffffffffffffffffff", "fffffffffffffffffffffffffffffffe"},
+		// RFC 4493 section 2.4: K1 = dbl(L), K2 = dbl(K1)
+		{"7df76b0c1ab899b33e42f047b91b546f", "fbeed618357133667c85e08f7236a8de"},
+		{"fbeed618357133667c85e08f7236a8de", "f7ddac306ae266ccf90bc11ee46d513b"},
+		// RFC 5297 A.1: dbl(CMAC(zero))
+		{"0e04dfafc1efbf040140582859bf073a", "1c09bf5f83df7e080280b050b37e0e74"},
+	} {
+		var in [16]byte
+		copy(in[:], decode(tt.in))
+		if got := Double(in); !bytes.Equal(got[:], decode(tt.out)) {
+			t.Errorf("Double(%s): expected: %s\ngot: %x", tt.in, tt.out, got)
+		}
+	}
+}
+
+func TestAESCTR(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	fast, _ := NewAES(key)
+	macBlock, ctrBlock, _ := newAESBlocks(key)
+	slow, _ := NewSIV(macBlock, ctrBlock)
+	if !fast.aesCTR || slow.aesCTR {
+		t.Fatalf("aesCTR: NewAES %v, NewSIV %v", fast.aesCTR, slow.aesCTR)
+	}
+	for _, n := range []int{aesCTRSize - 1, aesCTRSize, aesCTRSize + 1, 1000, 4096 + 15} {
+		m := make([]byte, n)
+		for i := range m {
+			m[i] = byte(i * 7)
+		}
+		want, _ := slow.Seal(nil, m, []byte("header"))
+		ct, _ := fast.Seal(nil, m, []byte("header"))
+		if !bytes.Equal(want, ct) {
+			t.Fatalf("Seal: %d bytes: crypto/aes CTR mode differs from xorKeyStream", n)
+		}
+		if pt, err := fast.Open(nil, ct, []byte("header")); err != nil || !bytes.Equal(pt, m) {
+			t.Fatalf("Open: %d bytes: %v", n, err)
+		}
+	}
+
+	// Both carry across the whole 128-bit counter
+	st := fast.getState()
+	defer fast.putState(st)
+	iv := decode("fffffffffffffffffffffffffffffffe")
+	src := make([]byte, 4*16)
+	want, got := make([]byte, len(src)), make([]byte, len(src))
+	st.xorKeyStream(ctrBlock, want, src, iv)
+	cipher.NewCTR(ctrBlock, iv).XORKeyStream(got, src)
+	if !bytes.Equal(want, got) {
+		t.Errorf("counter wrap: expected: %x\ngot: %x", want, got)
+	}
+}
+
+// recordingStream counts the bytes XORed by the stream it wraps.
+type recordingStream struct {
+	cipher.Stream
+	n *int
+}
+
+func (s recordingStream) XORKeyStream(dst, src []byte) {
+	*s.n += len(src)
+	s.Stream.XORKeyStream(dst, src)
+}
+
+func TestAESWithCTR(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	type call struct {
+		key, iv []byte
+		n       int
+	}
+	var calls []*call
+	c, err := NewAESWithCTR(key, func(k, iv []byte) cipher.Stream {
+		b, _ := aes.NewCipher(k)
+		x := &call{key: append([]byte(nil), k...), iv: append([]byte(nil), iv...)}
+		calls = append(calls, x)
+		return recordingStream{cipher.NewCTR(b, iv), &x.n}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, _ := NewAES(key)
+
+	for _, n := range []int{0, 1, 16, 17, aesCTRSize - 1, aesCTRSize, 1000} {
+		pt := make([]byte, n)
+		for i := range pt {
+			pt[i] = byte(i)
+		}
+		want, _ := ref.Seal(nil, pt, []byte("header"))
+		iv := append([]byte(nil), want[:16]...)
+		zeroIVBits(iv)
+
+		// Seal, Open and Verify each encrypt or decrypt the body once
+		calls = nil
+		ct, err := c.Seal(nil, pt, []byte("header"))
+		if err != nil || !bytes.Equal(ct, want) {
+			t.Fatalf("Seal: %d bytes: expected: %x\ngot: %x (%v)", n, want, ct, err)
+		}
+		if out, err := c.Open(nil, ct, []byte("header")); err != nil || !bytes.Equal(out, pt) {
+			t.Fatalf("Open: %d bytes: %v", n, err)
+		}
+		if err := c.Verify(ct, []byte("header")); err != nil {
+			t.Fatalf("Verify: %d bytes: %v", n, err)
+		}
+		if n == 0 {
+			if len(calls) != 0 {
+				t.Errorf("%d bytes: expected no streams for an empty message, got %d", n, len(calls))
+			}
+			continue
+		}
+		if len(calls) != 3 {
+			t.Fatalf("%d bytes: expected 3 streams, got %d", n, len(calls))
+		}
+		for _, x := range calls {
+			if !bytes.Equal(x.key, key[16:]) || !bytes.Equal(x.iv, iv) || x.n != n {
+				t.Errorf("%d bytes: expected key %x, IV %x and %d bytes, got %x, %x and %d", n, key[16:], iv, n, x.key, x.iv, x.n)
+			}
+		}
+	}
+
+	ctrKey := c.ctrKey
+	c.Reset()
+	if !bytes.Equal(ctrKey, make([]byte, len(ctrKey))) {
+		t.Errorf("Reset: CTR key not zeroed: %x", ctrKey)
+	}
+	if _, err := NewAESWithCTR(key[:16], nil); !errors.Is(err, ErrKeySize) {
+		t.Errorf("NewAESWithCTR: expected ErrKeySize, got %v", err)
+	}
+}
+
+func TestCounterMasking(t *testing.T) {
+	// Q = V bitand (1^64 || 0^1 || 1^31 || 0^1 || 1^31), RFC 5297 section 2.6
+	iv := bytes.Repeat([]byte{0xff}, 16)
+	zeroIVBits(iv)
+	if want := decode("ffffffffffffffff7fffffff7fffffff"); !bytes.Equal(iv, want) {
+		t.Fatalf("zeroIVBits: expected: %x\ngot: %x", want, iv)
+	}
+
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	macBlock, ctrBlock, _ := newAESBlocks(key)
+	fast, _ := NewAES(key)
+	slow, _ := NewSIV(macBlock, ctrBlock)
+
+	// keyStream computes n bytes of keystream from q, adding the block
+	// number to it as a 128-bit integer
+	keyStream := func(q []byte, n int) []byte {
+		mod := new(big.Int).Lsh(big.NewInt(1), 128)
+		out := make([]byte, (n+15)/16*16)
+		for i := 0; i < len(out); i += 16 {
+			ctr := new(big.Int).SetBytes(q)
+			ctr.Add(ctr, big.NewInt(int64(i/16))).Mod(ctr, mod)
+			b := ctr.Bytes()
+			block := make([]byte, 16)
+			copy(block[16-len(b):], b)
+			ctrBlock.Encrypt(out[i:], block)
+		}
+		return out[:n]
+	}
+
+	for _, c := range []*Cipher{fast, slow} {
+		for _, n := range []int{17, 5*16 + 3, aesCTRSize + 33} {
+			// Sealing zeros gives the keystream from the masked IV
+			ct, _ := c.Seal(nil, make([]byte, n), []byte("header"))
+			q := append([]byte(nil), ct[:16]...)
+			zeroIVBits(q)
+			if want := keyStream(q, n); !bytes.Equal(ct[16:], want) {
+				t.Errorf("Seal: %d bytes: expected keystream: %x\ngot: %x", n, want, ct[16:])
+			}
+
+			// Counters starting just below the cleared bits, which the
+			// blocks after the first increment into, and one ending in
+			// 32 set bits, which masking rules out, so the increment
+			// carries out of the lowest word
+			st := c.getState()
+			for _, q := range []string{
+				"000102030405060708090a0b7ffffffe",
+				"0001020304050607ffffffff7ffffffe",
+				"00010203040506077fffffffffffffff",
+			} {
+				q := decode(q)
+				got := make([]byte, n)
+				c.xorKeyStream(st, got, make([]byte, n), append([]byte(nil), q...))
+				if want := keyStream(q, n); !bytes.Equal(got, want) {
+					t.Errorf("xorKeyStream: %d bytes from %x: expected: %x\ngot: %x", n, q, want, got)
+				}
+			}
+			c.putState(st)
+		}
+	}
+}
+
+// raceEnabled is set when testing with the race detector.
+var raceEnabled = false
+
+func TestAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("skipping allocation test with the race detector")
+	}
+	for _, newCipher := range []func([]byte) (*Cipher, error){NewAES, NewPMACSIV} {
+		c, err := newCipher(make([]byte, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := make([]byte, 64)
+		for _, n := range []int{0, 64, aesCTRSize - 1, aesCTRSize, 1024} {
+			m := make([]byte, n)
+			ct, _ := c.Seal(nil, m, a)
+			out := make([]byte, 0, len(ct))
+
+			// Longer messages allocate the stream of crypto/aes's CTR mode
+			want := 0.0
+			if n >= aesCTRSize {
+				want = 1
+			}
+			if allocs := testing.AllocsPerRun(100, func() { c.Seal(out, m, a) }); allocs != want {
+				t.Errorf("Seal: %d bytes: expected %v allocations, got %v", n, want, allocs)
+			}
+			if allocs := testing.AllocsPerRun(100, func() { c.Open(out, ct, a) }); allocs != want {
+				t.Errorf("Open: %d bytes: expected %v allocations, got %v", n, want, allocs)
+			}
+		}
+	}
+}
+
+func BenchmarkSIVAES128_Seal_64(b *testing.B) {
+	a := make([]byte, 64)
+	m := make([]byte, 64)
+	c, _ := NewAES(make([]byte, 32))
+	out := make([]byte, 0, len(m)+c.Overhead())
+	b.SetBytes(int64(len(m)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Seal(out, m, a)
+	}
+}
+
 func BenchmarkSIVAES128_Seal_1K(b *testing.B) {
 	a := make([]byte, 64)
 	m := make([]byte, 1024)
 	c, _ := NewAES(make([]byte, 32))
 	out := make([]byte, 0, len(m)+c.Overhead())
 	b.SetBytes(int64(len(m)))
+	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
 		c.Seal(out, m, a)
 	}
@@ -194,6 +576,19 @@ func BenchmarkSIVAES128_Seal_8K(b *testing.B) {
 	}
 }
 
+func BenchmarkSIVAES128_Open_64(b *testing.B) {
+	a := make([]byte, 64)
+	m := make([]byte, 64)
+	c, _ := NewAES(make([]byte, 32))
+	x, _ := c.Seal(nil, m, a)
+	out := make([]byte, 0, len(m))
+	b.SetBytes(int64(len(m)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Open(out, x, a)
+	}
+}
+
 func BenchmarkSIVAES128_Open_1K(b *testing.B) {
 	a := make([]byte, 64)
 	m := make([]byte, 1024)
@@ -201,11 +596,144 @@ func BenchmarkSIVAES128_Open_1K(b *testing.B) {
 	x, _ := c.Seal(nil, m, a)
 	out := make([]byte, 0, len(m))
 	b.SetBytes(int64(len(m)))
+	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
 		c.Open(out, x, a)
 	}
 }
 
+func BenchmarkPMACSIVAES128_Seal_1K(b *testing.B) {
+	a := make([]byte, 64)
+	m := make([]byte, 1024)
+	c, _ := NewPMACSIV(make([]byte, 32))
+	out := make([]byte, 0, len(m)+c.Overhead())
+	b.SetBytes(int64(len(m)))
+	for i := 0; i < b.N; i++ {
+		c.Seal(out, m, a)
+	}
+}
+
+// BenchmarkOpenAccept and BenchmarkOpenReject are a rough check that Open
+// takes as long to reject a tag as to accept one: it always decrypts and
+// compares the whole tag, and only zeroes the plaintext in addition.
+func BenchmarkOpenAccept(b *testing.B) {
+	benchmarkOpenTag(b, 0)
+}
+
+func BenchmarkOpenReject(b *testing.B) {
+	benchmarkOpenTag(b, 0x80)
+}
+
+func benchmarkOpenTag(b *testing.B, flip byte) {
+	c, _ := NewAES(make([]byte, 32))
+	x, _ := c.Seal(nil, make([]byte, 64))
+	x[15] ^= flip
+	out := make([]byte, 0, len(x))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Open(out, x)
+	}
+}
+
+var benchmarkCiphers = []struct {
+	name string
+	new  func([]byte) (*Cipher, error)
+}{
+	{"AES-CMAC", NewAES},
+	{"AES-PMAC", NewPMACSIV},
+}
+
+var benchmarkSizes = []struct {
+	name string
+	n    int
+}{
+	{"16B", 16},
+	{"1K", 1 << 10},
+	{"64K", 64 << 10},
+	{"1M", 1 << 20},
+}
+
+func BenchmarkSeal(b *testing.B) {
+	for _, bc := range benchmarkCiphers {
+		c, _ := bc.new(make([]byte, 32))
+		for _, bs := range benchmarkSizes {
+			a, m := make([]byte, 64), make([]byte, bs.n)
+			out := make([]byte, 0, len(m)+c.Overhead())
+			b.Run(bc.name+"/"+bs.name, func(b *testing.B) {
+				b.SetBytes(int64(len(m)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					c.Seal(out, m, a)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkSealSetup compares sealing small messages with a long-lived Cipher
+// against constructing one for every message.
+func BenchmarkSealSetup(b *testing.B) {
+	key, a, m := make([]byte, 32), make([]byte, 64), make([]byte, 16)
+	out := make([]byte, 0, len(m)+16)
+	b.Run("Cached", func(b *testing.B) {
+		c, _ := NewAES(key)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c.Seal(out, m, a)
+		}
+	})
+	b.Run("PerCall", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c, _ := NewAES(key)
+			c.Seal(out, m, a)
+		}
+	})
+}
+
+// BenchmarkXORKeyStream compares the CTR mode of crypto/aes with encrypting
+// one block at a time, as is done for block ciphers from elsewhere.
+func BenchmarkXORKeyStream(b *testing.B) {
+	c, _ := NewAES(make([]byte, 32))
+	st := c.getState()
+	defer c.putState(st)
+	for _, bs := range benchmarkSizes[1:] {
+		src, dst := make([]byte, bs.n), make([]byte, bs.n)
+		b.Run("AES/"+bs.name, func(b *testing.B) {
+			b.SetBytes(int64(bs.n))
+			for i := 0; i < b.N; i++ {
+				c.xorKeyStream(st, dst, src, st.tmp1)
+			}
+		})
+		b.Run("Blockwise/"+bs.name, func(b *testing.B) {
+			b.SetBytes(int64(bs.n))
+			for i := 0; i < b.N; i++ {
+				st.xorKeyStream(c.b, dst, src, st.tmp1)
+			}
+		})
+	}
+}
+
+func BenchmarkOpen(b *testing.B) {
+	for _, bc := range benchmarkCiphers {
+		c, _ := bc.new(make([]byte, 32))
+		for _, bs := range benchmarkSizes {
+			a := make([]byte, 64)
+			x, _ := c.Seal(nil, make([]byte, bs.n), a)
+			out := make([]byte, 0, bs.n)
+			b.Run(bc.name+"/"+bs.name, func(b *testing.B) {
+				b.SetBytes(int64(bs.n))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := c.Open(out, x, a); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
 func BenchmarkSIVAES128_Open_8K(b *testing.B) {
 	a := make([]byte, 64)
 	m := make([]byte, 8192)
@@ -217,3 +745,967 @@ func BenchmarkSIVAES128_Open_8K(b *testing.B) {
 		c.Open(out, x, a)
 	}
 }
+
+func TestAssociatedDataOrder(t *testing.T) {
+	// A.2 of RFC 5297 binds three associated data items: AD1, AD2 and nonce
+	v := testVectors[1]
+	c, err := NewAES(decode(v.key))
+	if err != nil {
+		t.Fatalf("NewAES: %s", err)
+	}
+	pt, ad := decode(v.plaintext), decodeAD(v.adata)
+	ct := decode(v.output)
+
+	orderings := [][][]byte{
+		{ad[1], ad[0], ad[2]},
+		{ad[0], ad[2], ad[1]},
+		{ad[2], ad[1], ad[0]},
+		{ad[0], ad[2]},
+		{ad[1], ad[2]},
+		{append(append([]byte{}, ad[0]...), ad[1]...), ad[2]},
+	}
+	for i, o := range orderings {
+		if _, err := c.Open(nil, ct, o...); err != ErrNotAuthentic {
+			t.Errorf("Open: %d: expected ErrNotAuthentic, got %v", i, err)
+		}
+		x, err := c.Seal(nil, pt, o...)
+		if err != nil {
+			t.Fatalf("Seal: %d: %s", i, err)
+		}
+		if bytes.Equal(x, ct) {
+			t.Errorf("Seal: %d: reordered associated data produced the same ciphertext", i)
+		}
+		y, err := c.Open(nil, x, o...)
+		if err != nil {
+			t.Errorf("Open: %d: %s", i, err)
+		}
+		if !bytes.Equal(y, pt) {
+			t.Errorf("Open: %d: expected: %x\ngot: %x", i, pt, y)
+		}
+	}
+}
+
+func TestKeySize(t *testing.T) {
+	constructors := []struct {
+		name string
+		new  func([]byte) error
+	}{
+		{"NewAES", func(k []byte) error { _, err := NewAES(k); return err }},
+		{"NewPMACSIV", func(k []byte) error { _, err := NewPMACSIV(k); return err }},
+		{"NewAEADAES", func(k []byte) error { _, err := NewAEADAES(k, 16); return err }},
+		{"NewAEADAESPMACSIV", func(k []byte) error { _, err := NewAEADAESPMACSIV(k, 16); return err }},
+	}
+	for _, c := range constructors {
+		for _, n := range []int{0, 1, 15, 16, 17, 24, 31, 33, 47, 49, 63, 65, 96, 128} {
+			err := c.new(make([]byte, n))
+			if err != KeySizeError(n) {
+				t.Errorf("%s: %d byte key: expected KeySizeError(%d), got %v", c.name, n, n, err)
+			}
+		}
+		for _, n := range []int{32, 48, 64} {
+			if err := c.new(make([]byte, n)); err != nil {
+				t.Errorf("%s: %d byte key: %s", c.name, n, err)
+			}
+		}
+	}
+	if got, want := KeySizeError(31).Error(), "siv: invalid key length 31, expected 32, 48, or 64"; got != want {
+		t.Errorf("KeySizeError: expected %q, got %q", want, got)
+	}
+}
+
+// countingBlock is a cipher.Block which counts the blocks it encrypts.
+type countingBlock struct {
+	cipher.Block
+	n int
+}
+
+func (b *countingBlock) Encrypt(dst, src []byte) {
+	b.n++
+	b.Block.Encrypt(dst, src)
+}
+
+// loggingBlock is a cipher.Block which records its name in a shared log for
+// each block it encrypts.
+type loggingBlock struct {
+	cipher.Block
+	name string
+	log  *[]string
+}
+
+func (b *loggingBlock) Encrypt(dst, src []byte) {
+	*b.log = append(*b.log, b.name)
+	b.Block.Encrypt(dst, src)
+}
+
+func TestOpenDecryptsBeforeCompare(t *testing.T) {
+	var log []string
+	macBlock, _ := aes.NewCipher(make([]byte, 16))
+	ctrBlock, _ := aes.NewCipher(make([]byte, 16))
+	c, _ := NewSIV(&loggingBlock{macBlock, "mac", &log}, &loggingBlock{ctrBlock, "ctr", &log})
+	pt, ad := make([]byte, 100), []byte("header")
+	ct, _ := c.Seal(nil, pt, ad)
+
+	open := func(ct []byte) []string {
+		log = nil
+		c.Open(nil, ct, ad)
+		return log
+	}
+	want := open(ct)
+	blocks := (len(pt) + 15) / 16
+	last := len(want) - 1
+	for last >= 0 && want[last] == "mac" {
+		last--
+	}
+	// Every CTR block is decrypted, and only then is the IV recomputed
+	if n := strings.Count(strings.Join(want, " "), "ctr"); n != blocks || last < 0 || strings.Contains(strings.Join(want[:last+1-blocks], " "), "ctr") {
+		t.Fatalf("Open: expected %d consecutive CTR blocks before the final MAC blocks, got %v", blocks, want)
+	}
+
+	// A forgery differing anywhere makes the same calls in the same order
+	for _, i := range []int{0, TagSize - 1, TagSize, len(ct) / 2, len(ct) - 1} {
+		forged := append([]byte(nil), ct...)
+		forged[i] ^= 1
+		if got := open(forged); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("Open: forged byte %d: expected the block calls of an authentic ciphertext:\n%v\ngot:\n%v", i, want, got)
+		}
+	}
+	// A ciphertext too short to hold an IV is rejected without any
+	if got := open(ct[:TagSize-1]); len(got) != 0 {
+		t.Errorf("Open: too short: expected no block calls, got %v", got)
+	}
+}
+
+func TestNewSIV(t *testing.T) {
+	for i, v := range testVectors {
+		key := decode(v.key)
+		macBlock, err := aes.NewCipher(key[:len(key)/2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctrBlock, err := aes.NewCipher(key[len(key)/2:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		mac, ctr := &countingBlock{Block: macBlock}, &countingBlock{Block: ctrBlock}
+		c, err := NewSIV(mac, ctr)
+		if err != nil {
+			t.Fatalf("NewSIV: %d: %s", i, err)
+		}
+		gct := decode(v.output)
+		ct, err := c.Seal(nil, decode(v.plaintext), decodeAD(v.adata)...)
+		if err != nil {
+			t.Fatalf("Seal: %d: %s", i, err)
+		}
+		if !bytes.Equal(gct, ct) {
+			t.Errorf("Seal: %d: expected: %x\ngot: %x", i, gct, ct)
+		}
+		if mac.n == 0 || (ctr.n == 0 && len(ct) > c.Overhead()) {
+			t.Errorf("Seal: %d: the given block ciphers weren't used", i)
+		}
+	}
+
+	aesBlock, _ := aes.NewCipher(make([]byte, 16))
+	desBlock, _ := des.NewCipher(make([]byte, 8))
+	if _, err := NewSIV(desBlock, aesBlock); err != ErrBlockSize {
+		t.Errorf("NewSIV: 8-byte MAC block: expected ErrBlockSize, got %v", err)
+	}
+	if _, err := NewSIV(aesBlock, desBlock); err != ErrBlockSize {
+		t.Errorf("NewSIV: 8-byte CTR block: expected ErrBlockSize, got %v", err)
+	}
+}
+
+func TestSealBlockCount(t *testing.T) {
+	// The CMAC subkeys and the MAC of the zero block are computed once, so
+	// each Seal only encrypts the blocks of its own inputs
+	macBlock, _ := aes.NewCipher(make([]byte, 16))
+	ctrBlock, _ := aes.NewCipher(make([]byte, 16))
+	mac, ctr := &countingBlock{Block: macBlock}, &countingBlock{Block: ctrBlock}
+	c, err := NewSIV(mac, ctr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{0, 16, 17, 1024} {
+		mac.n, ctr.n = 0, 0
+		c.Seal(nil, make([]byte, n), []byte("header"))
+		// One block for the header, and the plaintext (at least one block)
+		wantMAC := 1 + (n+15)/16
+		if n == 0 {
+			wantMAC = 2
+		}
+		if mac.n != wantMAC || ctr.n != (n+15)/16 {
+			t.Errorf("Seal: %d bytes: expected %d MAC and %d CTR blocks, got %d and %d", n, wantMAC, (n+15)/16, mac.n, ctr.n)
+		}
+	}
+}
+
+func TestKeySplit(t *testing.T) {
+	// The first half of the key is used by S2V and the second half by CTR, so
+	// changing a byte in one half must change only the IV or only the body.
+	pt := make([]byte, 32)
+	for _, n := range []int{32, 48, 64} {
+		key := make([]byte, n)
+		seal := func() []byte {
+			c, err := NewAES(key)
+			if err != nil {
+				t.Fatalf("NewAES: %d byte key: %s", n, err)
+			}
+			ct, err := c.Seal(nil, pt)
+			if err != nil {
+				t.Fatalf("Seal: %d byte key: %s", n, err)
+			}
+			return ct
+		}
+		base := seal()
+		for _, i := range []int{0, n/2 - 1, n / 2, n - 1} {
+			key[i] ^= 1
+			ct := seal()
+			key[i] ^= 1
+			macKey := i < n/2
+			if ivChanged := !bytes.Equal(ct[:16], base[:16]); ivChanged != macKey {
+				t.Errorf("%d byte key: byte %d: IV changed: %v", n, i, ivChanged)
+			}
+			if bodyChanged := !bytes.Equal(ct[16:], base[16:]); !bodyChanged {
+				t.Errorf("%d byte key: byte %d: ciphertext unchanged", n, i)
+			}
+		}
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	for _, newCipher := range []func([]byte) (*Cipher, error){NewAES, NewPMACSIV} {
+		c, err := newCipher(make([]byte, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ad := []byte("associated data")
+		ct, err := c.Seal(nil, []byte("plaintext"), ad)
+		if err != nil {
+			t.Fatalf("Seal: %s", err)
+		}
+		ct[3] ^= 0x10
+		if _, err := c.Open(nil, ct, ad); !errors.Is(err, ErrNotAuthentic) {
+			t.Errorf("Open: flipped tag bit: expected ErrNotAuthentic, got %v", err)
+		}
+		if _, err := c.Open(nil, ct, ad); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("Open: flipped tag bit: expected ErrAuthFailed, got %v", err)
+		}
+		for n := 0; n < c.Overhead(); n++ {
+			_, err := c.Open(nil, ct[:n], ad)
+			if !errors.Is(err, ErrTooShort) {
+				t.Errorf("Open: %d bytes: expected ErrTooShort, got %v", n, err)
+			}
+			if errors.Is(err, ErrAuthFailed) {
+				t.Errorf("Open: %d bytes: ErrTooShort matches ErrAuthFailed", n)
+			}
+		}
+		if _, err := newCipher(make([]byte, 16)); !errors.Is(err, ErrKeySize) {
+			t.Errorf("NewCipher: 16 byte key: expected ErrKeySize, got %v", err)
+		}
+	}
+}
+
+func TestOpenTagBitFlips(t *testing.T) {
+	for _, newCipher := range []func([]byte) (*Cipher, error){NewAES, NewPMACSIV} {
+		c, err := newCipher(make([]byte, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct, err := c.Seal(nil, []byte("plaintext"))
+		if err != nil {
+			t.Fatalf("Seal: %s", err)
+		}
+		for i := 0; i < c.Overhead()*8; i++ {
+			ct[i/8] ^= 1 << uint(i%8)
+			if _, err := c.Open(nil, ct); err != ErrNotAuthentic {
+				t.Errorf("Open: tag bit %d flipped: expected ErrNotAuthentic, got %v", i, err)
+			}
+			ct[i/8] ^= 1 << uint(i%8)
+		}
+		if _, err := c.Open(nil, ct); err != nil {
+			t.Errorf("Open: %s", err)
+		}
+	}
+}
+
+func TestTooManyAssociatedDataItems(t *testing.T) {
+	c, err := NewAES(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("NewAES: %s", err)
+	}
+	ad := make([][]byte, MaxAssociatedDataItems+1)
+
+	ct, err := c.Seal(nil, nil, ad[:MaxAssociatedDataItems]...)
+	if err != nil {
+		t.Fatalf("Seal: %d items: %s", MaxAssociatedDataItems, err)
+	}
+	if _, err := c.Open(nil, ct, ad[:MaxAssociatedDataItems]...); err != nil {
+		t.Fatalf("Open: %d items: %s", MaxAssociatedDataItems, err)
+	}
+
+	if _, err := c.Seal(nil, nil, ad...); err != ErrTooManyAssociatedDataItems {
+		t.Errorf("Seal: expected ErrTooManyAssociatedDataItems, got %v", err)
+	}
+	if _, err := c.Open(nil, ct, ad...); err != ErrTooManyAssociatedDataItems {
+		t.Errorf("Open: expected ErrTooManyAssociatedDataItems, got %v", err)
+	}
+}
+
+func TestMaxPlaintextSize(t *testing.T) {
+	if strconv.IntSize < 64 {
+		t.Skip("MaxPlaintextSize exceeds the largest slice on 32-bit platforms")
+	}
+	// Slices this long can't be allocated here, so check the limit directly
+	max := uint64(MaxPlaintextSize)
+	if err := checkSealSize(0, TagSize, int(max)); err != nil {
+		t.Errorf("checkSealSize: at the limit: %s", err)
+	}
+	if err := checkSealSize(0, TagSize, int(max+1)); err != ErrPlaintextTooLong {
+		t.Errorf("checkSealSize: one byte over: expected ErrPlaintextTooLong, got %v", err)
+	}
+
+	// A slice header claiming more than the limit, backed by a single byte:
+	// Seal must reject it without reading or allocating anything
+	var b byte
+	huge := *(*[]byte)(unsafe.Pointer(&struct {
+		data     unsafe.Pointer
+		len, cap int
+	}{unsafe.Pointer(&b), int(max + 1), int(max + 1)}))
+	c, _ := NewAES(make([]byte, 32))
+	if _, err := c.Seal(nil, huge); err != ErrPlaintextTooLong {
+		t.Errorf("Seal: expected ErrPlaintextTooLong, got %v", err)
+	}
+	if _, _, err := c.SealDetached(nil, huge); err != ErrPlaintextTooLong {
+		t.Errorf("SealDetached: expected ErrPlaintextTooLong, got %v", err)
+	}
+	if _, err := EncryptAES(make([]byte, 32), nil, huge, nil); err != ErrPlaintextTooLong {
+		t.Errorf("EncryptAES: expected ErrPlaintextTooLong, got %v", err)
+	}
+	if _, err := c.NewSealWriter().Write(huge); err != ErrPlaintextTooLong {
+		t.Errorf("SealWriter.Write: expected ErrPlaintextTooLong, got %v", err)
+	}
+
+	// The last counter block of the longest plaintext doesn't carry out of
+	// the lowest 32 bits, whatever the IV
+	iv := bytes.Repeat([]byte{0xff}, 16)
+	zeroIVBits(iv)
+	if last := uint64(binary.BigEndian.Uint32(iv[12:])) + max/16 - 1; last > math.MaxUint32 {
+		t.Errorf("last counter block: low 32 bits carry: %x", last)
+	}
+}
+
+func TestCheckSealSize(t *testing.T) {
+	// Sums which overflow int, as they can on 32-bit platforms for lengths
+	// within MaxPlaintextSize
+	for _, tt := range []struct {
+		dstLen, overhead, n int
+		ok                  bool
+	}{
+		{0, 0, 0, true},
+		{0, TagSize, 1 << 20, true},
+		{maxInt - TagSize - 10, TagSize, 10, true},
+		{maxInt - TagSize - 10, TagSize, 11, false},
+		{maxInt - 10, TagSize, 0, false},
+		{maxInt, 0, 1, false},
+		{maxInt, TagSize, 0, false},
+	} {
+		err := checkSealSize(tt.dstLen, tt.overhead, tt.n)
+		if tt.ok && err != nil || !tt.ok && err != ErrPlaintextTooLong {
+			t.Errorf("checkSealSize(%d, %d, %d): %v", tt.dstLen, tt.overhead, tt.n, err)
+		}
+	}
+}
+
+func TestHeaderCounts(t *testing.T) {
+	// Vectors binding zero, one and three associated data items
+	for _, tt := range []struct {
+		name      string
+		newCipher func([]byte) (*Cipher, error)
+		vectors   []testVector
+	}{
+		{"AES-SIV", NewAES, testVectors},
+		{"AES-PMAC-SIV", NewPMACSIV, pmacTestVectors},
+	} {
+		seen := make(map[int]bool)
+		for i, v := range tt.vectors[:3] {
+			c, err := tt.newCipher(decode(v.key))
+			if err != nil {
+				t.Fatalf("%s: %d: %s", tt.name, i, err)
+			}
+			ad := decodeAD(v.adata)
+			seen[len(ad)] = true
+			ct, err := c.Seal(nil, decode(v.plaintext), ad...)
+			if err != nil {
+				t.Fatalf("%s: Seal: %d: %s", tt.name, i, err)
+			}
+			if !bytes.Equal(ct, decode(v.output)) {
+				t.Errorf("%s: Seal: %d headers: expected: %s\ngot: %x", tt.name, len(ad), v.output, ct)
+			}
+		}
+		for _, n := range []int{0, 1, 3} {
+			if !seen[n] {
+				t.Errorf("%s: no vector with %d headers", tt.name, n)
+			}
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	ctr := func(key, iv []byte) cipher.Stream {
+		b, _ := aes.NewCipher(key)
+		return cipher.NewCTR(b, iv)
+	}
+	for _, tc := range []struct {
+		name      string
+		newCipher func([]byte) (*Cipher, error)
+	}{
+		{"AES-SIV", NewAES},
+		{"AES-PMAC-SIV", NewPMACSIV},
+		{"AES-SIV with CTR", func(key []byte) (*Cipher, error) { return NewAESWithCTR(key, ctr) }},
+	} {
+		for _, n := range []int{32, 64} {
+			key := countingKey(n)
+			c, _ := tc.newCipher(key)
+			clone, err := c.Clone()
+			if err != nil {
+				t.Fatalf("%s: Clone: %s", tc.name, err)
+			}
+			pt := make([]byte, aesCTRSize+3)
+			want, _ := c.Seal(nil, pt, []byte("header"))
+			if ct, err := clone.Seal(nil, pt, []byte("header")); err != nil || !bytes.Equal(ct, want) {
+				t.Errorf("%s: %d-byte key: clone: Seal: expected: %x\ngot: %x (%v)", tc.name, n, want, ct, err)
+			}
+
+			// Reset on either leaves the other as it was
+			other, _ := clone.Clone()
+			c.Reset()
+			if out, err := clone.Open(nil, want, []byte("header")); err != nil || !bytes.Equal(out, pt) {
+				t.Errorf("%s: %d-byte key: clone: Open after Reset of the original: %v", tc.name, n, err)
+			}
+			clone.Reset()
+			if out, err := other.Open(nil, want, []byte("header")); err != nil || !bytes.Equal(out, pt) {
+				t.Errorf("%s: %d-byte key: clone of clone: Open after Reset of the clone: %v", tc.name, n, err)
+			}
+			if _, err := c.Clone(); err != ErrReset {
+				t.Errorf("%s: Clone after Reset: expected ErrReset, got %v", tc.name, err)
+			}
+		}
+	}
+
+	a, _ := NewAEADAESWithOptions(countingKey(32), 16, AEADOptions{NonceOrder: NonceFirst})
+	b, err := a.(AEAD).Clone()
+	if err != nil {
+		t.Fatalf("AEAD: Clone: %s", err)
+	}
+	a.(AEAD).Reset()
+	want, _ := NewAEADAESWithOptions(countingKey(32), 16, AEADOptions{NonceOrder: NonceFirst})
+	nonce := make([]byte, 16)
+	if ct := b.Seal(nil, nonce, nil, []byte("header")); !bytes.Equal(ct, want.Seal(nil, nonce, nil, []byte("header"))) || b.NonceSize() != 16 {
+		t.Errorf("AEAD: Clone: %x", ct)
+	}
+}
+
+func TestCloneConcurrent(t *testing.T) {
+	// Workers each with a clone of one Cipher, Resetting them as they finish,
+	// to be run with -race
+	for _, newCipher := range []func([]byte) (*Cipher, error){NewAES, NewPMACSIV} {
+		base, _ := newCipher(make([]byte, 32))
+		want, _ := base.Seal(nil, []byte("message"))
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			c, err := base.Clone()
+			if err != nil {
+				t.Fatal(err)
+			}
+			wg.Add(1)
+			go func(c *Cipher) {
+				defer wg.Done()
+				defer c.Reset()
+				for i := 0; i < 50; i++ {
+					ct, err := c.Seal(nil, []byte("message"))
+					if err != nil || !bytes.Equal(ct, want) {
+						t.Errorf("Seal: expected: %x\ngot: %x (%v)", want, ct, err)
+						return
+					}
+					if _, err := c.Open(nil, ct); err != nil {
+						t.Errorf("Open: %s", err)
+						return
+					}
+				}
+			}(c)
+		}
+		wg.Wait()
+		if _, err := base.Open(nil, want); err != nil {
+			t.Errorf("Open: original after its clones were Reset: %s", err)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	for _, newCipher := range []func([]byte) (*Cipher, error){NewAES, NewPMACSIV} {
+		c, err := newCipher(make([]byte, 32))
+		if err != nil {
+			t.Fatalf("NewCipher: %s", err)
+		}
+		ct, err := c.Seal(nil, []byte("plaintext"))
+		if err != nil {
+			t.Fatalf("Seal: %s", err)
+		}
+
+		// Scratch space is zeroed before it goes back to the pool
+		st := c.getState()
+		st.s2vStart(c.zeroMAC)
+		st.s2vFinish([]byte("plaintext"))
+		st.xorKeyStream(c.b, make([]byte, 16), make([]byte, 16), st.tmp2)
+		c.putState(st)
+		for _, b := range [][]byte{st.tmp1, st.tmp2, st.tag, st.ctr, st.ks} {
+			if !bytes.Equal(b, make([]byte, len(b))) {
+				t.Fatalf("putState: scratch buffers not zeroed: %x %x %x", st.tmp1, st.tmp2, st.ks)
+			}
+		}
+
+		c.Reset()
+		c.Reset()
+		if !bytes.Equal(c.zeroMAC, make([]byte, len(c.zeroMAC))) {
+			t.Errorf("Reset: cached MAC not zeroed: %x", c.zeroMAC)
+		}
+
+		if _, err := c.Seal(nil, []byte("plaintext")); err != ErrReset {
+			t.Errorf("Seal: expected ErrReset, got %v", err)
+		}
+		if _, err := c.Open(nil, ct); err != ErrReset {
+			t.Errorf("Open: expected ErrReset, got %v", err)
+		}
+	}
+}
+
+func TestOpenWipesPlaintext(t *testing.T) {
+	v := testVectors[1]
+	c, err := NewAES(decode(v.key))
+	if err != nil {
+		t.Fatalf("NewAES: %s", err)
+	}
+	ad := decodeAD(v.adata)
+	ct := decode(v.output)
+	ct[0] ^= 1
+
+	prefix := []byte{1, 2, 3, 4}
+	dst := make([]byte, len(prefix), len(prefix)+len(ct))
+	copy(dst, prefix)
+	for i := len(prefix); i < cap(dst); i++ {
+		dst[:cap(dst)][i] = 0xff
+	}
+
+	pt, err := c.Open(dst, ct, ad...)
+	if err != ErrNotAuthentic {
+		t.Fatalf("Open: expected ErrNotAuthentic, got %v", err)
+	}
+	if pt != nil {
+		t.Errorf("Open: expected nil plaintext, got %x", pt)
+	}
+	if !bytes.Equal(dst, prefix) {
+		t.Errorf("Open: dst prefix modified: %x", dst)
+	}
+	spare := dst[len(dst) : len(dst)+len(ct)-c.Overhead()]
+	if !bytes.Equal(spare, make([]byte, len(spare))) {
+		t.Errorf("Open: unauthenticated plaintext left in dst: %x", spare)
+	}
+}
+
+// TestOpenForged checks that nothing of a forged ciphertext's plaintext is
+// returned or left behind, whichever way Open is reached and for messages
+// short and long enough for each CTR path.
+func TestOpenForged(t *testing.T) {
+	key := make([]byte, 32)
+	ad, nonce := []byte("header"), make([]byte, 16)
+	for _, tc := range []struct {
+		newCipher func([]byte) (*Cipher, error)
+		newAEAD   func([]byte, int) (cipher.AEAD, error)
+	}{
+		{NewAES, NewAEADAES},
+		{NewPMACSIV, NewAEADAESPMACSIV},
+	} {
+		c, _ := tc.newCipher(key)
+		a, _ := tc.newAEAD(key, 16)
+		opens := []struct {
+			name string
+			open func(dst, ct []byte) ([]byte, error)
+		}{
+			{"Cipher.Open", func(dst, ct []byte) ([]byte, error) { return c.Open(dst, ct, ad, nonce) }},
+			{"AEAD.Open", func(dst, ct []byte) ([]byte, error) { return a.Open(dst, nonce, ct, ad) }},
+			{"Cipher.Open without associated data", func(dst, ct []byte) ([]byte, error) { return c.Open(dst, ct, nonce) }},
+		}
+		for _, o := range opens {
+			for _, n := range []int{0, 1, 16, 17, aesCTRSize + 3} {
+				pt := bytes.Repeat([]byte{0xaa}, n)
+				ct, _ := c.Seal(nil, pt, ad, nonce)
+				if o.name == "Cipher.Open without associated data" {
+					ct, _ = c.Seal(nil, pt, nonce)
+				}
+				ct[TagSize-1] ^= 1
+				name := string(c.Algorithm()) + ": " + o.name + ": " + strconv.Itoa(n) + " bytes"
+
+				// Appending to a dst with contents and spare capacity
+				prefix := []byte("prefix")
+				dst := make([]byte, len(prefix), len(prefix)+n)
+				copy(dst, prefix)
+				out, err := o.open(dst, ct)
+				if err != ErrAuthFailed || out != nil {
+					t.Errorf("%s: expected nil, ErrAuthFailed, got %x (%v)", name, out, err)
+				}
+				if !bytes.Equal(dst, prefix) || !bytes.Equal(dst[len(dst):cap(dst)], make([]byte, n)) {
+					t.Errorf("%s: dst: expected: %x followed by zeros\ngot: %x", name, prefix, dst[:cap(dst)])
+				}
+
+				// In place, over the IV or leaving the plaintext where it is
+				for _, off := range []int{0, c.Overhead()} {
+					buf := append([]byte(nil), ct...)
+					out, err := o.open(buf[off:off], buf)
+					if err != ErrAuthFailed || out != nil {
+						t.Errorf("%s: in place at %d: expected nil, ErrAuthFailed, got %x (%v)", name, off, out, err)
+					}
+					if !bytes.Equal(buf[off:off+n], make([]byte, n)) {
+						t.Errorf("%s: in place at %d: unauthenticated plaintext left: %x", name, off, buf[off:off+n])
+					}
+				}
+
+				// The scratch space returned to the pool holds none of it
+				st := c.getState()
+				for _, b := range [][]byte{st.tmp1, st.tmp2, st.tag, st.ctr, st.ks} {
+					if !bytes.Equal(b, make([]byte, len(b))) {
+						t.Errorf("%s: scratch space not zeroed: %x", name, b)
+					}
+				}
+				c.putState(st)
+			}
+		}
+	}
+}
+
+func TestSealWithIV(t *testing.T) {
+	for _, newCipher := range []func([]byte) (*Cipher, error){NewAES, NewPMACSIV} {
+		c, _ := newCipher(make([]byte, 32))
+		for _, n := range []int{0, 1, 16, aesCTRSize + 3} {
+			pt := make([]byte, n)
+			want, _ := c.Seal(nil, pt, []byte("header"))
+			_, tag, _ := c.SealDetached(nil, pt, []byte("header"))
+			ct, iv, err := c.SealWithIV([]byte("prefix"), pt, []byte("header"))
+			if err != nil || string(ct[:6]) != "prefix" || !bytes.Equal(ct[6:], want) {
+				t.Errorf("SealWithIV: %d bytes: expected: %x\ngot: %x (%v)", n, want, ct[6:], err)
+			}
+			if !bytes.Equal(iv[:], want[:TagSize]) || iv != tag {
+				t.Errorf("SealWithIV: %d bytes: expected IV: %x\ngot: %x", n, want[:TagSize], iv)
+			}
+		}
+	}
+
+	c, _ := NewAES(make([]byte, 32))
+	c.Reset()
+	if ct, iv, err := c.SealWithIV(nil, nil); err != ErrReset || ct != nil || iv != [TagSize]byte{} {
+		t.Errorf("SealWithIV: expected ErrReset, got %x %x (%v)", ct, iv, err)
+	}
+}
+
+func TestDetached(t *testing.T) {
+	for _, newCipher := range []func([]byte) (*Cipher, error){NewAES, NewPMACSIV} {
+		c, err := newCipher(make([]byte, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ad := []byte("header")
+		other, otherTag, _ := c.SealDetached(nil, []byte("another message"), ad)
+		for _, n := range []int{0, 1, 15, 16, 17, aesCTRSize + 3} {
+			m := make([]byte, n)
+			for i := range m {
+				m[i] = byte(i)
+			}
+			ct, tag, err := c.SealDetached([]byte("prefix"), m, ad)
+			if err != nil {
+				t.Fatalf("SealDetached: %d bytes: %s", n, err)
+			}
+			if string(ct[:6]) != "prefix" || len(ct) != 6+n {
+				t.Fatalf("SealDetached: %d bytes: bad append: %x", n, ct)
+			}
+			ct = ct[6:]
+			want, _ := c.Seal(nil, m, ad)
+			if got := append(tag[:], ct...); !bytes.Equal(got, want) {
+				t.Errorf("SealDetached: %d bytes: expected: %x\ngot: %x", n, want, got)
+			}
+			pt, err := c.OpenDetached(nil, ct, tag[:], ad)
+			if err != nil || !bytes.Equal(pt, m) || pt == nil {
+				t.Errorf("OpenDetached: %d bytes: %x (%v)", n, pt, err)
+			}
+
+			// In place
+			buf := append([]byte(nil), m...)
+			x, tag2, _ := c.SealDetached(buf[:0], buf, ad)
+			if tag2 != tag || !bytes.Equal(x, ct) {
+				t.Errorf("SealDetached: %d bytes: in place: expected: %x\ngot: %x", n, ct, x)
+			}
+			if x, err = c.OpenDetached(buf[:0], buf, tag[:], ad); err != nil || !bytes.Equal(x, m) {
+				t.Errorf("OpenDetached: %d bytes: in place: %x (%v)", n, x, err)
+			}
+
+			// A tag from another message doesn't open, and nothing is revealed
+			buf = append([]byte(nil), ct...)
+			if x, err := c.OpenDetached(buf[:0], buf, otherTag[:], ad); err != ErrNotAuthentic || x != nil {
+				t.Errorf("OpenDetached: %d bytes: another message's tag: %x (%v)", n, x, err)
+			}
+			if !bytes.Equal(buf, make([]byte, n)) {
+				t.Errorf("OpenDetached: %d bytes: unauthenticated plaintext left in dst: %x", n, buf)
+			}
+		}
+		if _, err := c.OpenDetached(nil, other, otherTag[:15], ad); err != ErrTagSize {
+			t.Errorf("OpenDetached: short tag: expected ErrTagSize, got %v", err)
+		}
+	}
+}
+
+func TestOpenInto(t *testing.T) {
+	v := testVectors[1]
+	c, err := NewAES(decode(v.key))
+	if err != nil {
+		t.Fatalf("NewAES: %s", err)
+	}
+	pt, ct, ad := decode(v.plaintext), decode(v.output), decodeAD(v.adata)
+
+	for _, size := range []int{len(pt), len(pt) + 10} {
+		dst := bytes.Repeat([]byte{0xaa}, size)
+		n, err := c.OpenInto(dst, ct, ad...)
+		if err != nil || n != len(pt) || !bytes.Equal(dst[:n], pt) {
+			t.Errorf("OpenInto: %d byte dst: expected: %x\ngot: %x (%v)", size, pt, dst[:n], err)
+		}
+		if !bytes.Equal(dst[n:], bytes.Repeat([]byte{0xaa}, size-n)) {
+			t.Errorf("OpenInto: %d byte dst: wrote past the plaintext: %x", size, dst[n:])
+		}
+	}
+
+	dst := bytes.Repeat([]byte{0xaa}, len(pt)-1)
+	if n, err := c.OpenInto(dst, ct, ad...); err != ErrShortBuffer || n != 0 {
+		t.Errorf("OpenInto: short dst: expected ErrShortBuffer, got %d (%v)", n, err)
+	}
+	if !bytes.Equal(dst, bytes.Repeat([]byte{0xaa}, len(dst))) {
+		t.Errorf("OpenInto: short dst was written to: %x", dst)
+	}
+	if _, err := c.OpenInto(make([]byte, 100), ct[:c.Overhead()-1], ad...); err != ErrTooShort {
+		t.Errorf("OpenInto: expected ErrTooShort, got %v", err)
+	}
+
+	// In place, over the IV or leaving the plaintext where it is
+	for _, off := range []int{0, c.Overhead()} {
+		buf := append([]byte(nil), ct...)
+		n, err := c.OpenInto(buf[off:], buf, ad...)
+		if err != nil || !bytes.Equal(buf[off:off+n], pt) {
+			t.Errorf("OpenInto: in place at %d: expected: %x\ngot: %x (%v)", off, pt, buf[off:off+n], err)
+		}
+	}
+
+	forged := append([]byte(nil), ct...)
+	forged[0] ^= 1
+	dst = make([]byte, len(pt))
+	if n, err := c.OpenInto(dst, forged, ad...); err != ErrAuthFailed || n != 0 {
+		t.Errorf("OpenInto: forged: expected ErrAuthFailed, got %d (%v)", n, err)
+	}
+	if !bytes.Equal(dst, make([]byte, len(dst))) {
+		t.Errorf("OpenInto: forged: unauthenticated plaintext left in dst: %x", dst)
+	}
+}
+
+func TestSealInto(t *testing.T) {
+	v := testVectors[1]
+	c, err := NewAES(decode(v.key))
+	if err != nil {
+		t.Fatalf("NewAES: %s", err)
+	}
+	pt, ct, ad := decode(v.plaintext), decode(v.output), decodeAD(v.adata)
+
+	for _, size := range []int{len(ct), len(ct) + 10} {
+		dst := bytes.Repeat([]byte{0xaa}, size)
+		n, err := c.SealInto(dst, pt, ad...)
+		if err != nil || n != len(ct) || !bytes.Equal(dst[:n], ct) {
+			t.Errorf("SealInto: %d byte dst: expected: %x\ngot: %x (%v)", size, ct, dst[:n], err)
+		}
+		if !bytes.Equal(dst[n:], bytes.Repeat([]byte{0xaa}, size-n)) {
+			t.Errorf("SealInto: %d byte dst: wrote past the ciphertext: %x", size, dst[n:])
+		}
+	}
+
+	dst := bytes.Repeat([]byte{0xaa}, len(ct)-1)
+	if n, err := c.SealInto(dst, pt, ad...); err != ErrShortBuffer || n != 0 {
+		t.Errorf("SealInto: short dst: expected ErrShortBuffer, got %d (%v)", n, err)
+	}
+	if !bytes.Equal(dst, bytes.Repeat([]byte{0xaa}, len(dst))) {
+		t.Errorf("SealInto: short dst was written to: %x", dst)
+	}
+
+	// In place, over the plaintext or into room reserved in front of it
+	for _, off := range []int{0, c.Overhead()} {
+		buf := make([]byte, len(ct))
+		copy(buf[off:], pt)
+		n, err := c.SealInto(buf, buf[off:off+len(pt)], ad...)
+		if err != nil || !bytes.Equal(buf[:n], ct) {
+			t.Errorf("SealInto: in place at %d: expected: %x\ngot: %x (%v)", off, ct, buf[:n], err)
+		}
+	}
+}
+
+func TestBoundaryLengths(t *testing.T) {
+	key := decode(testVectors[0].key)
+	ctrBlock, _ := aes.NewCipher(key[16:])
+	c, err := NewAES(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n <= 17; n++ {
+		pt := make([]byte, n)
+		for i := range pt {
+			pt[i] = byte(i)
+		}
+		for _, ad := range [][][]byte{nil, {{}}, {[]byte("header")}} {
+			// Reference: the IV from S2V, then the body from crypto/cipher CTR
+			iv, err := S2V(key[:16], append(append([][]byte{}, ad...), pt)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctr := append([]byte(nil), iv[:]...)
+			zeroIVBits(ctr)
+			want := append(iv[:], pt...)
+			cipher.NewCTR(ctrBlock, ctr).XORKeyStream(want[16:], pt)
+
+			ct, err := c.Seal(nil, pt, ad...)
+			if err != nil || !bytes.Equal(ct, want) {
+				t.Errorf("Seal: %d bytes, %d items: expected: %x\ngot: %x (%v)", n, len(ad), want, ct, err)
+			}
+			out, err := c.Open(nil, ct, ad...)
+			if err != nil || out == nil || !bytes.Equal(out, pt) {
+				t.Errorf("Open: %d bytes, %d items: expected: %x\ngot: %#v (%v)", n, len(ad), pt, out, err)
+			}
+		}
+	}
+
+	// An empty AD item is an S2V input of its own, unlike no item at all
+	a, _ := c.Seal(nil, nil)
+	b, _ := c.Seal(nil, nil, []byte{})
+	if len(a) != c.Overhead() || bytes.Equal(a, b) {
+		t.Errorf("Seal: empty plaintext: %x and %x", a, b)
+	}
+}
+
+func TestConcurrentSeal(t *testing.T) {
+	// Hundreds of Seals at once on one Cipher, each with its own nonce and
+	// buffers, all of which must open afterwards
+	const goroutines = 300
+	for _, newCipher := range []func([]byte) (*Cipher, error){NewAES, NewPMACSIV} {
+		c, err := newCipher(make([]byte, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ad := []byte("associated data")
+		cts := make([][]byte, goroutines)
+		var wg sync.WaitGroup
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				nonce := []byte(strconv.Itoa(g))
+				ct, err := c.Seal(nil, bytes.Repeat(nonce, 20), ad, nonce)
+				if err != nil {
+					t.Errorf("Seal: %d: %s", g, err)
+				}
+				cts[g] = ct
+			}(g)
+		}
+		wg.Wait()
+		for g, ct := range cts {
+			nonce := []byte(strconv.Itoa(g))
+			pt, err := c.Open(nil, ct, ad, nonce)
+			if err != nil || !bytes.Equal(pt, bytes.Repeat(nonce, 20)) {
+				t.Errorf("Open: %d: %x (%v)", g, pt, err)
+			}
+		}
+	}
+}
+
+func TestInPlace(t *testing.T) {
+	v := testVectors[1]
+	c, err := NewAES(decode(v.key))
+	if err != nil {
+		t.Fatalf("NewAES: %s", err)
+	}
+	pt, ct, ad := decode(v.plaintext), decode(v.output), decodeAD(v.adata)
+	overhead := c.Overhead()
+
+	// dst starts where the plaintext does
+	buf := make([]byte, len(ct))
+	copy(buf, pt)
+	x, err := c.Seal(buf[:0], buf[:len(pt)], ad...)
+	if err != nil {
+		t.Fatalf("Seal: %s", err)
+	}
+	if !bytes.Equal(x, ct) || &x[0] != &buf[0] {
+		t.Errorf("Seal: in place: expected: %x\ngot: %x", ct, x)
+	}
+	x, err = c.Open(buf[:0], buf, ad...)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	if !bytes.Equal(x, pt) || &x[0] != &buf[0] {
+		t.Errorf("Open: in place: expected: %x\ngot: %x", pt, x)
+	}
+
+	// plaintext follows space reserved for the IV
+	buf = make([]byte, len(ct))
+	copy(buf[overhead:], pt)
+	x, err = c.Seal(buf[:0], buf[overhead:], ad...)
+	if err != nil {
+		t.Fatalf("Seal: %s", err)
+	}
+	if !bytes.Equal(x, ct) {
+		t.Errorf("Seal: in place after IV: expected: %x\ngot: %x", ct, x)
+	}
+	x, err = c.Open(buf[overhead:overhead], buf, ad...)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	if !bytes.Equal(x, pt) || &x[0] != &buf[overhead] {
+		t.Errorf("Open: in place after IV: expected: %x\ngot: %x", pt, x)
+	}
+
+	// appending to a slice with existing contents
+	buf = make([]byte, 4, 4+len(ct))
+	copy(buf, "abcd")
+	x, err = c.Seal(buf, pt, ad...)
+	if err != nil {
+		t.Fatalf("Seal: %s", err)
+	}
+	if !bytes.Equal(x[:4], []byte("abcd")) || !bytes.Equal(x[4:], ct) {
+		t.Errorf("Seal: append: expected: abcd%x\ngot: %x", ct, x)
+	}
+}
+
+func TestInPlaceInvalidOverlap(t *testing.T) {
+	v := testVectors[1]
+	c, err := NewAES(decode(v.key))
+	if err != nil {
+		t.Fatalf("NewAES: %s", err)
+	}
+	pt, ct, ad := decode(v.plaintext), decode(v.output), decodeAD(v.adata)
+
+	mustPanic := func(name string, f func()) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s: expected panic on inexact overlap", name)
+			}
+		}()
+		f()
+	}
+	mustPanic("Seal", func() {
+		buf := make([]byte, len(ct)+8)
+		copy(buf[8:], pt)
+		c.Seal(buf[:3], buf[8:8+len(pt)], ad...)
+	})
+	mustPanic("Open", func() {
+		buf := make([]byte, len(ct)+8)
+		copy(buf[8:], ct)
+		c.Open(buf[:3], buf[8:], ad...)
+	})
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+)
+
+// StreamNoncePrefixSize is the size of the caller-supplied nonce prefix
+// used by the STREAM construction.
+const StreamNoncePrefixSize = 8
+
+// StreamNonceSize is the size of the per-segment nonce passed to the
+// underlying AEAD: nonce prefix ‖ 32-bit big endian counter ‖ last block flag.
+const StreamNonceSize = StreamNoncePrefixSize + 4 + 1
+
+// lastBlockFlag is the final byte of the nonce of the last segment in a
+// STREAM (it is zero for every other segment).
+const lastBlockFlag byte = 1
+
+// maxStreamCounter is the largest segment counter STREAM can encode.
+const maxStreamCounter = ^uint32(0)
+
+var (
+	ErrStreamNoncePrefixSize = errors.New("siv: STREAM nonce prefix must be 8 bytes")
+	ErrStreamCounterOverflow = errors.New("siv: STREAM counter overflow")
+	ErrStreamFinished        = errors.New("siv: STREAM already finished")
+	ErrStreamAEAD            = errors.New("siv: STREAM requires an AEAD of this package taking its segment nonces")
+)
+
+// AEADConstructor creates a cipher.AEAD from a key and nonce size, as
+// NewAEADAES and NewAEADAESPMACSIV do.
+type AEADConstructor func(key []byte, nonceSize int) (cipher.AEAD, error)
+
+// StreamEncryptor encrypts a message as a sequence of segments using the
+// STREAM construction (Hoang, Reyhanitabar, Rogaway and Vizár, "Online
+// Authenticated-Encryption and its Nonce-Reuse Misuse-Resistance").
+//
+// Each segment is sealed under a nonce derived from the nonce prefix and the
+// position of the segment, so segments cannot be reordered, and the final
+// segment is marked as such, so the stream cannot be truncated.
+type StreamEncryptor struct {
+	a *aead
+	n NonceEncoder
+}
+
+// NewStreamEncryptor returns a STREAM encryptor using the AEAD created by
+// newAEAD (e.g. NewAEADAES or NewAEADAESPMACSIV) with the given key, and the
+// given 8-byte nonce prefix, which must be unique for each stream.
+//
+// STREAM passes each segment's associated data to S2V as an item even when
+// it is empty, followed by the segment's nonce, whatever NonceOrder or
+// EmptyADEncoding the AEAD was made with, so that the segments don't depend
+// on how the AEAD treats empty associated data. newAEAD must therefore
+// return an AEAD of this package, taking nonces of StreamNonceSize bytes;
+// other AEADs, including wrappers of this package's, are rejected with
+// ErrStreamAEAD.
+func NewStreamEncryptor(newAEAD AEADConstructor, key, noncePrefix []byte) (*StreamEncryptor, error) {
+	a, n, err := newStream(newAEAD, key, noncePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamEncryptor{a: a, n: n}, nil
+}
+
+// NewStreamEncryptorByName is like NewStreamEncryptor, with the AEAD named
+// by alg, as for NewAEADByName. The nonces of the segments don't depend on
+// the algorithm.
+func NewStreamEncryptorByName(alg string, key, noncePrefix []byte) (*StreamEncryptor, error) {
+	return NewStreamEncryptor(aeadByName(alg), key, noncePrefix)
+}
+
+// NonceSize returns the size of the nonce prefix the stream was created with.
+func (e *StreamEncryptor) NonceSize() int { return StreamNoncePrefixSize }
+
+// Overhead returns the difference between plaintext and ciphertext lengths
+// of each segment.
+func (e *StreamEncryptor) Overhead() int { return e.a.Overhead() }
+
+// Seal encrypts and authenticates the next segment of the stream along with
+// the given associated data, and appends the result to dst, returning the
+// updated slice. lastBlock must be true for the final segment, after which
+// Seal returns ErrStreamFinished.
+//
+// Each segment has associated data of its own, such as the offset of the
+// segment or metadata about its contents, which is passed to S2V along with
+// the segment's nonce. It must be passed again to Open for that segment, and
+// doesn't authenticate any other.
+func (e *StreamEncryptor) Seal(dst, plaintext, data []byte, lastBlock bool) ([]byte, error) {
+	nonce, err := e.n.Nonce(lastBlock)
+	if err != nil {
+		return nil, err
+	}
+	out, err := streamSeal(e.a, dst, nonce, plaintext, data)
+	if err != nil {
+		return nil, err
+	}
+	// Nonce has already checked that the stream can advance
+	e.n.Advance(lastBlock)
+	return out, nil
+}
+
+// StreamDecryptor decrypts a message encrypted by StreamEncryptor.
+type StreamDecryptor struct {
+	a *aead
+	n NonceEncoder
+}
+
+// NewStreamDecryptor returns a STREAM decryptor using the AEAD created by
+// newAEAD with the given key and 8-byte nonce prefix, which must match those
+// the stream was encrypted with. newAEAD must return an AEAD of this package,
+// as for NewStreamEncryptor.
+func NewStreamDecryptor(newAEAD AEADConstructor, key, noncePrefix []byte) (*StreamDecryptor, error) {
+	a, n, err := newStream(newAEAD, key, noncePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamDecryptor{a: a, n: n}, nil
+}
+
+// NewStreamDecryptorByName is like NewStreamDecryptor, with the AEAD named
+// by alg, as for NewAEADByName.
+func NewStreamDecryptorByName(alg string, key, noncePrefix []byte) (*StreamDecryptor, error) {
+	return NewStreamDecryptor(aeadByName(alg), key, noncePrefix)
+}
+
+// NonceSize returns the size of the nonce prefix the stream was created with.
+func (d *StreamDecryptor) NonceSize() int { return StreamNoncePrefixSize }
+
+// Overhead returns the difference between plaintext and ciphertext lengths
+// of each segment.
+func (d *StreamDecryptor) Overhead() int { return d.a.Overhead() }
+
+// Open decrypts and authenticates the next segment of the stream along with
+// the given associated data, and if successful appends the resulting
+// plaintext to dst, returning the updated slice. lastBlock must be true for
+// the final segment. The position of the stream only advances when a segment
+// is authentic.
+func (d *StreamDecryptor) Open(dst, ciphertext, data []byte, lastBlock bool) ([]byte, error) {
+	nonce, err := d.n.Nonce(lastBlock)
+	if err != nil {
+		return nil, err
+	}
+	out, err := streamOpen(d.a, dst, nonce, ciphertext, data)
+	if err != nil {
+		return nil, err
+	}
+	d.n.Advance(lastBlock)
+	return out, nil
+}
+
+// Finished reports whether the last segment of the stream has been opened.
+// A stream which ends before Finished is true has been truncated and must be
+// rejected, even though every segment opened so far was authentic.
+func (d *StreamDecryptor) Finished() bool { return d.n.Finished() }
+
+func newStream(newAEAD AEADConstructor, key, noncePrefix []byte) (*aead, NonceEncoder, error) {
+	n, err := NewNonceEncoder(noncePrefix)
+	if err != nil {
+		return nil, NonceEncoder{}, err
+	}
+	a, err := newAEAD(key, StreamNonceSize)
+	if err != nil {
+		return nil, NonceEncoder{}, err
+	}
+	s, ok := a.(*aead)
+	if !ok || s.checkNonce(make([]byte, StreamNonceSize)) != nil {
+		return nil, NonceEncoder{}, ErrStreamAEAD
+	}
+	return s, *n, nil
+}
+
+// aeadByName returns an AEADConstructor for the algorithm named alg.
+func aeadByName(alg string) AEADConstructor {
+	return func(key []byte, nonceSize int) (cipher.AEAD, error) {
+		return NewAEADByName(alg, key, nonceSize)
+	}
+}
+
+// streamSeal seals a segment with the Cipher of a, passing data and then
+// nonce to S2V, as documented on NewStreamEncryptor.
+func streamSeal(a *aead, dst, nonce, plaintext, data []byte) ([]byte, error) {
+	return a.c.sealPair(dst, plaintext, data, nonce)
+}
+
+// streamOpen opens a segment with the Cipher of a, as for streamSeal.
+func streamOpen(a *aead, dst, nonce, ciphertext, data []byte) ([]byte, error) {
+	return a.c.openPair(dst, ciphertext, data, nonce)
+}
+
+// NonceEncoder computes the nonces of the segments of a STREAM, for use by
+// StreamEncryptor and StreamDecryptor or by callers segmenting messages
+// themselves. Each nonce is StreamNonceSize bytes long: the 8-byte nonce
+// prefix, the position of the segment as a 32-bit big endian counter starting
+// from zero, and a final byte which is one for the last segment and zero for
+// every other, as the Miscreant STREAM description specifies.
+//
+// Interoperability with the STREAM implementations of the other Miscreant
+// bindings is unverified: the STREAM vectors in testdata were generated by
+// this package, and no stream produced by another implementation has been
+// decrypted with it.
+//
+// Callers segmenting messages themselves seal each segment with Cipher.Seal,
+// passing the segment's associated data, even if it is empty, and then the
+// nonce, as the associated data items.
+type NonceEncoder struct {
+	value    [StreamNonceSize]byte
+	counter  uint32
+	finished bool
+}
+
+// NewNonceEncoder returns a NonceEncoder for the first segment of a stream
+// with the given 8-byte nonce prefix.
+func NewNonceEncoder(noncePrefix []byte) (*NonceEncoder, error) {
+	if len(noncePrefix) != StreamNoncePrefixSize {
+		return nil, ErrStreamNoncePrefixSize
+	}
+	n := new(NonceEncoder)
+	copy(n.value[:], noncePrefix)
+	return n, nil
+}
+
+// Nonce returns the nonce for the current segment, with the last block flag
+// set if lastBlock is true. The returned slice is overwritten by the next call.
+//
+// A segment which is not the last one cannot use the maximum counter value,
+// since the stream could then never be finished, so Nonce returns
+// ErrStreamCounterOverflow instead. It returns ErrStreamFinished once the last
+// segment has been passed to Advance.
+func (n *NonceEncoder) Nonce(lastBlock bool) ([]byte, error) {
+	if n.finished {
+		return nil, ErrStreamFinished
+	}
+	if !lastBlock && n.counter == maxStreamCounter {
+		return nil, ErrStreamCounterOverflow
+	}
+	binary.BigEndian.PutUint32(n.value[StreamNoncePrefixSize:], n.counter)
+	n.value[StreamNonceSize-1] = 0
+	if lastBlock {
+		n.value[StreamNonceSize-1] = lastBlockFlag
+	}
+	return n.value[:], nil
+}
+
+// Advance moves on to the next segment once the current one has been sealed
+// or opened under the nonce returned by Nonce(lastBlock). After the last
+// segment, the stream is finished. Advance returns the error Nonce would have
+// for the same segment, in which case the position doesn't change.
+func (n *NonceEncoder) Advance(lastBlock bool) error {
+	if n.finished {
+		return ErrStreamFinished
+	}
+	if lastBlock {
+		n.finished = true
+		return nil
+	}
+	if n.counter == maxStreamCounter {
+		return ErrStreamCounterOverflow
+	}
+	n.counter++
+	return nil
+}
+
+// Counter returns the position of the current segment.
+func (n *NonceEncoder) Counter() uint32 { return n.counter }
+
+// Finished reports whether the last segment has been passed to Advance.
+func (n *NonceEncoder) Finished() bool { return n.finished }
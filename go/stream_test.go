@@ -0,0 +1,423 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"testing"
+)
+
+type streamTestVector struct {
+	name    string
+	newAEAD AEADConstructor
+	key     string
+	nonce   string
+	blocks  []streamTestBlock
+}
+
+type streamTestBlock struct {
+	ad         string
+	plaintext  string
+	ciphertext string
+}
+
+// These vectors, also in testdata/aes_siv_stream.tjson, were generated by this
+// package and are regression vectors only: they pin the nonce layout
+// documented on NonceEncoder at both the 128-bit and the 256-bit (64-byte
+// AES-256-SIV key) level, but no other implementation has produced or checked
+// them, so they don't show that the streams interoperate. The -AD streams
+// give the second and third segments different associated data, so that
+// each segment's associated data is bound to that segment.
+var streamTestVectors = []streamTestVector{
+	{
+		"AES-SIV-STREAM-128",
+		NewAEADAES,
+		"00010203 04050607 08090a0b 0c0d0e0f 10111213 14151617 18191a1b 1c1d1e1f",
+		"10111213 14151617",
+		[]streamTestBlock{
+			{"", "48656c6c 6f2c2077 6f726c64 21", "d6111508 bfbf9ea0 c818abc0 f4ccbd97 f3b0cb91 2de7dcba 64cf0d79 f8"},
+			{"6164", "48656c6c 6f2c2077 6f726c64 21", "b04557e6 e9355a78 bf5df5c2 d7c240f8 ef7c2fc4 9cd0aee4 329dbcac a3"},
+			{"", "476f6f64 62796521", "5512ce1f a8175979 1b037786 a9aadd9c 0ad9f504 b4734b69"},
+		},
+	},
+	// The AES-PMAC-SIV streams are regression vectors only too: there are no
+	// aes_pmac_siv_stream vectors among the shared vectors to check them
+	// against, so that the segment nonces are the same as for AES-SIV rests on
+	// this package alone.
+	{
+		"AES-PMAC-SIV-STREAM-128",
+		NewAEADAESPMACSIV,
+		"00010203 04050607 08090a0b 0c0d0e0f 10111213 14151617 18191a1b 1c1d1e1f",
+		"10111213 14151617",
+		[]streamTestBlock{
+			{"", "48656c6c 6f2c2077 6f726c64 21", "2d627952 00005ea2 29171ab6 2a6c74a0 bb56f86f 130800ad 0c915d0f f9"},
+			{"6164", "48656c6c 6f2c2077 6f726c64 21", "96a02e2e 54f9c214 1bdaa24f d10d8638 f6303430 1288d5c1 4d9c0016 13"},
+			{"", "476f6f64 62796521", "03614f9b 33f63786 cb10a5bc 870f7247 990ce064 6a115a90"},
+		},
+	},
+	{
+		"AES-SIV-STREAM-256",
+		NewAEADAES,
+		"00010203 04050607 08090a0b 0c0d0e0f 10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627 28292a2b 2c2d2e2f 30313233 34353637 38393a3b 3c3d3e3f",
+		"10111213 14151617",
+		[]streamTestBlock{
+			{"", "48656c6c 6f2c2077 6f726c64 21", "4221a5d1 807a5437 8e566fb2 f9999936 f3dd73c1 6619eea3 e3374606 07"},
+			{"6164", "48656c6c 6f2c2077 6f726c64 21", "061bbe20 ae6f973b e47892a8 00961290 e6d17757 c17826a8 a8ff884d fa"},
+			{"", "476f6f64 62796521", "df48d373 7c65ccfb 0367797c 07db0bc7 5362587b ed6fa533"},
+		},
+	},
+	{
+		"AES-PMAC-SIV-STREAM-256",
+		NewAEADAESPMACSIV,
+		"00010203 04050607 08090a0b 0c0d0e0f 10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627 28292a2b 2c2d2e2f 30313233 34353637 38393a3b 3c3d3e3f",
+		"10111213 14151617",
+		[]streamTestBlock{
+			{"", "48656c6c 6f2c2077 6f726c64 21", "b5be324f d455cbb4 8f2b97ff ccfddf9d cdba00f7 cf6ba7ae 80fb28fd 40"},
+			{"6164", "48656c6c 6f2c2077 6f726c64 21", "4bce07a7 3fb16c3d 01e3f0ec 54c89813 88b51cff e8e34abe 446332e7 b9"},
+			{"", "476f6f64 62796521", "cd83ebac ca2dea55 0576153b 96c776f8 eab7673b 12cb7b1b"},
+		},
+	},
+	{
+		"AES-SIV-STREAM-AD",
+		NewAEADAES,
+		"00010203 04050607 08090a0b 0c0d0e0f 10111213 14151617 18191a1b 1c1d1e1f",
+		"20212223 24252627",
+		[]streamTestBlock{
+			{"", "48656c6c 6f2c2077 6f726c64 21", "96ea7b98 46a82007 d0b91e7c 7c2c0f94 ea2d0052 d1e51971 c3c2da28 4b"},
+			{"636f6e74 656e742d 74797065 3a207465 78742f70 6c61696e", "48656c6c 6f2c2077 6f726c64 21", "d2a3a426 66c247ce c122c885 3405de16 ec343534 e443da94 25cb30d4 17"},
+			{"6f666673 65743a20 3236", "48656c6c 6f2c2077 6f726c64 21", "36890f94 86f51045 50ca2c45 cee9b23c 6cc8ddbd c759be6f 9fe5b9e1 b4"},
+			{"", "476f6f64 62796521", "5719cb6e a8a38ea8 0b91e55d 259349e7 f5245d5e 6f63374f"},
+		},
+	},
+	{
+		"AES-PMAC-SIV-STREAM-AD",
+		NewAEADAESPMACSIV,
+		"00010203 04050607 08090a0b 0c0d0e0f 10111213 14151617 18191a1b 1c1d1e1f",
+		"20212223 24252627",
+		[]streamTestBlock{
+			{"", "48656c6c 6f2c2077 6f726c64 21", "20706d5d 7a36b938 401d683b 6d0c6b00 5b4f43e4 1f7144fe b0540baf bb"},
+			{"636f6e74 656e742d 74797065 3a207465 78742f70 6c61696e", "48656c6c 6f2c2077 6f726c64 21", "f90b7468 199d5849 b8a434ec a33d3272 e3f59dc8 911bb1eb 80a6bc63 70"},
+			{"6f666673 65743a20 3236", "48656c6c 6f2c2077 6f726c64 21", "a57e86ea 68bde4fa c942015b 2285a9db 48756cc4 f27f3833 5d874c42 63"},
+			{"", "476f6f64 62796521", "24b64426 63e623c2 0e94d64d 4ebe5eb9 0b512595 b3b7f16f"},
+		},
+	},
+}
+
+func TestStream(t *testing.T) {
+	for _, v := range streamTestVectors {
+		key, nonce := decode(v.key), decode(v.nonce)
+		enc, err := NewStreamEncryptor(v.newAEAD, key, nonce)
+		if err != nil {
+			t.Fatalf("%s: NewStreamEncryptor: %s", v.name, err)
+		}
+		dec, err := NewStreamDecryptor(v.newAEAD, key, nonce)
+		if err != nil {
+			t.Fatalf("%s: NewStreamDecryptor: %s", v.name, err)
+		}
+		for i, b := range v.blocks {
+			last := i == len(v.blocks)-1
+			ad, gpt, gct := decode(b.ad), decode(b.plaintext), decode(b.ciphertext)
+			ct, err := enc.Seal(nil, gpt, ad, last)
+			if err != nil {
+				t.Fatalf("%s: Seal: %d: %s", v.name, i, err)
+			}
+			if !bytes.Equal(gct, ct) {
+				t.Errorf("%s: Seal: %d: expected: %x\ngot: %x", v.name, i, gct, ct)
+			}
+			pt, err := dec.Open(nil, ct, ad, last)
+			if err != nil {
+				t.Fatalf("%s: Open: %d: %s", v.name, i, err)
+			}
+			if !bytes.Equal(gpt, pt) {
+				t.Errorf("%s: Open: %d: expected: %x\ngot: %x", v.name, i, gpt, pt)
+			}
+		}
+		if _, err := enc.Seal(nil, nil, nil, true); err != ErrStreamFinished {
+			t.Errorf("%s: Seal after last block: expected ErrStreamFinished, got %v", v.name, err)
+		}
+	}
+}
+
+func TestStreamNonceEncoding(t *testing.T) {
+	v := streamTestVectors[0]
+	key, prefix := decode(v.key), decode(v.nonce)
+	c, err := NewAES(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range v.blocks {
+		// nonce prefix ‖ big endian counter ‖ last block flag
+		nonce := append(append([]byte{}, prefix...), 0, 0, 0, byte(i), 0)
+		if i == len(v.blocks)-1 {
+			nonce[StreamNonceSize-1] = 1
+		}
+		// The associated data is an S2V item even when empty
+		ct, _ := c.Seal(nil, decode(b.plaintext), decode(b.ad), nonce)
+		if !bytes.Equal(decode(b.ciphertext), ct) {
+			t.Errorf("Seal: %d: expected: %s\ngot: %x", i, b.ciphertext, ct)
+		}
+	}
+}
+
+func TestNonceEncoder(t *testing.T) {
+	prefix := decode("10111213 14151617")
+	n, err := NewNonceEncoder(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"10111213 14151617 00000000 00",
+		"10111213 14151617 00000001 00",
+	} {
+		nonce, err := n.Nonce(false)
+		if err != nil || !bytes.Equal(nonce, decode(want)) {
+			t.Errorf("Nonce: expected: %s\ngot: %x (%v)", want, nonce, err)
+		}
+		if err := n.Advance(false); err != nil {
+			t.Errorf("Advance: %s", err)
+		}
+	}
+	if n.Counter() != 2 {
+		t.Errorf("Counter: expected 2, got %d", n.Counter())
+	}
+
+	// The counter is big endian, carrying into the higher bytes, and the last
+	// segment is marked with a final byte of 1 as in the Miscreant spec
+	n.counter = 0x01020304 - 1
+	n.Advance(false)
+	if nonce, _ := n.Nonce(true); !bytes.Equal(nonce, decode("10111213 14151617 01020304 01")) {
+		t.Errorf("Nonce: last block: got %x", nonce)
+	}
+	if err := n.Advance(true); err != nil || !n.Finished() {
+		t.Errorf("Advance: last block: finished %v (%v)", n.Finished(), err)
+	}
+	if _, err := n.Nonce(true); err != ErrStreamFinished {
+		t.Errorf("Nonce: expected ErrStreamFinished, got %v", err)
+	}
+	if err := n.Advance(false); err != ErrStreamFinished {
+		t.Errorf("Advance: expected ErrStreamFinished, got %v", err)
+	}
+
+	// Only the last segment may use the maximum counter
+	n, _ = NewNonceEncoder(prefix)
+	n.counter = maxStreamCounter
+	if _, err := n.Nonce(false); err != ErrStreamCounterOverflow {
+		t.Errorf("Nonce: expected ErrStreamCounterOverflow, got %v", err)
+	}
+	if err := n.Advance(false); err != ErrStreamCounterOverflow || n.Counter() != maxStreamCounter {
+		t.Errorf("Advance: expected ErrStreamCounterOverflow, got %v at %d", err, n.Counter())
+	}
+	if nonce, err := n.Nonce(true); err != nil || !bytes.Equal(nonce, decode("10111213 14151617 ffffffff 01")) {
+		t.Errorf("Nonce: last block at maximum counter: %x (%v)", nonce, err)
+	}
+
+	if _, err := NewNonceEncoder(make([]byte, 12)); err != ErrStreamNoncePrefixSize {
+		t.Errorf("NewNonceEncoder: expected ErrStreamNoncePrefixSize, got %v", err)
+	}
+}
+
+func TestStreamReorder(t *testing.T) {
+	v := streamTestVectors[0]
+	key, nonce := decode(v.key), decode(v.nonce)
+	dec, err := NewStreamDecryptor(NewAEADAES, key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.Open(nil, decode(v.blocks[1].ciphertext), decode(v.blocks[1].ad), false); err != ErrNotAuthentic {
+		t.Errorf("Open: out of order segment: expected ErrNotAuthentic, got %v", err)
+	}
+	// A failed segment doesn't advance the stream
+	if _, err := dec.Open(nil, decode(v.blocks[0].ciphertext), nil, false); err != nil {
+		t.Errorf("Open: first segment: %s", err)
+	}
+	// The penultimate segment can't be passed off as the last one
+	if _, err := dec.Open(nil, decode(v.blocks[1].ciphertext), decode(v.blocks[1].ad), true); err != ErrNotAuthentic {
+		t.Errorf("Open: truncated stream: expected ErrNotAuthentic, got %v", err)
+	}
+}
+
+func TestStreamCounterOverflow(t *testing.T) {
+	enc, err := NewStreamEncryptor(NewAEADAES, make([]byte, 32), make([]byte, StreamNoncePrefixSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc.n.counter = maxStreamCounter - 1
+	if _, err := enc.Seal(nil, nil, nil, false); err != nil {
+		t.Fatalf("Seal: %s", err)
+	}
+	if _, err := enc.Seal(nil, nil, nil, false); err != ErrStreamCounterOverflow {
+		t.Errorf("Seal: expected ErrStreamCounterOverflow, got %v", err)
+	}
+	if _, err := enc.Seal(nil, nil, nil, true); err != nil {
+		t.Errorf("Seal: last block at maximum counter: %s", err)
+	}
+}
+
+func TestStreamNoncePrefixSize(t *testing.T) {
+	for _, n := range []int{0, 7, 9, 16} {
+		if _, err := NewStreamEncryptor(NewAEADAES, make([]byte, 32), make([]byte, n)); err != ErrStreamNoncePrefixSize {
+			t.Errorf("NewStreamEncryptor: %d byte prefix: expected ErrStreamNoncePrefixSize, got %v", n, err)
+		}
+		if _, err := NewStreamDecryptor(NewAEADAES, make([]byte, 32), make([]byte, n)); err != ErrStreamNoncePrefixSize {
+			t.Errorf("NewStreamDecryptor: %d byte prefix: expected ErrStreamNoncePrefixSize, got %v", n, err)
+		}
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	v := streamTestVectors[0]
+	key, nonce := decode(v.key), decode(v.nonce)
+	segments := make([][]byte, len(v.blocks))
+	for i, b := range v.blocks {
+		segments[i] = decode(b.ciphertext)
+	}
+	open := func(segments [][]byte) (finished bool, err error) {
+		dec, err := NewStreamDecryptor(NewAEADAES, key, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, ct := range segments {
+			ad := decode(v.blocks[i%len(v.blocks)].ad)
+			if _, err := dec.Open(nil, ct, ad, i == len(segments)-1); err != nil {
+				return dec.Finished(), err
+			}
+		}
+		return dec.Finished(), nil
+	}
+
+	if finished, err := open(segments); err != nil || !finished {
+		t.Fatalf("Open: untampered stream: %v (finished: %v)", err, finished)
+	}
+	// Dropping the final segment leaves the penultimate one to be opened as the
+	// last, which fails because it was sealed without the last block flag
+	if finished, err := open(segments[:2]); err != ErrNotAuthentic || finished {
+		t.Errorf("Open: truncated stream: expected ErrNotAuthentic, got %v (finished: %v)", err, finished)
+	}
+	if _, err := open([][]byte{segments[0], segments[2]}); err != ErrNotAuthentic {
+		t.Errorf("Open: dropped segment: expected ErrNotAuthentic, got %v", err)
+	}
+	if _, err := open([][]byte{segments[0], segments[0], segments[1], segments[2]}); err != ErrNotAuthentic {
+		t.Errorf("Open: duplicated segment: expected ErrNotAuthentic, got %v", err)
+	}
+	if _, err := open([][]byte{segments[1], segments[0], segments[2]}); err != ErrNotAuthentic {
+		t.Errorf("Open: reordered segments: expected ErrNotAuthentic, got %v", err)
+	}
+}
+
+func TestStreamSegmentAD(t *testing.T) {
+	for _, v := range streamTestVectors[4:] {
+		key, nonce := decode(v.key), decode(v.nonce)
+		ct1, ct2 := decode(v.blocks[1].ciphertext), decode(v.blocks[2].ciphertext)
+		ad1, ad2 := decode(v.blocks[1].ad), decode(v.blocks[2].ad)
+		for _, tt := range []struct {
+			name string
+			ct   []byte
+			ad   []byte
+		}{
+			{"other segment's associated data", ct1, ad2},
+			{"no associated data", ct1, nil},
+			{"swapped segments", ct2, ad2},
+		} {
+			dec, err := NewStreamDecryptor(v.newAEAD, key, nonce)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := dec.Open(nil, decode(v.blocks[0].ciphertext), nil, false); err != nil {
+				t.Fatalf("%s: Open: first segment: %s", v.name, err)
+			}
+			if _, err := dec.Open(nil, tt.ct, tt.ad, false); err != ErrNotAuthentic {
+				t.Errorf("%s: Open: %s: expected ErrNotAuthentic, got %v", v.name, tt.name, err)
+			}
+			// The segment still opens with its own associated data
+			if _, err := dec.Open(nil, ct1, ad1, false); err != nil {
+				t.Errorf("%s: Open: %s: second segment: %s", v.name, tt.name, err)
+			}
+		}
+	}
+}
+
+func TestStreamByName(t *testing.T) {
+	key, prefix := make([]byte, 32), make([]byte, StreamNoncePrefixSize)
+	segments := map[Algorithm][][]byte{}
+	for _, alg := range Algorithms() {
+		enc, err := NewStreamEncryptorByName(string(alg), key, prefix)
+		if err != nil {
+			t.Fatalf("NewStreamEncryptorByName: %s: %s", alg, err)
+		}
+		a, _ := NewAEADByName(string(alg), key, StreamNonceSize)
+		for i, last := range []bool{false, true} {
+			ct, err := enc.Seal(nil, []byte("segment"), []byte("ad"), last)
+			if err != nil {
+				t.Fatalf("Seal: %s: %s", alg, err)
+			}
+			// The nonces are those of NonceEncoder, whatever the algorithm
+			nonce := append(append([]byte(nil), prefix...), 0, 0, 0, byte(i), 0)
+			if last {
+				nonce[StreamNonceSize-1] = lastBlockFlag
+			}
+			if pt, err := a.Open(nil, nonce, ct, []byte("ad")); err != nil || string(pt) != "segment" {
+				t.Errorf("Open: %s: segment %d: %q (%v)", alg, i, pt, err)
+			}
+			segments[alg] = append(segments[alg], ct)
+		}
+	}
+
+	// A stream sealed with one algorithm doesn't open with the other
+	for _, tt := range []struct{ sealed, opened Algorithm }{
+		{AlgorithmAESPMACSIV, AlgorithmAESSIV},
+		{AlgorithmAESSIV, AlgorithmAESPMACSIV},
+	} {
+		dec, err := NewStreamDecryptorByName(string(tt.opened), key, prefix)
+		if err != nil {
+			t.Fatalf("NewStreamDecryptorByName: %s: %s", tt.opened, err)
+		}
+		if _, err := dec.Open(nil, segments[tt.sealed][0], []byte("ad"), false); err != ErrNotAuthentic {
+			t.Errorf("Open: %s stream with %s: expected ErrNotAuthentic, got %v", tt.sealed, tt.opened, err)
+		}
+	}
+
+	if _, err := NewStreamEncryptorByName("AES-GCM", key, prefix); err != UnknownAlgorithmError("AES-GCM") {
+		t.Errorf("NewStreamEncryptorByName: expected UnknownAlgorithmError, got %v", err)
+	}
+	if _, err := NewStreamDecryptorByName("AES-GCM", key, prefix); err != UnknownAlgorithmError("AES-GCM") {
+		t.Errorf("NewStreamDecryptorByName: expected UnknownAlgorithmError, got %v", err)
+	}
+}
+
+func TestStreamAEAD(t *testing.T) {
+	key, prefix := make([]byte, 32), make([]byte, StreamNoncePrefixSize)
+	want, _ := NewStreamEncryptor(NewAEADAES, key, prefix)
+
+	// The segments don't depend on the options the AEAD was made with
+	withOptions := func(key []byte, nonceSize int) (cipher.AEAD, error) {
+		return NewAEADAESWithOptions(key, nonceSize, AEADOptions{NonceOrder: NonceFirst, EmptyAD: OmitEmptyAD})
+	}
+	enc, err := NewStreamEncryptor(withOptions, key, prefix)
+	if err != nil {
+		t.Fatalf("NewStreamEncryptor: with options: %s", err)
+	}
+	for _, ad := range [][]byte{nil, {}, []byte("ad")} {
+		got, _ := enc.Seal(nil, []byte("segment"), ad, false)
+		if exp, _ := want.Seal(nil, []byte("segment"), ad, false); !bytes.Equal(got, exp) {
+			t.Errorf("Seal: with options: associated data %q: expected: %x\ngot: %x", ad, exp, got)
+		}
+	}
+
+	// AEADs which aren't this package's are rejected
+	wrapped := func(key []byte, nonceSize int) (cipher.AEAD, error) {
+		a, err := NewAEADAES(key, nonceSize)
+		return struct{ cipher.AEAD }{a}, err
+	}
+	deterministic := func(key []byte, nonceSize int) (cipher.AEAD, error) {
+		return NewAEADAES(key, 0)
+	}
+	for _, newAEAD := range []AEADConstructor{wrapped, deterministic} {
+		if _, err := NewStreamEncryptor(newAEAD, key, prefix); err != ErrStreamAEAD {
+			t.Errorf("NewStreamEncryptor: expected ErrStreamAEAD, got %v", err)
+		}
+		if _, err := NewStreamDecryptor(newAEAD, key, prefix); err != ErrStreamAEAD {
+			t.Errorf("NewStreamDecryptor: expected ErrStreamAEAD, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// MaxStreamChunkSize is the largest plaintext chunk size supported by
+// NewEncryptWriter and NewDecryptReader.
+const MaxStreamChunkSize = 1 << 24
+
+// streamFrameHeaderSize is the size of the big endian length prefix which
+// precedes each ciphertext chunk.
+const streamFrameHeaderSize = 4
+
+var ErrStreamChunkSize = errors.New("siv: invalid STREAM chunk size")
+
+// encryptWriter seals plaintext written to it in chunks using STREAM.
+type encryptWriter struct {
+	w         io.Writer
+	e         *StreamEncryptor
+	chunkSize int
+	buf       []byte // buffered plaintext of the current chunk
+	frame     []byte // length-prefixed ciphertext of the current chunk
+	err       error
+	pool      *ChunkPool // where buf and frame came from, if anywhere
+	bufs      *chunkBuffers
+}
+
+// NewEncryptWriter returns a writer which encrypts everything written to it
+// with AES-SIV in STREAM mode, using the given key and 8-byte nonce prefix,
+// and writes the ciphertext to w.
+//
+// Plaintext is split into chunks of chunkSize bytes, each of which is sealed
+// and written to w preceded by its 4-byte big endian length. Close must be
+// called to seal the final chunk, which is authenticated as the last one so
+// that truncation of the ciphertext is detected. Close does not close w.
+func NewEncryptWriter(key, noncePrefix []byte, chunkSize int, w io.Writer) (io.WriteCloser, error) {
+	if chunkSize <= 0 || chunkSize > MaxStreamChunkSize {
+		return nil, ErrStreamChunkSize
+	}
+	e, err := NewStreamEncryptor(NewAEADAES, key, noncePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamEncryptWriter(e, chunkSize, w)
+}
+
+// NewStreamEncryptWriter is like NewEncryptWriter, but seals each chunk with
+// e, so any algorithm can be used. Chunks are sealed with no associated data;
+// as each is sealed under the next STREAM counter, a reader can't be fed them
+// out of order. e must not be used by anything else while the writer is.
+func NewStreamEncryptWriter(e *StreamEncryptor, chunkSize int, w io.Writer) (io.WriteCloser, error) {
+	if chunkSize <= 0 || chunkSize > MaxStreamChunkSize {
+		return nil, ErrStreamChunkSize
+	}
+	return &encryptWriter{
+		w:         w,
+		e:         e,
+		chunkSize: chunkSize,
+		buf:       make([]byte, 0, chunkSize),
+		frame:     make([]byte, 0, streamFrameHeaderSize+chunkSize+e.Overhead()),
+	}, nil
+}
+
+// NewStreamEncryptWriterPool is like NewStreamEncryptWriter, with chunks of
+// the pool's chunk size, but takes the writer's buffers from pool and returns
+// them to it, zeroed, once Close is called or a write fails.
+func NewStreamEncryptWriterPool(e *StreamEncryptor, pool *ChunkPool, w io.Writer) io.WriteCloser {
+	b := pool.get()
+	return &encryptWriter{
+		w:         w,
+		e:         e,
+		chunkSize: pool.chunkSize,
+		buf:       b.plaintext[:0],
+		frame:     b.ciphertext[:0],
+		pool:      pool,
+		bufs:      b,
+	}
+}
+
+// Write buffers p, sealing and writing each chunk once it is known not to be
+// the last one.
+func (w *encryptWriter) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	for len(p) > 0 {
+		if len(w.buf) == w.chunkSize {
+			if err := w.flush(false); err != nil {
+				w.err = err
+				w.release()
+				return n, err
+			}
+		}
+		m := copy(w.buf[len(w.buf):w.chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Close seals and writes the last chunk, which may be empty.
+func (w *encryptWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	err := w.flush(true)
+	w.err = ErrStreamFinished
+	w.release()
+	return err
+}
+
+// release returns the buffers of a pooled writer to its pool.
+func (w *encryptWriter) release() {
+	if w.pool == nil {
+		return
+	}
+	w.bufs.plaintext, w.bufs.ciphertext = w.buf, w.frame
+	w.pool.put(w.bufs)
+	w.buf, w.frame, w.pool, w.bufs = nil, nil, nil, nil
+}
+
+func (w *encryptWriter) flush(lastBlock bool) error {
+	frame, err := w.e.Seal(w.frame[:streamFrameHeaderSize], w.buf, nil, lastBlock)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-streamFrameHeaderSize))
+	w.frame = frame[:0]
+	w.buf = w.buf[:0]
+	_, err = w.w.Write(frame)
+	return err
+}
+
+// decryptReader opens ciphertext produced by encryptWriter.
+type decryptReader struct {
+	r    io.Reader
+	d    *StreamDecryptor
+	hdr  [streamFrameHeaderSize]byte
+	cur  []byte // ciphertext of the chunk to be opened next
+	next []byte // ciphertext of the chunk following cur
+	buf  []byte // decrypted plaintext of the current chunk
+	pt   []byte // unread part of buf
+	err  error
+
+	// pool is where the buffers came from, if anywhere, and first is the
+	// buffer for the first chunk
+	pool  *ChunkPool
+	bufs  *chunkBuffers
+	first []byte
+}
+
+// NewDecryptReader returns a reader which decrypts ciphertext produced by a
+// writer returned by NewEncryptWriter with the same key and nonce prefix.
+//
+// Plaintext is only returned once the chunk containing it has been
+// authenticated. If the ciphertext ends before the last chunk, Read returns
+// io.ErrUnexpectedEOF or ErrNotAuthentic rather than io.EOF.
+func NewDecryptReader(key, noncePrefix []byte, r io.Reader) (io.Reader, error) {
+	d, err := NewStreamDecryptor(NewAEADAES, key, noncePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return NewStreamDecryptReader(d, r), nil
+}
+
+// NewStreamDecryptReader is like NewDecryptReader, but opens each chunk with
+// d, which must match the StreamEncryptor given to NewStreamEncryptWriter.
+// d must not be used by anything else while the reader is.
+func NewStreamDecryptReader(d *StreamDecryptor, r io.Reader) io.Reader {
+	return &decryptReader{r: r, d: d}
+}
+
+// NewStreamDecryptReaderPool is like NewStreamDecryptReader, but takes the
+// reader's buffers from pool and returns them to it, zeroed, once Read has
+// returned an error, including io.EOF. The buffers of a reader which is
+// abandoned before then are left to the garbage collector.
+func NewStreamDecryptReaderPool(d *StreamDecryptor, pool *ChunkPool, r io.Reader) io.Reader {
+	b := pool.get()
+	return &decryptReader{
+		r:     r,
+		d:     d,
+		first: b.ciphertext[:0],
+		next:  b.next[:0],
+		buf:   b.plaintext[:0],
+		pool:  pool,
+		bufs:  b,
+	}
+}
+
+func (r *decryptReader) Read(p []byte) (n int, err error) {
+	for len(r.pt) == 0 {
+		if r.err != nil {
+			r.release()
+			return 0, r.err
+		}
+		r.err = r.openChunk()
+	}
+	n = copy(p, r.pt)
+	r.pt = r.pt[n:]
+	return n, nil
+}
+
+// openChunk opens the next chunk into buf. A chunk is the last one if no
+// further chunk follows it.
+func (r *decryptReader) openChunk() error {
+	if r.d.Finished() {
+		return io.EOF
+	}
+	if r.cur == nil {
+		ct, err := r.readFrame(r.first)
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		r.cur = ct
+	}
+
+	next, err := r.readFrame(r.next)
+	lastBlock := err == io.EOF
+	if err != nil && !lastBlock {
+		return err
+	}
+
+	// Open returns nil on failure, and r.buf must keep its buffer
+	pt, err := r.d.Open(r.buf[:0], r.cur, nil, lastBlock)
+	if err != nil {
+		return err
+	}
+	r.buf, r.pt = pt, pt
+	if lastBlock {
+		// Keep the buffer next would have been read into, to be released
+		r.first = r.next
+	}
+	r.cur, r.next = next, r.cur
+	return nil
+}
+
+// readFrame reads a length-prefixed chunk into buf, returning io.EOF only if
+// the stream ends cleanly before the length prefix.
+func (r *decryptReader) readFrame(buf []byte) ([]byte, error) {
+	if _, err := io.ReadFull(r.r, r.hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(r.hdr[:])
+	if n < uint32(r.d.Overhead()) || n > uint32(MaxStreamChunkSize+r.d.Overhead()) {
+		return nil, ErrStreamChunkSize
+	}
+	if cap(buf) < int(n) {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// SealStream reads plaintext from r until io.EOF and writes it to w sealed
+// with e, as a writer returned by NewStreamEncryptWriter would, so that it
+// can be opened with OpenStream or a reader returned by
+// NewStreamDecryptReader.
+//
+// ctx is checked before each chunk is read, and once it is done SealStream
+// returns ctx.Err() without sealing the last chunk, so what has been written
+// to w is rejected as truncated. Either way, the buffered plaintext is
+// overwritten with zeros before SealStream returns.
+func SealStream(ctx context.Context, e *StreamEncryptor, chunkSize int, w io.Writer, r io.Reader) error {
+	sw, err := NewStreamEncryptWriter(e, chunkSize, w)
+	if err != nil {
+		return err
+	}
+	ew := sw.(*encryptWriter)
+	buf := make([]byte, chunkSize)
+	defer func() {
+		zero(buf)
+		ew.wipe()
+	}()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := io.ReadFull(r, buf)
+		if _, werr := ew.Write(buf[:n]); werr != nil {
+			return werr
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return ew.Close()
+}
+
+// OpenStream reads ciphertext produced by SealStream, or by a writer returned
+// by NewStreamEncryptWriter, from r, opens it with d and writes the plaintext
+// to w. As with a reader returned by NewStreamDecryptReader, each chunk is
+// only written once it has been authenticated, and a truncated stream is an
+// error.
+//
+// ctx is checked before each chunk is opened, and once it is done OpenStream
+// returns ctx.Err(). Either way, the decrypted plaintext is overwritten with
+// zeros before OpenStream returns.
+func OpenStream(ctx context.Context, d *StreamDecryptor, w io.Writer, r io.Reader) error {
+	dr := NewStreamDecryptReader(d, r).(*decryptReader)
+	defer dr.wipe()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch err := dr.openChunk(); err {
+		case nil:
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+		if _, err := w.Write(dr.pt); err != nil {
+			return err
+		}
+	}
+}
+
+// wipe zeroes the buffered plaintext and ciphertext of the current chunk.
+func (w *encryptWriter) wipe() {
+	zero(w.buf[:cap(w.buf)])
+	zero(w.frame[:cap(w.frame)])
+}
+
+// wipe zeroes the decrypted plaintext of the current chunk.
+func (r *decryptReader) wipe() {
+	zero(r.buf[:cap(r.buf)])
+	r.pt = nil
+}
+
+// release returns the buffers of a pooled reader to its pool.
+func (r *decryptReader) release() {
+	if r.pool == nil {
+		return
+	}
+	ct := r.cur
+	if ct == nil {
+		ct = r.first
+	}
+	r.bufs.plaintext, r.bufs.ciphertext, r.bufs.next = r.buf, ct, r.next
+	r.pool.put(r.bufs)
+	r.cur, r.next, r.buf, r.pt, r.first, r.pool, r.bufs = nil, nil, nil, nil, nil, nil, nil
+}
+
+// chunkSlack is the room ChunkPool leaves in its ciphertext buffers for the
+// frame header and the overhead of the AEAD.
+const chunkSlack = streamFrameHeaderSize + 64
+
+// ChunkPool is a pool of the chunk buffers of STREAM writers and readers, for
+// programs handling many streams, each of which would otherwise allocate
+// buffers of a few times the chunk size. Buffers are zeroed before they are
+// returned to the pool, so none of them hold plaintext or ciphertext while
+// pooled. A ChunkPool may be used by multiple goroutines at once.
+type ChunkPool struct {
+	chunkSize int
+	pool      sync.Pool
+
+	// onPut, if set, is called with each set of buffers put in the pool
+	onPut func(b *chunkBuffers)
+}
+
+// chunkBuffers are the buffers of one writer or reader.
+type chunkBuffers struct {
+	plaintext, ciphertext, next []byte
+}
+
+// NewChunkPool returns a ChunkPool for chunks of chunkSize bytes, which must
+// be from 1 to MaxStreamChunkSize.
+func NewChunkPool(chunkSize int) (*ChunkPool, error) {
+	if chunkSize <= 0 || chunkSize > MaxStreamChunkSize {
+		return nil, ErrStreamChunkSize
+	}
+	p := &ChunkPool{chunkSize: chunkSize}
+	p.pool.New = func() interface{} {
+		return &chunkBuffers{
+			plaintext:  make([]byte, 0, chunkSize+chunkSlack),
+			ciphertext: make([]byte, 0, chunkSize+chunkSlack),
+			next:       make([]byte, 0, chunkSize+chunkSlack),
+		}
+	}
+	return p, nil
+}
+
+// ChunkSize returns the chunk size of the writers and readers using p.
+func (p *ChunkPool) ChunkSize() int { return p.chunkSize }
+
+func (p *ChunkPool) get() *chunkBuffers {
+	return p.pool.Get().(*chunkBuffers)
+}
+
+// put zeroes b and returns it to the pool, replacing any buffer too small
+// for a chunk.
+func (p *ChunkPool) put(b *chunkBuffers) {
+	for _, buf := range []*[]byte{&b.plaintext, &b.ciphertext, &b.next} {
+		zero((*buf)[:cap(*buf)])
+		if cap(*buf) < p.chunkSize+chunkSlack {
+			*buf = make([]byte, 0, p.chunkSize+chunkSlack)
+		}
+	}
+	if p.onPut != nil {
+		p.onPut(b)
+	}
+	p.pool.Put(b)
+}
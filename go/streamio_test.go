@@ -0,0 +1,395 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+package miscreant
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"testing/iotest"
+)
+
+func encryptChunks(t *testing.T, key, nonce []byte, chunkSize int, pt []byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(key, nonce, chunkSize, &buf)
+	if err != nil {
+		t.Fatalf("NewEncryptWriter: %s", err)
+	}
+	// Write in uneven pieces to exercise chunk buffering
+	for p := pt; len(p) > 0; {
+		n := 7
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncryptWriterRoundTrip(t *testing.T) {
+	key, nonce := make([]byte, 32), make([]byte, StreamNoncePrefixSize)
+	for _, chunkSize := range []int{1, 16, 100} {
+		for _, n := range []int{0, 1, 15, 16, 17, 99, 100, 101, 200, 1000} {
+			pt := make([]byte, n)
+			for i := range pt {
+				pt[i] = byte(i)
+			}
+			ct := encryptChunks(t, key, nonce, chunkSize, pt)
+
+			chunks := (n + chunkSize - 1) / chunkSize
+			if chunks == 0 {
+				chunks = 1
+			}
+			if want := n + chunks*(streamFrameHeaderSize+16); len(ct) != want {
+				t.Errorf("chunk %d, length %d: expected %d bytes of ciphertext, got %d", chunkSize, n, want, len(ct))
+			}
+
+			r, err := NewDecryptReader(key, nonce, iotest.OneByteReader(bytes.NewReader(ct)))
+			if err != nil {
+				t.Fatalf("NewDecryptReader: %s", err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("chunk %d, length %d: ReadAll: %s", chunkSize, n, err)
+			}
+			if !bytes.Equal(got, pt) {
+				t.Errorf("chunk %d, length %d: expected: %x\ngot: %x", chunkSize, n, pt, got)
+			}
+		}
+	}
+}
+
+func TestEncryptWriterZeroLengthFinalChunk(t *testing.T) {
+	key, nonce := make([]byte, 32), make([]byte, StreamNoncePrefixSize)
+
+	// An empty plaintext is sealed as a single empty last chunk
+	ct := encryptChunks(t, key, nonce, 16, nil)
+	if n := binary.BigEndian.Uint32(ct); n != 16 || len(ct) != streamFrameHeaderSize+16 {
+		t.Errorf("expected a single empty chunk, got %x", ct)
+	}
+
+	// Other writers may end a stream with an empty last chunk after a full one
+	e, err := NewStreamEncryptor(NewAEADAES, key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt := make([]byte, 32)
+	var buf []byte
+	for i, chunk := range [][]byte{pt[:16], pt[16:], nil} {
+		buf = append(buf, 0, 0, 0, 0)
+		buf, err = e.Seal(buf, chunk, nil, i == 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		binary.BigEndian.PutUint32(buf[len(buf)-len(chunk)-16-streamFrameHeaderSize:], uint32(len(chunk)+16))
+	}
+	r, err := NewDecryptReader(key, nonce, bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if !bytes.Equal(got, pt) {
+		t.Errorf("expected: %x\ngot: %x", pt, got)
+	}
+}
+
+func TestDecryptReaderTampering(t *testing.T) {
+	key, nonce := make([]byte, 32), make([]byte, StreamNoncePrefixSize)
+	// Three chunks, the last of which is 8 bytes
+	ct := encryptChunks(t, key, nonce, 16, make([]byte, 40))
+	frame := streamFrameHeaderSize + 16 + 16
+
+	tampered := append([]byte{}, ct...)
+	tampered[frame+streamFrameHeaderSize+20] ^= 1
+
+	for _, tt := range []struct {
+		name string
+		ct   []byte
+		err  error
+	}{
+		{"empty", nil, io.ErrUnexpectedEOF},
+		{"tampered middle chunk", tampered, ErrNotAuthentic},
+		{"dropped final chunk", ct[:2*frame], ErrNotAuthentic},
+		{"truncated final chunk", ct[:len(ct)-1], io.ErrUnexpectedEOF},
+		{"truncated length prefix", ct[:2*frame+2], io.ErrUnexpectedEOF},
+		{"dropped middle chunk", append(append([]byte{}, ct[:frame]...), ct[2*frame:]...), ErrNotAuthentic},
+		{"reordered chunks", append(append(append([]byte{}, ct[frame:2*frame]...), ct[:frame]...), ct[2*frame:]...), ErrNotAuthentic},
+	} {
+		r, err := NewDecryptReader(key, nonce, bytes.NewReader(tt.ct))
+		if err != nil {
+			t.Fatalf("NewDecryptReader: %s", err)
+		}
+		if _, err := ioutil.ReadAll(r); err != tt.err {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestStreamEncryptWriterPipe(t *testing.T) {
+	key, nonce := make([]byte, 32), make([]byte, StreamNoncePrefixSize)
+	pt := make([]byte, 10000)
+	for i := range pt {
+		pt[i] = byte(i * 7)
+	}
+	for _, newAEAD := range []AEADConstructor{NewAEADAES, NewAEADAESPMACSIV} {
+		e, _ := NewStreamEncryptor(newAEAD, key, nonce)
+		d, _ := NewStreamDecryptor(newAEAD, key, nonce)
+
+		// Both ends of a connection, with nothing buffered between them
+		client, server := net.Pipe()
+		errc := make(chan error, 1)
+		go func() {
+			w, err := NewStreamEncryptWriter(e, 1000, client)
+			if err == nil {
+				_, err = io.Copy(w, bytes.NewReader(pt))
+			}
+			if err == nil {
+				err = w.Close()
+			}
+			client.Close()
+			errc <- err
+		}()
+		var got bytes.Buffer
+		if _, err := io.Copy(&got, NewStreamDecryptReader(d, server)); err != nil {
+			t.Fatalf("Read: %s", err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+		if !bytes.Equal(got.Bytes(), pt) {
+			t.Errorf("expected: %x\ngot: %x", pt, got.Bytes())
+		}
+	}
+}
+
+func TestEncryptWriterChunkSize(t *testing.T) {
+	for _, n := range []int{-1, 0, MaxStreamChunkSize + 1} {
+		if _, err := NewEncryptWriter(make([]byte, 32), make([]byte, StreamNoncePrefixSize), n, ioutil.Discard); err != ErrStreamChunkSize {
+			t.Errorf("NewEncryptWriter: chunk size %d: expected ErrStreamChunkSize, got %v", n, err)
+		}
+	}
+}
+
+// writerFunc is an io.Writer calling a function for each write.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestSealOpenStream(t *testing.T) {
+	key, nonce := make([]byte, 32), make([]byte, StreamNoncePrefixSize)
+	pt := bytes.Repeat([]byte("0123456789"), 10)
+	for _, n := range []int{0, 1, 16, 32, len(pt)} {
+		var ct bytes.Buffer
+		e, _ := NewStreamEncryptor(NewAEADAES, key, nonce)
+		if err := SealStream(context.Background(), e, 16, &ct, bytes.NewReader(pt[:n])); err != nil {
+			t.Fatalf("SealStream: %d bytes: %s", n, err)
+		}
+		if want := encryptChunks(t, key, nonce, 16, pt[:n]); !bytes.Equal(ct.Bytes(), want) {
+			t.Errorf("SealStream: %d bytes: expected: %x\ngot: %x", n, want, ct.Bytes())
+		}
+
+		var out bytes.Buffer
+		d, _ := NewStreamDecryptor(NewAEADAES, key, nonce)
+		if err := OpenStream(context.Background(), d, &out, bytes.NewReader(ct.Bytes())); err != nil || !bytes.Equal(out.Bytes(), pt[:n]) {
+			t.Errorf("OpenStream: %d bytes: %q (%v)", n, out.Bytes(), err)
+		}
+		if n > 0 {
+			d, _ = NewStreamDecryptor(NewAEADAES, key, nonce)
+			if err := OpenStream(context.Background(), d, ioutil.Discard, bytes.NewReader(ct.Bytes()[:ct.Len()-1])); err != io.ErrUnexpectedEOF {
+				t.Errorf("OpenStream: %d bytes: truncated: expected io.ErrUnexpectedEOF, got %v", n, err)
+			}
+		}
+	}
+}
+
+func TestSealOpenStreamCancel(t *testing.T) {
+	key, nonce := make([]byte, 32), make([]byte, StreamNoncePrefixSize)
+	pt := bytes.Repeat([]byte("0123456789"), 10)
+
+	// Cancelled once the first segment has been written
+	ctx, cancel := context.WithCancel(context.Background())
+	var ct bytes.Buffer
+	w := writerFunc(func(p []byte) (int, error) {
+		cancel()
+		return ct.Write(p)
+	})
+	e, _ := NewStreamEncryptor(NewAEADAES, key, nonce)
+	if err := SealStream(ctx, e, 16, w, bytes.NewReader(pt)); err != context.Canceled {
+		t.Fatalf("SealStream: expected context.Canceled, got %v", err)
+	}
+	if ct.Len() != streamFrameHeaderSize+16+e.Overhead() {
+		t.Errorf("SealStream: expected one segment, got %d bytes", ct.Len())
+	}
+	// What was written is rejected as truncated
+	d, _ := NewStreamDecryptor(NewAEADAES, key, nonce)
+	if err := OpenStream(context.Background(), d, ioutil.Discard, &ct); err == nil {
+		t.Errorf("OpenStream: cancelled stream opened")
+	}
+
+	full := encryptChunks(t, key, nonce, 16, pt)
+	ctx, cancel = context.WithCancel(context.Background())
+	var out bytes.Buffer
+	w = writerFunc(func(p []byte) (int, error) {
+		cancel()
+		return out.Write(p)
+	})
+	d, _ = NewStreamDecryptor(NewAEADAES, key, nonce)
+	if err := OpenStream(ctx, d, w, bytes.NewReader(full)); err != context.Canceled {
+		t.Fatalf("OpenStream: expected context.Canceled, got %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), pt[:16]) {
+		t.Errorf("OpenStream: expected the first segment, got %q", out.Bytes())
+	}
+
+	// Nothing is done with a context which is already done
+	e, _ = NewStreamEncryptor(NewAEADAES, key, nonce)
+	ct.Reset()
+	if err := SealStream(ctx, e, 16, &ct, bytes.NewReader(pt)); err != context.Canceled || ct.Len() != 0 {
+		t.Errorf("SealStream: done context: expected context.Canceled and no output, got %v, %d bytes", err, ct.Len())
+	}
+}
+
+func TestStreamWipe(t *testing.T) {
+	key, nonce := make([]byte, 32), make([]byte, StreamNoncePrefixSize)
+	e, _ := NewStreamEncryptor(NewAEADAES, key, nonce)
+	sw, _ := NewStreamEncryptWriter(e, 16, ioutil.Discard)
+	ew := sw.(*encryptWriter)
+	ew.Write([]byte("secret"))
+	ew.wipe()
+	if b := ew.buf[:cap(ew.buf)]; !bytes.Equal(b, make([]byte, len(b))) {
+		t.Errorf("wipe: plaintext left: %q", b)
+	}
+
+	d, _ := NewStreamDecryptor(NewAEADAES, key, nonce)
+	dr := NewStreamDecryptReader(d, bytes.NewReader(encryptChunks(t, key, nonce, 16, []byte("secret")))).(*decryptReader)
+	if err := dr.openChunk(); err != nil {
+		t.Fatal(err)
+	}
+	dr.wipe()
+	if b := dr.buf[:cap(dr.buf)]; !bytes.Equal(b, make([]byte, len(b))) || dr.pt != nil {
+		t.Errorf("wipe: plaintext left: %q", b)
+	}
+}
+
+func TestChunkPool(t *testing.T) {
+	key, nonce := make([]byte, 32), make([]byte, StreamNoncePrefixSize)
+	pool, err := NewChunkPool(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var put []*chunkBuffers
+	pool.onPut = func(b *chunkBuffers) { put = append(put, b) }
+
+	pt := bytes.Repeat([]byte("secret!"), 10)
+	for i := 0; i < 3; i++ {
+		put = nil
+		var buf bytes.Buffer
+		e, _ := NewStreamEncryptor(NewAEADAES, key, nonce)
+		w := NewStreamEncryptWriterPool(e, pool, &buf)
+		w.Write(pt)
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %s", err)
+		}
+		if !bytes.Equal(buf.Bytes(), encryptChunks(t, key, nonce, 16, pt)) {
+			t.Fatalf("%d: pooled writer differs from NewEncryptWriter", i)
+		}
+
+		d, _ := NewStreamDecryptor(NewAEADAES, key, nonce)
+		got, err := ioutil.ReadAll(NewStreamDecryptReaderPool(d, pool, iotest.OneByteReader(&buf)))
+		if err != nil || !bytes.Equal(got, pt) {
+			t.Fatalf("%d: pooled reader: %q (%v)", i, got, err)
+		}
+
+		// Both the writer and the reader return their buffers, wiped
+		if len(put) != 2 {
+			t.Fatalf("%d: expected 2 sets of buffers returned, got %d", i, len(put))
+		}
+		for _, b := range put {
+			for _, s := range [][]byte{b.plaintext, b.ciphertext, b.next} {
+				if s := s[:cap(s)]; !bytes.Equal(s, make([]byte, len(s))) {
+					t.Errorf("%d: pooled buffer not wiped: %q", i, s)
+				}
+			}
+		}
+	}
+
+	// A writer whose write fails also returns its buffers
+	put = nil
+	e, _ := NewStreamEncryptor(NewAEADAES, key, nonce)
+	w := NewStreamEncryptWriterPool(e, pool, writerFunc(func(p []byte) (int, error) { return 0, io.ErrClosedPipe }))
+	if _, err := w.Write(pt); err != io.ErrClosedPipe || len(put) != 1 {
+		t.Errorf("Write: expected io.ErrClosedPipe and buffers returned, got %v (%d)", err, len(put))
+	}
+	if err := w.Close(); err != io.ErrClosedPipe || len(put) != 1 {
+		t.Errorf("Close: expected io.ErrClosedPipe and no second return, got %v (%d)", err, len(put))
+	}
+
+	// A reader which fails to authenticate returns usable buffers
+	for i := 0; i < 3; i++ {
+		ct := encryptChunks(t, key, nonce, 16, pt)
+		ct[len(ct)-1] ^= 1
+		d, _ := NewStreamDecryptor(NewAEADAES, key, nonce)
+		if _, err := ioutil.ReadAll(NewStreamDecryptReaderPool(d, pool, bytes.NewReader(ct))); err != ErrNotAuthentic {
+			t.Fatalf("pooled reader: tampered: expected ErrNotAuthentic, got %v", err)
+		}
+		var buf bytes.Buffer
+		e, _ := NewStreamEncryptor(NewAEADAES, key, nonce)
+		w := NewStreamEncryptWriterPool(e, pool, &buf)
+		if _, err := w.Write(pt); err != nil {
+			t.Fatalf("pooled writer after a failed reader: %s", err)
+		}
+		if err := w.Close(); err != nil || !bytes.Equal(buf.Bytes(), encryptChunks(t, key, nonce, 16, pt)) {
+			t.Fatalf("pooled writer after a failed reader: %v", err)
+		}
+	}
+
+	for _, n := range []int{0, -1, MaxStreamChunkSize + 1} {
+		if _, err := NewChunkPool(n); err != ErrStreamChunkSize {
+			t.Errorf("NewChunkPool: %d: expected ErrStreamChunkSize, got %v", n, err)
+		}
+	}
+}
+
+func BenchmarkStreamWriter(b *testing.B) {
+	key, nonce := make([]byte, 32), make([]byte, StreamNoncePrefixSize)
+	pt := make([]byte, 4096)
+	pool, _ := NewChunkPool(len(pt))
+	for _, bm := range []struct {
+		name string
+		new  func(e *StreamEncryptor) io.WriteCloser
+	}{
+		{"Default", func(e *StreamEncryptor) io.WriteCloser {
+			w, _ := NewStreamEncryptWriter(e, len(pt), ioutil.Discard)
+			return w
+		}},
+		{"Pool", func(e *StreamEncryptor) io.WriteCloser {
+			return NewStreamEncryptWriterPool(e, pool, ioutil.Discard)
+		}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			e0, _ := NewStreamEncryptor(NewAEADAES, key, nonce)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(pt)))
+			for i := 0; i < b.N; i++ {
+				// A new stream each time, as a server would for each request
+				e := *e0
+				w := bm.new(&e)
+				w.Write(pt)
+				w.Close()
+			}
+		})
+	}
+}
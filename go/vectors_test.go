@@ -0,0 +1,310 @@
+// Copyright (c) 2017 The Miscreant Developers. See LICENSE.txt for licensing information.
+
+// Tests against the cross-language test vectors in the vectors directory,
+// and the examples generated by this package in testdata, which are written
+// in TJSON (https://www.tjson.org/).
+
+package miscreant
+
+import (
+	"bytes"
+	"crypto/aes"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/miscreant/miscreant/go/internal/tjson"
+	"github.com/miscreant/miscreant/go/pmac"
+)
+
+// loadExamples returns the examples in the named file in the vectors directory.
+func loadExamples(t *testing.T, file string) []map[string]interface{} {
+	return loadExampleFile(t, filepath.Join("..", "vectors", file))
+}
+
+// loadExampleFile returns the examples in a TJSON file.
+func loadExampleFile(t *testing.T, file string) []map[string]interface{} {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := tjson.Parse(data)
+	if err != nil {
+		t.Fatalf("%s: %s", file, err)
+	}
+	a, ok := doc["examples"].([]interface{})
+	if !ok {
+		t.Fatalf("%s: no examples", file)
+	}
+	examples := make([]map[string]interface{}, len(a))
+	for i, x := range a {
+		if examples[i], ok = x.(map[string]interface{}); !ok {
+			t.Fatalf("%s: example %d is not an object", file, i)
+		}
+	}
+	return examples
+}
+
+// exampleName identifies an example in failure messages by its index, and
+// by its name if it has one.
+func exampleName(file string, i int, ex map[string]interface{}) string {
+	if name, ok := ex["name"].(string); ok {
+		return fmt.Sprintf("%s: example %d (%q)", file, i, name)
+	}
+	return fmt.Sprintf("%s: example %d", file, i)
+}
+
+// TestSIVVectors checks the shared AES-SIV and AES-PMAC-SIV vectors, and the
+// files of the same name in testdata, which were generated by this package
+// and cover empty plaintexts with associated data and the EmptyADEncoding
+// choices.
+func TestSIVVectors(t *testing.T) {
+	for _, tc := range []struct {
+		file      string
+		alg       Algorithm
+		newCipher func([]byte) (*Cipher, error)
+		newAEAD   AEADConstructor
+	}{
+		{"aes_siv.tjson", AlgorithmAESSIV, NewAES, NewAEADAES},
+		{"aes_pmac_siv.tjson", AlgorithmAESPMACSIV, NewPMACSIV, NewAEADAESPMACSIV},
+	} {
+		examples := loadExamples(t, tc.file)
+		examples = append(examples, loadExampleFile(t, filepath.Join("testdata", tc.file))...)
+		for i, ex := range examples {
+			name := exampleName(tc.file, i, ex)
+			key, _ := ex["key"].([]byte)
+			ad, _ := ex["ad"].([][]byte)
+			gpt, _ := ex["plaintext"].([]byte)
+			gct, _ := ex["ciphertext"].([]byte)
+
+			// Every associated data item as a separate S2V input
+			c, err := tc.newCipher(key)
+			if err != nil {
+				t.Errorf("%s: NewCipher: %s", name, err)
+				continue
+			}
+			if c.Algorithm() != tc.alg {
+				t.Errorf("%s: Algorithm: expected %q, got %q", name, tc.alg, c.Algorithm())
+			}
+			ct, err := c.Seal(nil, gpt, ad...)
+			if err != nil || !bytes.Equal(gct, ct) {
+				t.Errorf("%s: Seal: expected: %x\ngot: %x (%v)", name, gct, ct, err)
+			}
+			pt, err := c.Open(nil, gct, ad...)
+			if err != nil || !bytes.Equal(gpt, pt) {
+				t.Errorf("%s: Open: expected: %x\ngot: %x (%v)", name, gpt, pt, err)
+			}
+			if err == nil && pt == nil {
+				t.Errorf("%s: Open: empty plaintext returned as nil", name)
+			}
+			if err := c.Verify(gct, ad...); err != nil {
+				t.Errorf("%s: Verify: %s", name, err)
+			}
+
+			// The detached tag is the synthetic IV at the start
+			body, tag, err := c.SealDetached(nil, gpt, ad...)
+			if err != nil || !bytes.Equal(gct[:TagSize], tag[:]) || !bytes.Equal(gct[TagSize:], body) {
+				t.Errorf("%s: SealDetached: expected: %x\ngot: %x %x (%v)", name, gct, tag, body, err)
+			}
+			pt, err = c.OpenDetached(nil, gct[TagSize:], gct[:TagSize], ad...)
+			if err != nil || !bytes.Equal(gpt, pt) {
+				t.Errorf("%s: OpenDetached: expected: %x\ngot: %x (%v)", name, gpt, pt, err)
+			}
+
+			// Associated data written one byte at a time
+			w := c.NewAssociatedData()
+			for _, item := range ad {
+				for i := range item {
+					w.Write(item[i : i+1])
+				}
+				w.Next()
+			}
+			if ct, err := w.Seal(nil, gpt); err != nil || !bytes.Equal(gct, ct) {
+				t.Errorf("%s: AssociatedData Seal: expected: %x\ngot: %x (%v)", name, gct, ct, err)
+			}
+
+			// The AEAD interface takes at most one item and a nonce, and
+			// passes non-nil empty associated data as an empty first item
+			var data, nonce []byte
+			switch len(ad) {
+			case 0:
+			case 1:
+				data = ad[0]
+			case 2:
+				data, nonce = append([]byte{}, ad[0]...), ad[1]
+			default:
+				continue
+			}
+			a, err := tc.newAEAD(key, len(nonce))
+			if err != nil {
+				t.Errorf("%s: newAEAD: %s", name, err)
+				continue
+			}
+			if ct := a.Seal(nil, nonce, gpt, data); !bytes.Equal(gct, ct) {
+				t.Errorf("%s: AEAD Seal: expected: %x\ngot: %x", name, gct, ct)
+			}
+			pt, err = a.Open(nil, nonce, gct, data)
+			if err != nil || !bytes.Equal(gpt, pt) {
+				t.Errorf("%s: AEAD Open: expected: %x\ngot: %x (%v)", name, gpt, pt, err)
+			}
+			a, err = NewAEADByName(string(tc.alg), key, len(nonce))
+			if err != nil {
+				t.Errorf("%s: NewAEADByName: %s", name, err)
+				continue
+			}
+			if ct := a.Seal(nil, nonce, gpt, data); !bytes.Equal(gct, ct) {
+				t.Errorf("%s: NewAEADByName Seal: expected: %x\ngot: %x", name, gct, ct)
+			}
+		}
+	}
+}
+
+// TestCTRVectors checks xorKeyStream, the CTR mode used for short messages
+// and for block ciphers other than crypto/aes.
+func TestCTRVectors(t *testing.T) {
+	const file = "aes_ctr.tjson"
+	for i, ex := range loadExamples(t, file) {
+		name := exampleName(file, i, ex)
+		key, _ := ex["key"].([]byte)
+		iv, _ := ex["iv"].([]byte)
+		gpt, _ := ex["plaintext"].([]byte)
+		gct, _ := ex["ciphertext"].([]byte)
+		b, err := aes.NewCipher(key)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		ct := make([]byte, len(gpt))
+		newState(nil, aes.BlockSize).xorKeyStream(b, ct, gpt, iv)
+		if !bytes.Equal(gct, ct) {
+			t.Errorf("%s: expected: %x\ngot: %x", name, gct, ct)
+		}
+	}
+}
+
+// TestStreamVectors replays each stream in testdata/aes_siv_stream.tjson
+// with both the STREAM decryptor and a NonceEncoder driving the Cipher directly.
+// The streams were generated by this package, and no other implementation has
+// checked them, so they aren't among the shared vectors.
+func TestStreamVectors(t *testing.T) {
+	const file = "aes_siv_stream.tjson"
+	newCiphers := map[string]func([]byte) (*Cipher, error){
+		"AES-SIV":      NewAES,
+		"AES-PMAC-SIV": NewPMACSIV,
+	}
+	for i, ex := range loadExampleFile(t, filepath.Join("testdata", file)) {
+		name := exampleName(file, i, ex)
+		alg, _ := ex["alg"].(string)
+		newCipher, ok := newCiphers[alg]
+		if !ok {
+			t.Errorf("%s: unknown algorithm %q", name, alg)
+			continue
+		}
+		key, _ := ex["key"].([]byte)
+		prefix, _ := ex["nonce"].([]byte)
+		blocks, _ := ex["blocks"].([]interface{})
+
+		enc, err := NewStreamEncryptorByName(alg, key, prefix)
+		if err != nil {
+			t.Fatalf("%s: NewStreamEncryptorByName: %s", name, err)
+		}
+		dec, err := NewStreamDecryptorByName(alg, key, prefix)
+		if err != nil {
+			t.Fatalf("%s: NewStreamDecryptorByName: %s", name, err)
+		}
+		c, err := newCipher(key)
+		if err != nil {
+			t.Fatalf("%s: new Cipher: %s", name, err)
+		}
+		n, err := NewNonceEncoder(prefix)
+		if err != nil {
+			t.Fatalf("%s: NewNonceEncoder: %s", name, err)
+		}
+		for j, x := range blocks {
+			b, _ := x.(map[string]interface{})
+			ad, _ := b["ad"].([]byte)
+			gpt, _ := b["plaintext"].([]byte)
+			gct, _ := b["ciphertext"].([]byte)
+			last := j == len(blocks)-1
+
+			ct, err := enc.Seal(nil, gpt, ad, last)
+			if err != nil || !bytes.Equal(gct, ct) {
+				t.Errorf("%s: segment %d: Seal: expected: %x\ngot: %x (%v)", name, j, gct, ct, err)
+			}
+			pt, err := dec.Open(nil, gct, ad, last)
+			if err != nil || !bytes.Equal(gpt, pt) {
+				t.Errorf("%s: segment %d: Open: expected: %x\ngot: %x (%v)", name, j, gpt, pt, err)
+			}
+
+			nonce, err := n.Nonce(last)
+			if err != nil {
+				t.Fatalf("%s: segment %d: Nonce: %s", name, j, err)
+			}
+			pt, err = c.Open(nil, gct, ad, nonce)
+			if err != nil || !bytes.Equal(gpt, pt) {
+				t.Errorf("%s: segment %d: Cipher Open: expected: %x\ngot: %x (%v)", name, j, gpt, pt, err)
+			}
+			n.Advance(last)
+		}
+		if !dec.Finished() || !n.Finished() {
+			t.Errorf("%s: stream not finished", name)
+		}
+	}
+}
+
+func TestMACVectors(t *testing.T) {
+	for _, tc := range []struct {
+		file   string
+		newMAC func([]byte) (hash.Hash, error)
+	}{
+		{"aes_cmac.tjson", func(key []byte) (hash.Hash, error) {
+			b, err := aes.NewCipher(key)
+			if err != nil {
+				return nil, err
+			}
+			return NewCMAC(b)
+		}},
+		{"aes_pmac.tjson", func(key []byte) (hash.Hash, error) {
+			b, err := aes.NewCipher(key)
+			if err != nil {
+				return nil, err
+			}
+			return pmac.New(b)
+		}},
+	} {
+		for i, ex := range loadExamples(t, tc.file) {
+			name := exampleName(tc.file, i, ex)
+			key, _ := ex["key"].([]byte)
+			msg, _ := ex["message"].([]byte)
+			tag, _ := ex["tag"].([]byte)
+			h, err := tc.newMAC(key)
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				continue
+			}
+			h.Write(msg)
+			if sum := h.Sum(nil); !bytes.Equal(tag, sum) {
+				t.Errorf("%s: expected: %x\ngot: %x", name, tag, sum)
+			}
+		}
+	}
+}
+
+func TestDblVectors(t *testing.T) {
+	for i, ex := range loadExamples(t, "dbl.tjson") {
+		in, _ := ex["input"].([]byte)
+		want, _ := ex["output"].([]byte)
+		x := append([]byte(nil), in...)
+		dbl(x)
+		if !bytes.Equal(want, x) {
+			t.Errorf("dbl.tjson: example %d: dbl(%x): expected: %x\ngot: %x", i, in, want, x)
+		}
+		var block [16]byte
+		copy(block[:], in)
+		if got := Double(block); !bytes.Equal(want, got[:]) {
+			t.Errorf("dbl.tjson: example %d: Double(%x): expected: %x\ngot: %x", i, in, want, got)
+		}
+	}
+}